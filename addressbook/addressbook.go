@@ -0,0 +1,177 @@
+// Package addressbook keeps a local I2P address book fed by hosts.txt
+// subscriptions, which are fetched over I2P through a StreamSession.
+package addressbook
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/i2pkeys"
+	"github.com/sirupsen/logrus"
+)
+
+// Record is a name of the address book and where it came from.
+type Record struct {
+	Name   string
+	Addr   i2pkeys.I2PAddr
+	Source string
+	Added  time.Time
+}
+
+// Conflict is an entry which was not merged because the address book
+// already has the name with another destination.
+type Conflict struct {
+	Name     string
+	Existing Record
+	Proposed i2pkeys.I2PAddr
+	Source   string
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s from %s is %s, keeping %s from %s", c.Name, c.Source, c.Proposed.Base32(), c.Existing.Addr.Base32(), c.Existing.Source)
+}
+
+// MergeResult reports what Merge changed.
+type MergeResult struct {
+	Added     []string
+	Conflicts []Conflict
+}
+
+// AddressBook maps names to destinations. As in the router's address book,
+// the first registration of a name wins: entries proposing another
+// destination for a known name are reported as conflicts and not merged.
+// An AddressBook is a common.Resolver and is safe for concurrent use.
+type AddressBook struct {
+	mu      sync.RWMutex
+	records map[string]Record
+	// now is time.Now, replaced in tests
+	now func() time.Time
+}
+
+// New returns an empty AddressBook.
+func New() *AddressBook {
+	return &AddressBook{
+		records: make(map[string]Record),
+		now:     time.Now,
+	}
+}
+
+// Open returns an AddressBook holding the entries of the hosts.txt file
+// fname, which may not exist yet.
+func Open(fname string) (*AddressBook, error) {
+	b := New()
+	f, err := os.Open(fname)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to open address book")
+		return nil, err
+	}
+	defer f.Close()
+	entries, err := common.ReadHosts(f)
+	if err != nil {
+		log.WithError(err).WithField("file", fname).Warn("Skipped invalid address book entries")
+	}
+	b.Merge(fname, entries)
+	return b, nil
+}
+
+// Merge adds entries from source, a subscription URL or file name. Entries
+// without a name, such as metadata-only lines, are ignored.
+func (b *AddressBook) Merge(source string, entries []common.HostEntry) MergeResult {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var result MergeResult
+	for _, e := range entries {
+		if e.Name == "" {
+			continue
+		}
+		existing, ok := b.records[e.Name]
+		switch {
+		case !ok:
+			b.records[e.Name] = Record{Name: e.Name, Addr: e.Addr, Source: source, Added: b.now()}
+			result.Added = append(result.Added, e.Name)
+		case existing.Addr != e.Addr:
+			result.Conflicts = append(result.Conflicts, Conflict{Name: e.Name, Existing: existing, Proposed: e.Addr, Source: source})
+		}
+	}
+	log.WithFields(logrus.Fields{
+		"source":    source,
+		"added":     len(result.Added),
+		"conflicts": len(result.Conflicts),
+	}).Debug("Merged entries into address book")
+	return result
+}
+
+// Lookup returns the record of name.
+func (b *AddressBook) Lookup(name string) (Record, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	r, ok := b.records[strings.ToLower(name)]
+	return r, ok
+}
+
+// ResolveContext returns the destination of name, or an error wrapping
+// common.ErrKeyNotFound.
+func (b *AddressBook) ResolveContext(ctx context.Context, name string) (i2pkeys.I2PAddr, error) {
+	if r, ok := b.Lookup(name); ok {
+		return r.Addr, nil
+	}
+	return i2pkeys.I2PAddr(""), fmt.Errorf("%w: %s", common.ErrKeyNotFound, name)
+}
+
+// Records returns the records, sorted by name.
+func (b *AddressBook) Records() []Record {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	records := make([]Record, 0, len(b.records))
+	for _, r := range b.records {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	return records
+}
+
+// Len returns the number of names.
+func (b *AddressBook) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.records)
+}
+
+// WriteTo writes the address book in hosts.txt format, sorted by name.
+func (b *AddressBook) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var n int64
+	for _, r := range b.Records() {
+		m, err := fmt.Fprintf(bw, "%s=%s\n", r.Name, r.Addr.Base64())
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, bw.Flush()
+}
+
+// Save writes the address book to the hosts.txt file fname, atomically.
+func (b *AddressBook) Save(fname string) error {
+	var buf strings.Builder
+	if _, err := b.WriteTo(&buf); err != nil {
+		log.WithError(err).WithField("file", fname).Error("Failed to write address book")
+		return err
+	}
+	if err := common.WriteFileAtomic(fname, []byte(buf.String()), 0o644); err != nil {
+		log.WithError(err).WithField("file", fname).Error("Failed to save address book")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package addressbook
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/go-sam-go/samtest"
+	"github.com/go-i2p/i2pkeys"
+)
+
+// newSigningDestination returns an EdDSA_SHA512_Ed25519 destination and its
+// signing key.
+func newSigningDestination(t *testing.T) (i2pkeys.I2PAddr, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := make([]byte, destKeysLen, destKeysLen+7)
+	rand.Read(dest[:destKeysLen-ed25519.PublicKeySize])
+	copy(dest[destKeysLen-ed25519.PublicKeySize:], pub)
+	dest = append(dest, 5, 0, 4)
+	dest = binary.BigEndian.AppendUint16(dest, 7)
+	dest = binary.BigEndian.AppendUint16(dest, 0)
+	return i2pkeys.I2PAddr(i2pB64enc.EncodeToString(dest)), priv
+}
+
+// signedLine returns a registration line for name signed with key.
+func signedLine(name string, addr i2pkeys.I2PAddr, key ed25519.PrivateKey) string {
+	e := common.HostEntry{Name: name, Addr: addr, Options: map[string]string{"date": "1700000000"}}
+	sig := ed25519.Sign(key, []byte(SignedData(e)))
+	return SignedData(e) + "#sig=" + i2pB64enc.EncodeToString(sig)
+}
+
+func TestVerify(t *testing.T) {
+	addr, key := newSigningDestination(t)
+	_, otherKey := newSigningDestination(t)
+	dsa, _ := samtest.NewDestination()
+	tests := []struct {
+		name    string
+		line    string
+		wantErr error
+	}{
+		{"unsigned", "plain.i2p=" + dsa, nil},
+		{"signed", signedLine("signed.i2p", addr, key), nil},
+		{"signed by another key", signedLine("signed.i2p", addr, otherKey), ErrBadSignature},
+		{"altered", strings.Replace(signedLine("signed.i2p", addr, key), "date=1700000000", "date=1700000001", 1), ErrBadSignature},
+		{"renamed", strings.Replace(signedLine("signed.i2p", addr, key), "signed.i2p", "stolen.i2p", 1), ErrBadSignature},
+		{"unsupported type", "dsa.i2p=" + dsa + "#!date=1#sig=AAAA", ErrUnsupportedSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, ok, err := common.ParseHostsLine(tt.line)
+			if !ok || err != nil {
+				t.Fatalf("ParseHostsLine() = %v, %v", ok, err)
+			}
+			if err := Verify(e); !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddressBook_Merge(t *testing.T) {
+	a, _ := samtest.NewDestination()
+	b, _ := samtest.NewDestination()
+	book := New()
+	first := book.Merge("first", []common.HostEntry{
+		{Name: "a.i2p", Addr: i2pkeys.I2PAddr(a)},
+		{Name: "alias.i2p", Addr: i2pkeys.I2PAddr(a)},
+		{Options: map[string]string{"action": "remove", "name": "a.i2p"}},
+	})
+	if len(first.Added) != 2 || len(first.Conflicts) != 0 {
+		t.Errorf("first Merge() = %+v, want 2 names added", first)
+	}
+	second := book.Merge("second", []common.HostEntry{
+		{Name: "a.i2p", Addr: i2pkeys.I2PAddr(b)},
+		{Name: "alias.i2p", Addr: i2pkeys.I2PAddr(a)},
+		{Name: "b.i2p", Addr: i2pkeys.I2PAddr(b)},
+	})
+	if len(second.Added) != 1 || second.Added[0] != "b.i2p" {
+		t.Errorf("second Merge() added %v, want [b.i2p]", second.Added)
+	}
+	if len(second.Conflicts) != 1 {
+		t.Fatalf("second Merge() conflicts = %v, want a.i2p", second.Conflicts)
+	}
+	c := second.Conflicts[0]
+	if c.Name != "a.i2p" || c.Existing.Source != "first" || string(c.Proposed) != b || c.Source != "second" {
+		t.Errorf("conflict = %+v", c)
+	}
+	if r, ok := book.Lookup("A.i2p"); !ok || string(r.Addr) != a {
+		t.Error("conflicting registration replaced the first one")
+	}
+}
+
+func TestAddressBook_SaveOpen(t *testing.T) {
+	a, _ := samtest.NewDestination()
+	b, _ := samtest.NewDestination()
+	fname := filepath.Join(t.TempDir(), "hosts.txt")
+	if book, err := Open(fname); err != nil || book.Len() != 0 {
+		t.Fatalf("Open() of a missing file = %v, %v, want an empty book", book, err)
+	}
+	book := New()
+	book.Merge("test", []common.HostEntry{{Name: "b.i2p", Addr: i2pkeys.I2PAddr(b)}, {Name: "a.i2p", Addr: i2pkeys.I2PAddr(a)}})
+	if err := book.Save(fname); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, _ := os.ReadFile(fname)
+	if want := "a.i2p=" + a + "\nb.i2p=" + b + "\n"; string(data) != want {
+		t.Errorf("saved %q, want sorted hosts.txt lines", data)
+	}
+	reopened, err := Open(fname)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	addr, err := reopened.ResolveContext(context.Background(), "b.i2p")
+	if err != nil || string(addr) != b {
+		t.Errorf("ResolveContext() = %.16s, %v, want b's destination", addr, err)
+	}
+	if _, err := reopened.ResolveContext(context.Background(), "c.i2p"); !errors.Is(err, common.ErrKeyNotFound) {
+		t.Errorf("ResolveContext() of an unknown name error = %v, want ErrKeyNotFound", err)
+	}
+}
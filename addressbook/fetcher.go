@@ -0,0 +1,256 @@
+package addressbook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/go-sam-go/stream"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultInterval is how often Run fetches the subscriptions, like the
+	// router's address book
+	DefaultInterval = 12 * time.Hour
+	// MaxFeedSize bounds the size of a subscription feed
+	MaxFeedSize = 16 << 20
+)
+
+// Subscription is a hosts.txt feed and the validators of its last fetch,
+// sent back so an unchanged feed is not downloaded again.
+type Subscription struct {
+	URL          string
+	ETag         string
+	LastModified string
+	LastFetched  time.Time
+}
+
+// FetchResult reports what fetching a subscription changed.
+type FetchResult struct {
+	URL string
+	// NotModified is set when the feed did not change since the last fetch
+	NotModified bool
+	MergeResult
+	// Rejected lists the invalid lines and badly signed entries, which were
+	// not merged
+	Rejected []error
+}
+
+// Fetcher keeps an AddressBook up to date with subscriptions. It is safe
+// for concurrent use.
+type Fetcher struct {
+	book     *AddressBook
+	client   *http.Client
+	interval time.Duration
+	onUpdate func(FetchResult)
+
+	mu   sync.Mutex
+	subs []*Subscription
+}
+
+// Option configures a Fetcher.
+type Option func(*Fetcher) error
+
+// WithHTTPClient fetches the feeds with client instead of through the
+// StreamSession, e.g. from a local HTTP server in tests.
+func WithHTTPClient(client *http.Client) Option {
+	return func(f *Fetcher) error {
+		f.client = client
+		return nil
+	}
+}
+
+// WithInterval sets how often Run fetches the subscriptions,
+// DefaultInterval by default.
+func WithInterval(d time.Duration) Option {
+	return func(f *Fetcher) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid interval %s", d)
+		}
+		f.interval = d
+		return nil
+	}
+}
+
+// WithOnUpdate calls fn with the result of every fetch made by Run.
+func WithOnUpdate(fn func(FetchResult)) Option {
+	return func(f *Fetcher) error {
+		f.onUpdate = fn
+		return nil
+	}
+}
+
+// HTTPClient returns an HTTP client whose connections are streams dialed
+// through session. It does not use a proxy.
+func HTTPClient(session *stream.StreamSession) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:       session.DialContext,
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+// NewFetcher returns a Fetcher merging the feeds at urls into book, fetched
+// through session. session may be nil with WithHTTPClient.
+func NewFetcher(session *stream.StreamSession, book *AddressBook, urls []string, opts ...Option) (*Fetcher, error) {
+	f := &Fetcher{
+		book:     book,
+		interval: DefaultInterval,
+	}
+	for _, u := range urls {
+		f.subs = append(f.subs, &Subscription{URL: u})
+	}
+	for _, o := range opts {
+		if err := o(f); err != nil {
+			return nil, err
+		}
+	}
+	if f.client == nil {
+		if session == nil {
+			return nil, errors.New("no stream session to fetch subscriptions through")
+		}
+		f.client = HTTPClient(session)
+	}
+	return f, nil
+}
+
+// Subscriptions returns the state of the subscriptions.
+func (f *Fetcher) Subscriptions() []Subscription {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subs := make([]Subscription, len(f.subs))
+	for i, s := range f.subs {
+		subs[i] = *s
+	}
+	return subs
+}
+
+// Update fetches every subscription once, in order, so earlier
+// subscriptions take precedence for new names. The error joins the
+// failures of the feeds which could not be fetched.
+func (f *Fetcher) Update(ctx context.Context) ([]FetchResult, error) {
+	f.mu.Lock()
+	subs := append([]*Subscription(nil), f.subs...)
+	f.mu.Unlock()
+	var results []FetchResult
+	var errs []error
+	for _, sub := range subs {
+		result, err := f.fetch(ctx, sub)
+		if err != nil {
+			log.WithError(err).WithField("url", sub.URL).Error("Failed to fetch subscription")
+			errs = append(errs, err)
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, errors.Join(errs...)
+}
+
+// Run updates the address book now and then every interval, until ctx is
+// done.
+func (f *Fetcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		results, _ := f.Update(ctx)
+		if f.onUpdate != nil {
+			for _, r := range results {
+				f.onUpdate(r)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetch downloads a feed unless it did not change, and merges it.
+func (f *Fetcher) fetch(ctx context.Context, sub *Subscription) (FetchResult, error) {
+	f.mu.Lock()
+	etag, lastModified := sub.ETag, sub.LastModified
+	f.mu.Unlock()
+	logger := log.WithField("url", sub.URL)
+	logger.Debug("Fetching subscription")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sub.URL, nil)
+	if err != nil {
+		return FetchResult{}, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return FetchResult{}, fmt.Errorf("fetching %s: %w", sub.URL, err)
+	}
+	defer resp.Body.Close()
+
+	result := FetchResult{URL: sub.URL}
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		logger.Debug("Subscription not modified")
+		result.NotModified = true
+		f.fetched(sub, etag, lastModified)
+		return result, nil
+	case http.StatusOK:
+	default:
+		return FetchResult{}, fmt.Errorf("fetching %s: %s", sub.URL, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxFeedSize+1))
+	if err != nil {
+		return FetchResult{}, fmt.Errorf("reading %s: %w", sub.URL, err)
+	}
+	if len(data) > MaxFeedSize {
+		return FetchResult{}, fmt.Errorf("reading %s: feed larger than %d bytes", sub.URL, MaxFeedSize)
+	}
+	entries, err := common.ReadHosts(bytes.NewReader(data))
+	if err != nil && !errors.Is(err, common.ErrInvalidHostsEntry) {
+		return FetchResult{}, fmt.Errorf("reading %s: %w", sub.URL, err)
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		result.Rejected = append(result.Rejected, joined.Unwrap()...)
+	} else if err != nil {
+		result.Rejected = append(result.Rejected, err)
+	}
+	valid := entries[:0]
+	for _, e := range entries {
+		if err := Verify(e); err != nil {
+			result.Rejected = append(result.Rejected, err)
+			continue
+		}
+		valid = append(valid, e)
+	}
+	result.MergeResult = f.book.Merge(sub.URL, valid)
+	for _, c := range result.Conflicts {
+		logger.WithField("conflict", c.String()).Warn("Conflicting registration not merged")
+	}
+	f.fetched(sub, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"))
+	logger.WithFields(logrus.Fields{
+		"added":     len(result.Added),
+		"conflicts": len(result.Conflicts),
+		"rejected":  len(result.Rejected),
+	}).Debug("Fetched subscription")
+	return result, nil
+}
+
+// fetched records the validators of a successful fetch.
+func (f *Fetcher) fetched(sub *Subscription, etag, lastModified string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sub.ETag, sub.LastModified = etag, lastModified
+	sub.LastFetched = time.Now()
+}
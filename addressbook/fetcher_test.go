@@ -0,0 +1,170 @@
+package addressbook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/go-sam-go/samtest"
+	"github.com/go-i2p/go-sam-go/stream"
+)
+
+// feedServer serves feeds by path, with an ETag, answering 304 to a request
+// carrying it.
+func feedServer(t *testing.T, feeds map[string]string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var full atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		feed, ok := feeds[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		etag := `"` + r.URL.Path + `-v1"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Write([]byte(feed))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &full
+}
+
+func TestFetcher_Update(t *testing.T) {
+	a, _ := samtest.NewDestination()
+	b, _ := samtest.NewDestination()
+	signed, key := newSigningDestination(t)
+	_, otherKey := newSigningDestination(t)
+	srv, full := feedServer(t, map[string]string{
+		"/hosts.txt": strings.Join([]string{
+			"# first feed",
+			"a.i2p=" + a,
+			"not a line",
+			signedLine("signed.i2p", signed, key),
+			signedLine("forged.i2p", signed, otherKey),
+		}, "\n"),
+		"/newhosts.txt": "a.i2p=" + b + "\nb.i2p=" + b + "\n",
+	})
+	book := New()
+	f, err := NewFetcher(nil, book, []string{srv.URL + "/hosts.txt", srv.URL + "/newhosts.txt", srv.URL + "/gone.txt"}, WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatalf("NewFetcher() error = %v", err)
+	}
+
+	results, err := f.Update(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Update() error = %v, want the missing feed reported", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Update() returned %d results, want 2", len(results))
+	}
+	first, second := results[0], results[1]
+	if strings.Join(first.Added, " ") != "a.i2p signed.i2p" {
+		t.Errorf("first feed added %v, want a.i2p and signed.i2p", first.Added)
+	}
+	if len(first.Rejected) != 2 || !errors.Is(first.Rejected[0], common.ErrInvalidHostsEntry) || !errors.Is(first.Rejected[1], ErrBadSignature) {
+		t.Errorf("first feed rejected %v, want the invalid line and the forged entry", first.Rejected)
+	}
+	if len(second.Conflicts) != 1 || second.Conflicts[0].Name != "a.i2p" || strings.Join(second.Added, " ") != "b.i2p" {
+		t.Errorf("second feed = %+v, want b.i2p added and a.i2p in conflict", second.MergeResult)
+	}
+	if subs := f.Subscriptions(); subs[0].ETag == "" || subs[0].LastFetched.IsZero() {
+		t.Errorf("subscription state = %+v, want the ETag recorded", subs[0])
+	}
+
+	results, _ = f.Update(context.Background())
+	for _, r := range results {
+		if !r.NotModified || len(r.Added) != 0 {
+			t.Errorf("second Update() of %s = %+v, want not modified", r.URL, r)
+		}
+	}
+	if n := full.Load(); n != 2 {
+		t.Errorf("server sent %d full feeds, want 2", n)
+	}
+	if book.Len() != 3 {
+		t.Errorf("address book has %d names, want 3", book.Len())
+	}
+}
+
+func TestFetcher_Run(t *testing.T) {
+	a, _ := samtest.NewDestination()
+	srv, full := feedServer(t, map[string]string{"/hosts.txt": "a.i2p=" + a + "\n"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var updates atomic.Int32
+	f, err := NewFetcher(nil, New(), []string{srv.URL + "/hosts.txt"},
+		WithHTTPClient(srv.Client()),
+		WithInterval(time.Millisecond),
+		WithOnUpdate(func(r FetchResult) {
+			if updates.Add(1) == 3 {
+				cancel()
+			}
+		}))
+	if err != nil {
+		t.Fatalf("NewFetcher() error = %v", err)
+	}
+	if err := f.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error = %v, want context.Canceled", err)
+	}
+	if full.Load() != 1 {
+		t.Errorf("server sent %d full feeds, want 1 and then not modified", full.Load())
+	}
+}
+
+func TestFetcher_OverStreamSession(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	newSession := func(id string) *stream.StreamSession {
+		sam, err := common.NewSAM(b.Addr())
+		if err != nil {
+			t.Fatalf("NewSAM() error = %v", err)
+		}
+		keys, err := sam.NewKeys()
+		if err != nil {
+			t.Fatalf("NewKeys() error = %v", err)
+		}
+		session, err := (&stream.SAM{SAM: sam}).NewStreamSession(id, keys, nil)
+		if err != nil {
+			t.Fatalf("NewStreamSession() error = %v", err)
+		}
+		t.Cleanup(func() { session.Close() })
+		return session
+	}
+	dest, _ := samtest.NewDestination()
+	server := newSession("feed")
+	listener, err := server.Listen()
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("overlay.i2p=" + dest + "\n"))
+	}))
+	defer listener.Close()
+
+	book := New()
+	f, err := NewFetcher(newSession("subscriber"), book, []string{"http://" + server.Addr().Base32() + "/hosts.txt"})
+	if err != nil {
+		t.Fatalf("NewFetcher() error = %v", err)
+	}
+	if _, err := f.Update(context.Background()); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if r, ok := book.Lookup("overlay.i2p"); !ok || string(r.Addr) != dest {
+		t.Error("feed fetched over I2P was not merged")
+	}
+	if _, err := NewFetcher(nil, book, nil); err == nil {
+		t.Error("NewFetcher() without a session or HTTP client succeeded")
+	}
+}
@@ -0,0 +1,78 @@
+package addressbook
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-i2p/go-sam-go/common"
+)
+
+var (
+	// ErrBadSignature is returned for a registration whose sig does not
+	// verify with the signing key of its destination.
+	ErrBadSignature = errors.New("bad registration signature")
+	// ErrUnsupportedSignature is returned for a registration signed with a
+	// signature type which cannot be verified yet.
+	ErrUnsupportedSignature = errors.New("unsupported registration signature type")
+)
+
+var i2pB64enc = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~")
+
+// destKeysLen is the length of the public keys of a destination, before its
+// certificate. The signing public key ends there, right-aligned.
+const destKeysLen = 384
+
+// SignedData returns the part of a registration line covered by its sig:
+// the name, the destination and the metadata other than sig, sorted by key.
+func SignedData(e common.HostEntry) string {
+	keys := make([]string, 0, len(e.Options))
+	for k := range e.Options {
+		if k != "sig" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(e.Name + "=" + e.Addr.Base64() + "#!")
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte('#')
+		}
+		b.WriteString(k + "=" + e.Options[k])
+	}
+	return b.String()
+}
+
+// Verify checks the sig of a signed registration against the signing key of
+// its destination. Entries without sig are not signed and pass. Only
+// EdDSA_SHA512_Ed25519 signatures can be verified.
+func Verify(e common.HostEntry) error {
+	sig, signed := e.Options["sig"]
+	if !signed {
+		return nil
+	}
+	sigType, _, err := common.DestinationTypes(e.Addr)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrBadSignature, e.Name, err)
+	}
+	if sigType != common.SIG_EdDSA_SHA512_Ed25519 {
+		return fmt.Errorf("%w: %s is signed with %s", ErrUnsupportedSignature, e.Name, sigType)
+	}
+	raw, err := e.Addr.ToBytes()
+	if err != nil || len(raw) < destKeysLen {
+		return fmt.Errorf("%w: %s: invalid destination", ErrBadSignature, e.Name)
+	}
+	pub := ed25519.PublicKey(raw[destKeysLen-ed25519.PublicKeySize : destKeysLen])
+	sigBytes, err := i2pB64enc.DecodeString(sig)
+	if err != nil {
+		sigBytes, err = i2pB64enc.WithPadding(base64.NoPadding).DecodeString(sig)
+	}
+	if err != nil || !ed25519.Verify(pub, []byte(SignedData(e)), sigBytes) {
+		return fmt.Errorf("%w: %s", ErrBadSignature, e.Name)
+	}
+	return nil
+}
@@ -1,13 +1,12 @@
 package common
 
 import (
-	"bufio"
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
-	"strings"
 
 	"github.com/go-i2p/i2pkeys"
 	"github.com/sirupsen/logrus"
@@ -55,11 +54,10 @@ func (sam *SAM) EnsureKeyfile(fname string) (keys i2pkeys.I2PKeys, err error) {
 			if err == nil {
 				sam.SAMEmit.I2PConfig.DestinationKeys = &keys
 				// save keys
-				var f io.WriteCloser
-				f, err = os.OpenFile(fname, os.O_WRONLY|os.O_CREATE, 0o600)
+				var data []byte
+				data, err = encodeKeys(keys)
 				if err == nil {
-					err = i2pkeys.StoreKeysIncompat(keys, f)
-					f.Close()
+					err = WriteFileAtomic(fname, data, 0o600)
 					log.Debug("Generated and saved new keys")
 				}
 			}
@@ -84,64 +82,156 @@ func (sam *SAM) EnsureKeyfile(fname string) (keys i2pkeys.I2PKeys, err error) {
 
 // Creates the I2P-equivalent of an IP address, that is unique and only the one
 // who has the private keys can send messages from. The public keys are the I2P
-// desination (the address) that anyone can send messages to.
-func (sam *SAM) NewKeys(sigType ...string) (i2pkeys.I2PKeys, error) {
+// desination (the address) that anyone can send messages to. Without a
+// sigType, the SAM's SigType is used if set, the router's default otherwise.
+func (sam *SAM) NewKeys(sigType ...SigType) (i2pkeys.I2PKeys, error) {
+	ctx, cancel := sam.ContextWithTimeout()
+	defer cancel()
+	return sam.NewKeysContext(ctx, sigType...)
+}
+
+// NewKeysContext is like NewKeys, but gives up when ctx is done.
+func (sam *SAM) NewKeysContext(ctx context.Context, sigType ...SigType) (i2pkeys.I2PKeys, error) {
 	log.WithField("sigType", sigType).Debug("Generating new keys")
-	sigtmp := ""
-	if len(sigType) > 0 {
-		sigtmp = sigType[0]
+	requested := sam.SigType
+	if len(sigType) > 0 && sigType[0] != SIG_DEFAULT {
+		requested = sigType[0]
+	}
+	sig, err := sam.signatureType(requested)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
 	}
-	if _, err := sam.Conn.Write([]byte("DEST GENERATE " + sigtmp + "\n")); err != nil {
-		log.WithError(err).Error("Failed to write DEST GENERATE command")
-		return i2pkeys.I2PKeys{}, fmt.Errorf("error with writing in SAM: %w", err)
+	cmd := "DEST GENERATE"
+	if sig != SIG_DEFAULT {
+		cmd += " SIGNATURE_TYPE=" + sig.String()
 	}
-	buf := make([]byte, 8192)
-	n, err := sam.Conn.Read(buf)
+	reply, err := sam.CommandContext(ctx, cmd)
 	if err != nil {
-		log.WithError(err).Error("Failed to read SAM response for key generation")
-		return i2pkeys.I2PKeys{}, fmt.Errorf("error with reading in SAM: %w", err)
-	}
-	s := bufio.NewScanner(bytes.NewReader(buf[:n]))
-	s.Split(bufio.ScanWords)
-
-	var pub, priv string
-	for s.Scan() {
-		text := s.Text()
-		if text == "DEST" {
-			continue
-		} else if text == "REPLY" {
-			continue
-		} else if strings.HasPrefix(text, "PUB=") {
-			pub = text[4:]
-		} else if strings.HasPrefix(text, "PRIV=") {
-			priv = text[5:]
-		} else {
-			log.Error("Failed to parse keys from SAM response")
-			return i2pkeys.I2PKeys{}, fmt.Errorf("Failed to parse keys.")
+		log.WithError(err).Error("Failed to generate keys")
+		return i2pkeys.I2PKeys{}, err
+	}
+	if !reply.Is("DEST", "REPLY") {
+		log.WithField("reply", reply.String()).Error("Unexpected reply to DEST GENERATE")
+		return i2pkeys.I2PKeys{}, UnexpectedReply(reply)
+	}
+	if reply.Has("RESULT") {
+		// DEST REPLY only carries a RESULT when generation failed
+		if err := reply.Err(); err != nil {
+			log.WithError(err).Error("Failed to generate keys")
+			return i2pkeys.I2PKeys{}, err
 		}
 	}
+	pub, priv := reply.Get("PUB"), reply.Get("PRIV")
+	if pub == "" || priv == "" {
+		log.WithField("reply", reply.String()).Error("Failed to parse keys from SAM response")
+		return i2pkeys.I2PKeys{}, fmt.Errorf("Failed to parse keys.")
+	}
 	log.Debug("Successfully generated new keys")
 	return i2pkeys.NewKeys(i2pkeys.I2PAddr(pub), priv), nil
 }
 
 // Performs a lookup, probably this order: 1) routers known addresses, cached
-// addresses, 3) by asking peers in the I2P network.
+// addresses, 3) by asking peers in the I2P network. Base64 destinations and
+// malformed .b32.i2p addresses are answered without asking the router, and
+// Hosts is consulted before or after it.
 func (sam *SAM) Lookup(name string) (i2pkeys.I2PAddr, error) {
+	ctx, cancel := sam.ContextWithTimeout()
+	defer cancel()
+	return sam.LookupContext(ctx, name)
+}
+
+// LookupContext is like Lookup, but gives up when ctx is done.
+func (sam *SAM) LookupContext(ctx context.Context, name string) (i2pkeys.I2PAddr, error) {
 	log.WithField("name", name).Debug("Looking up address")
-	return sam.SAMResolver.Resolve(name)
+	if addr, ok, err := localLookup(name); ok {
+		return addr, err
+	}
+	if r := sam.SAMEmit.I2PConfig.Resolver; r != nil {
+		addr, err := r.ResolveContext(ctx, name)
+		if err == nil || !errors.Is(err, ErrKeyNotFound) {
+			return addr, err
+		}
+		log.WithField("name", name).Debug("Name unknown to the configured resolver")
+	}
+	return sam.DefaultResolver().ResolveContext(ctx, name)
+}
+
+// LookupOptions looks name up on the bridge with OPTIONS=true, so the
+// result carries the options the destination publishes in its lease set.
+// It always asks the bridge, bypassing Resolver, Hosts and Cache. Bridges
+// without FeatureLookupOptions fail with ErrUnsupported.
+func (sam *SAM) LookupOptions(name string) (*LookupResult, error) {
+	ctx, cancel := sam.ContextWithTimeout()
+	defer cancel()
+	return sam.LookupOptionsContext(ctx, name)
+}
+
+// LookupOptionsContext is like LookupOptions, but gives up when ctx is done.
+func (sam *SAM) LookupOptionsContext(ctx context.Context, name string) (*LookupResult, error) {
+	if err := sam.Require(FeatureLookupOptions); err != nil {
+		return nil, err
+	}
+	return sam.SAMResolver.lookupContext(ctx, name, true)
+}
+
+// LookupSelf returns the destination of the session running on the control
+// connection (NAME=ME). It fails before a session is created.
+func (sam *SAM) LookupSelf() (*LookupResult, error) {
+	ctx, cancel := sam.ContextWithTimeout()
+	defer cancel()
+	return sam.LookupSelfContext(ctx)
+}
+
+// LookupSelfContext is like LookupSelf, but gives up when ctx is done.
+func (sam *SAM) LookupSelfContext(ctx context.Context) (*LookupResult, error) {
+	return sam.SAMResolver.lookupContext(ctx, "ME", false)
+}
+
+// DefaultResolver returns the resolver Lookup uses for names the configured
+// Resolver does not know: Hosts, before or after Cache, which asks the
+// bridge. It is the building block for a Resolver which should still ask
+// the bridge, as in
+//
+//	common.SuffixResolver{"corp.i2p": corpHosts, "": sam.DefaultResolver()}
+func (sam *SAM) DefaultResolver() Resolver {
+	var bridge Resolver = sam.SAMResolver
+	if sam.Cache != nil {
+		bridge = sam.Cache
+	}
+	switch {
+	case sam.Hosts == nil:
+		return bridge
+	case sam.Hosts.AfterSAM:
+		return ChainResolver{bridge, sam.Hosts}
+	default:
+		return ChainResolver{sam.Hosts, bridge}
+	}
 }
 
 // Creates a new session with the style of either "STREAM", "DATAGRAM" or "RAW",
 // for a new I2P tunnel with name id, using the cypher keys specified, with the
 // I2CP/streaminglib-options as specified. Extra arguments can be specified by
-// setting extra to something else than []string{}.
+// setting extra to something else than []string{}. They override the options
+// derived from the I2PConfig with the same key, see SessionOptions. Keys
+// left empty ask for a transient destination. See SessionBuilder for the
+// other settings.
 // This sam3 instance is now a session
 func (sam *SAM) NewGenericSession(style, id string, keys i2pkeys.I2PKeys, extras []string) (net.Conn, error) {
 	log.WithFields(logrus.Fields{"style": style, "id": id}).Debug("Creating new generic session")
-	return sam.NewGenericSessionWithSignature(style, id, keys, SIG_EdDSA_SHA512_Ed25519, extras)
+	return sam.NewGenericSessionWithSignature(style, id, keys, SIG_DEFAULT, extras)
 }
 
-func (sam *SAM) NewGenericSessionWithSignature(style, id string, keys i2pkeys.I2PKeys, sigType string, extras []string) (net.Conn, error) {
+// NewGenericSessionContext is like NewGenericSession, but gives up when ctx
+// is done. The control connection is closed in that case.
+func (sam *SAM) NewGenericSessionContext(ctx context.Context, style, id string, keys i2pkeys.I2PKeys, extras []string) (net.Conn, error) {
+	log.WithFields(logrus.Fields{"style": style, "id": id}).Debug("Creating new generic session")
+	return sam.NewGenericSessionWithSignatureAndPortsContext(ctx, style, id, "0", "0", keys, SIG_DEFAULT, extras)
+}
+
+// NewGenericSessionWithSignature is like NewGenericSession, but fails with
+// ErrInvalidSigType unless keys are of sigType. SIG_DEFAULT uses the SAM's
+// SigType if set and accepts any keys otherwise.
+func (sam *SAM) NewGenericSessionWithSignature(style, id string, keys i2pkeys.I2PKeys, sigType SigType, extras []string) (net.Conn, error) {
 	log.WithFields(logrus.Fields{"style": style, "id": id, "sigType": sigType}).Debug("Creating new generic session with signature")
 	return sam.NewGenericSessionWithSignatureAndPorts(style, id, "0", "0", keys, sigType, extras)
 }
@@ -151,77 +241,23 @@ func (sam *SAM) NewGenericSessionWithSignature(style, id string, keys i2pkeys.I2
 // I2CP/streaminglib-options as specified. Extra arguments can be specified by
 // setting extra to something else than []string{}.
 // This sam3 instance is now a session
-func (sam *SAM) NewGenericSessionWithSignatureAndPorts(style, id, from, to string, keys i2pkeys.I2PKeys, sigType string, extras []string) (net.Conn, error) {
-	log.WithFields(logrus.Fields{"style": style, "id": id, "from": from, "to": to, "sigType": sigType}).Debug("Creating new generic session with signature and ports")
-
-	optStr := sam.SamOptionsString()
-	extraStr := strings.Join(extras, " ")
-
-	conn := sam.Conn
-	fp := ""
-	tp := ""
-	if from != "0" {
-		fp = " FROM_PORT=" + from
-	}
-	if to != "0" {
-		tp = " TO_PORT=" + to
-	}
-	scmsg := []byte("SESSION CREATE STYLE=" + style + fp + tp + " ID=" + id + " DESTINATION=" + keys.String() + " " + optStr + extraStr + "\n")
-
-	log.WithField("message", string(scmsg)).Debug("Sending SESSION CREATE message")
+func (sam *SAM) NewGenericSessionWithSignatureAndPorts(style, id, from, to string, keys i2pkeys.I2PKeys, sigType SigType, extras []string) (net.Conn, error) {
+	ctx, cancel := sam.ContextWithTimeout()
+	defer cancel()
+	return sam.NewGenericSessionWithSignatureAndPortsContext(ctx, style, id, from, to, keys, sigType, extras)
+}
 
-	for m, i := 0, 0; m != len(scmsg); i++ {
-		if i == 15 {
-			log.Error("Failed to write SESSION CREATE message after 15 attempts")
-			conn.Close()
-			return nil, fmt.Errorf("writing to SAM failed")
-		}
-		n, err := conn.Write(scmsg[m:])
-		if err != nil {
-			log.WithError(err).Error("Failed to write to SAM connection")
-			conn.Close()
-			return nil, fmt.Errorf("writing to connection failed: %w", err)
-		}
-		m += n
-	}
-	buf := make([]byte, 4096)
-	n, err := conn.Read(buf)
-	if err != nil {
-		log.WithError(err).Error("Failed to read SAM response")
-		conn.Close()
-		return nil, fmt.Errorf("reading from connection failed: %w", err)
-	}
-	text := string(buf[:n])
-	log.WithField("response", text).Debug("Received SAM response")
-	if strings.HasPrefix(text, SESSION_OK) {
-		if keys.String() != text[len(SESSION_OK):len(text)-1] {
-			log.Error("SAM created a tunnel with different keys than requested")
-			conn.Close()
-			return nil, fmt.Errorf("SAMv3 created a tunnel with keys other than the ones we asked it for")
-		}
-		log.Debug("Successfully created new session")
-		return conn, nil //&StreamSession{id, conn, keys, nil, sync.RWMutex{}, nil}, nil
-	} else if text == SESSION_DUPLICATE_ID {
-		log.Error("Duplicate tunnel name")
-		conn.Close()
-		return nil, fmt.Errorf("Duplicate tunnel name")
-	} else if text == SESSION_DUPLICATE_DEST {
-		log.Error("Duplicate destination")
-		conn.Close()
-		return nil, fmt.Errorf("Duplicate destination")
-	} else if text == SESSION_INVALID_KEY {
-		log.Error("Invalid key for SAM session")
-		conn.Close()
-		return nil, fmt.Errorf("Invalid key - SAM session")
-	} else if strings.HasPrefix(text, SESSION_I2P_ERROR) {
-		log.WithField("error", text[len(SESSION_I2P_ERROR):]).Error("I2P error")
-		conn.Close()
-		return nil, fmt.Errorf("I2P error " + text[len(SESSION_I2P_ERROR):])
+// NewGenericSessionWithSignatureAndPortsContext is like
+// NewGenericSessionWithSignatureAndPorts, but gives up when ctx is done.
+func (sam *SAM) NewGenericSessionWithSignatureAndPortsContext(ctx context.Context, style, id, from, to string, keys i2pkeys.I2PKeys, sigType SigType, extras []string) (net.Conn, error) {
+	log.WithFields(logrus.Fields{"style": style, "id": id, "from": from, "to": to, "sigType": sigType}).Debug("Creating new generic session with signature and ports")
+	b := sam.NewSessionBuilder(style).ID(id).Ports(from, to).SigType(sigType).Options(extras...)
+	if keys.String() == "" {
+		b.Transient()
 	} else {
-		log.WithField("reply", text).Error("Unable to parse SAMv3 reply")
-		conn.Close()
-		return nil, fmt.Errorf("Unable to parse SAMv3 reply: " + text)
+		b.Keys(keys)
 	}
+	return b.CreateContext(ctx)
 }
 
 // close this sam session
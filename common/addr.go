@@ -0,0 +1,42 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/go-i2p/i2pkeys"
+)
+
+// I2PAddrPort is a destination and one of its virtual ports, as given in
+// FROM_PORT and TO_PORT. It is the net.Addr of the ends of a connection;
+// port 0 stands for any port.
+type I2PAddrPort struct {
+	Addr i2pkeys.I2PAddr
+	Port int
+}
+
+// Network returns "I2P", like i2pkeys.I2PAddr.
+func (a I2PAddrPort) Network() string {
+	return a.Addr.Network()
+}
+
+// String returns the .b32.i2p address of the destination, followed by
+// ":port" unless the port is 0.
+func (a I2PAddrPort) String() string {
+	if a.Port == 0 {
+		return a.Addr.Base32()
+	}
+	return a.Addr.Base32() + ":" + strconv.Itoa(a.Port)
+}
+
+// ParsePort parses a virtual port, "" being port 0.
+func ParsePort(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 0 || port > 65535 {
+		return 0, fmt.Errorf("Invalid port %q", s)
+	}
+	return port, nil
+}
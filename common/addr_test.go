@@ -0,0 +1,53 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/go-i2p/i2pkeys"
+)
+
+func TestParsePort(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"80", 80, false},
+		{"65535", 65535, false},
+		{"65536", 0, true},
+		{"-1", 0, true},
+		{"http", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePort(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParsePort(%q) = %d, %v, want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestI2PAddrPort_String(t *testing.T) {
+	_, sam := newCacheTestSAM(t)
+	keys, err := sam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	addr := keys.Addr()
+	tests := []struct {
+		a    I2PAddrPort
+		want string
+	}{
+		{I2PAddrPort{Addr: addr}, addr.Base32()},
+		{I2PAddrPort{Addr: addr, Port: 80}, addr.Base32() + ":80"},
+	}
+	for _, tt := range tests {
+		if got := tt.a.String(); got != tt.want {
+			t.Errorf("String() = %s, want %s", got, tt.want)
+		}
+		if got := tt.a.Network(); got != i2pkeys.I2PAddr("").Network() {
+			t.Errorf("Network() = %s", got)
+		}
+	}
+}
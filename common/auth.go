@@ -0,0 +1,48 @@
+package common
+
+import "github.com/sirupsen/logrus"
+
+// AuthEnable turns on authentication for new SAM connections. Make sure a
+// user exists first, or nobody will be able to connect afterwards.
+func (sam *SAM) AuthEnable() error {
+	return sam.auth("AUTH ENABLE")
+}
+
+// AuthDisable turns off authentication for new SAM connections.
+func (sam *SAM) AuthDisable() error {
+	return sam.auth("AUTH DISABLE")
+}
+
+// AuthAdd adds a user who may authenticate with HELLO USER/PASSWORD.
+func (sam *SAM) AuthAdd(user, password string) error {
+	return sam.auth("AUTH ADD USER=" + quoteValue(user) + " PASSWORD=" + quoteValue(password))
+}
+
+// AuthRemove removes a user.
+func (sam *SAM) AuthRemove(user string) error {
+	return sam.auth("AUTH REMOVE USER=" + quoteValue(user))
+}
+
+func (sam *SAM) auth(cmd string) error {
+	if err := sam.Require(FeatureAuth); err != nil {
+		return err
+	}
+	reply, err := sam.Command(cmd)
+	if err != nil {
+		log.WithError(err).Error("Failed to send AUTH command")
+		return err
+	}
+	if !reply.Is("AUTH", "STATUS") {
+		log.WithField("reply", reply.String()).Error("Unexpected reply to AUTH command")
+		return UnexpectedReply(reply)
+	}
+	if err := reply.Err(); err != nil {
+		log.WithFields(logrus.Fields{
+			"result":  reply.Result(),
+			"message": reply.Get("MESSAGE"),
+		}).Error("AUTH command failed")
+		return err
+	}
+	log.Debug("AUTH command succeeded")
+	return nil
+}
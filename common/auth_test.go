@@ -0,0 +1,116 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-sam-go/samtest"
+)
+
+func TestNewSAM_Auth(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	b.SetAuth(true)
+	b.AddUser("alice", "s3cret pass")
+
+	tests := []struct {
+		name    string
+		opts    []func(*SAMEmit) error
+		wantErr error
+	}{
+		{"no credentials", nil, ErrAuthFailed},
+		{"wrong password", []func(*SAMEmit) error{SetSAMAuth("alice", "nope")}, ErrAuthFailed},
+		{"unknown user", []func(*SAMEmit) error{SetSAMAuth("bob", "s3cret pass")}, ErrAuthFailed},
+		{"valid credentials", []func(*SAMEmit) error{SetSAMAuth("alice", "s3cret pass")}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sam, err := NewSAM(b.Addr(), tt.opts...)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("NewSAM() error = %v", err)
+				}
+				defer sam.Close()
+				// data connections must authenticate the same way
+				other, err := sam.Redial()
+				if err != nil {
+					t.Fatalf("Redial() error = %v", err)
+				}
+				other.Close()
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("NewSAM() error = %v, want %v", err, tt.wantErr)
+			}
+			var samErr *SAMError
+			if !errors.As(err, &samErr) || samErr.Result != "I2P_ERROR" {
+				t.Errorf("NewSAM() error = %v, want *SAMError with I2P_ERROR", err)
+			}
+		})
+	}
+}
+
+func TestSetSAMAuth_Hello(t *testing.T) {
+	emit, err := NewEmit(SetSAMAuth("alice", `pa"ss word`))
+	if err != nil {
+		t.Fatalf("NewEmit() error = %v", err)
+	}
+	hello := emit.Hello()
+	if !strings.Contains(hello, ` USER=alice PASSWORD="pa\"ss word"`) {
+		t.Errorf("Hello() = %q, want quoted credentials", hello)
+	}
+	if _, err := NewEmit(SetSAMAuth("alice", "")); err == nil {
+		t.Error("SetSAMAuth() with empty password succeeded")
+	}
+}
+
+func TestSAM_AuthCommands(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	admin, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	defer admin.Close()
+
+	if err := admin.AuthAdd("carol", "pw"); err != nil {
+		t.Fatalf("AuthAdd() error = %v", err)
+	}
+	if err := admin.AuthAdd("carol", "pw"); !errors.Is(err, ErrI2PError) {
+		t.Errorf("duplicate AuthAdd() error = %v, want ErrI2PError", err)
+	}
+	if err := admin.AuthEnable(); err != nil {
+		t.Fatalf("AuthEnable() error = %v", err)
+	}
+	if _, err := NewSAM(b.Addr()); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("NewSAM() without credentials error = %v, want ErrAuthFailed", err)
+	}
+	sam, err := NewSAM(b.Addr(), SetSAMAuth("carol", "pw"))
+	if err != nil {
+		t.Fatalf("NewSAM() with credentials error = %v", err)
+	}
+	sam.Close()
+
+	if err := admin.AuthRemove("carol"); err != nil {
+		t.Fatalf("AuthRemove() error = %v", err)
+	}
+	if _, err := NewSAM(b.Addr(), SetSAMAuth("carol", "pw")); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("NewSAM() as removed user error = %v, want ErrAuthFailed", err)
+	}
+	if err := admin.AuthDisable(); err != nil {
+		t.Fatalf("AuthDisable() error = %v", err)
+	}
+	sam, err = NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() after AuthDisable() error = %v", err)
+	}
+	sam.Close()
+}
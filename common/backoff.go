@@ -0,0 +1,55 @@
+package common
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff is the exponential backoff between attempts to create a session
+// again. Zero fields take their value from DefaultBackoff.
+type Backoff struct {
+	// Initial is the delay after the first failed attempt
+	Initial time.Duration
+	// Max caps the delay
+	Max time.Duration
+	// Multiplier grows the delay after each failed attempt
+	Multiplier float64
+}
+
+// DefaultBackoff retries after 1s, 2s, 4s, ... up to once a minute.
+var DefaultBackoff = Backoff{
+	Initial:    time.Second,
+	Max:        time.Minute,
+	Multiplier: 2,
+}
+
+func (b Backoff) withDefaults() Backoff {
+	if b.Initial <= 0 {
+		b.Initial = DefaultBackoff.Initial
+	}
+	if b.Max <= 0 {
+		b.Max = DefaultBackoff.Max
+	}
+	if b.Max < b.Initial {
+		b.Max = b.Initial
+	}
+	if b.Multiplier < 1 {
+		b.Multiplier = DefaultBackoff.Multiplier
+	}
+	return b
+}
+
+// Delay returns how long to wait after the failed attempt number attempt,
+// counting from 0. Up to a quarter of the delay is randomly taken off, so
+// sessions which lost the same bridge do not retry in lockstep.
+func (b Backoff) Delay(attempt int) time.Duration {
+	b = b.withDefaults()
+	d := float64(b.Initial)
+	for i := 0; i < attempt && d < float64(b.Max); i++ {
+		d *= b.Multiplier
+	}
+	if d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	return time.Duration(d - d/4*rand.Float64())
+}
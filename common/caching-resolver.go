@@ -0,0 +1,340 @@
+package common
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/i2pkeys"
+	"github.com/sirupsen/logrus"
+)
+
+// Defaults of a CachingResolver.
+const (
+	DefaultCacheSize        = 1024
+	DefaultCacheTTL         = 10 * time.Minute
+	DefaultCacheNegativeTTL = time.Minute
+	DefaultCacheParallelism = 8
+)
+
+// CachingResolver remembers the answers of another Resolver. Up to size names
+// are kept, the least recently used are dropped first. Resolved names are
+// kept for the TTL, and names the parent does not know (ErrKeyNotFound) for
+// the negative TTL; other failures are not cached. Concurrent lookups of
+// the same name share a single lookup. A CachingResolver is safe for
+// concurrent use.
+type CachingResolver struct {
+	resolver    Resolver
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	parallelism int
+	// now is time.Now, replaced in tests
+	now func() time.Time
+
+	*cacheState
+}
+
+// cacheState holds the entries of a CachingResolver, shared with the
+// resolvers returned by via.
+type cacheState struct {
+	mu sync.Mutex
+	// entries holds *cacheEntry, most recently used first
+	entries  *list.List
+	index    map[string]*list.Element
+	inflight map[string]*lookupCall
+}
+
+type cacheEntry struct {
+	name    string
+	addr    i2pkeys.I2PAddr
+	err     error
+	expires time.Time
+}
+
+// lookupCall is a lookup shared by every caller waiting for the same
+// name. It is canceled once all of them gave up.
+type lookupCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	addr    i2pkeys.I2PAddr
+	err     error
+}
+
+// CacheOption configures a CachingResolver.
+type CacheOption func(*CachingResolver) error
+
+// CacheSize sets the number of names kept, DefaultCacheSize by default.
+func CacheSize(n int) CacheOption {
+	return func(r *CachingResolver) error {
+		if n < 1 {
+			return fmt.Errorf("invalid cache size %d", n)
+		}
+		r.size = n
+		return nil
+	}
+}
+
+// CacheTTL sets how long resolved names are kept, DefaultCacheTTL by
+// default.
+func CacheTTL(d time.Duration) CacheOption {
+	return func(r *CachingResolver) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid cache TTL %s", d)
+		}
+		r.ttl = d
+		return nil
+	}
+}
+
+// CacheNegativeTTL sets how long unknown names are kept,
+// DefaultCacheNegativeTTL by default. Zero disables negative caching.
+func CacheNegativeTTL(d time.Duration) CacheOption {
+	return func(r *CachingResolver) error {
+		if d < 0 {
+			return fmt.Errorf("invalid negative cache TTL %s", d)
+		}
+		r.negativeTTL = d
+		return nil
+	}
+}
+
+// CacheParallelism sets how many lookups ResolveAll runs at once,
+// DefaultCacheParallelism by default.
+func CacheParallelism(n int) CacheOption {
+	return func(r *CachingResolver) error {
+		if n < 1 {
+			return fmt.Errorf("invalid lookup parallelism %d", n)
+		}
+		r.parallelism = n
+		return nil
+	}
+}
+
+// NewCachingResolver returns a CachingResolver asking parent for names
+// which are not cached.
+func NewCachingResolver(parent Resolver, opts ...CacheOption) (*CachingResolver, error) {
+	log.Debug("Creating new CachingResolver")
+	r := &CachingResolver{
+		resolver:    parent,
+		size:        DefaultCacheSize,
+		ttl:         DefaultCacheTTL,
+		negativeTTL: DefaultCacheNegativeTTL,
+		parallelism: DefaultCacheParallelism,
+		now:         time.Now,
+		cacheState: &cacheState{
+			entries:  list.New(),
+			index:    make(map[string]*list.Element),
+			inflight: make(map[string]*lookupCall),
+		},
+	}
+	for _, o := range opts {
+		if err := o(r); err != nil {
+			log.WithError(err).Error("Failed to apply cache option")
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// via returns a CachingResolver sharing the entries and settings of r but
+// asking parent for names which are not cached. It returns nil if r is nil.
+func (r *CachingResolver) via(parent *SAMResolver) *CachingResolver {
+	if r == nil {
+		return nil
+	}
+	shared := *r
+	shared.resolver = parent
+	return &shared
+}
+
+// Resolve is like SAMResolver.Resolve, but answers from the cache when it
+// can.
+func (r *CachingResolver) Resolve(name string) (i2pkeys.I2PAddr, error) {
+	ctx, cancel := resolverContext(r.resolver)
+	defer cancel()
+	return r.ResolveContext(ctx, name)
+}
+
+// ResolveContext is like Resolve, but gives up when ctx is done. The lookup
+// itself goes on as long as another caller waits for the same name.
+func (r *CachingResolver) ResolveContext(ctx context.Context, name string) (i2pkeys.I2PAddr, error) {
+	r.mu.Lock()
+	if e, ok := r.lookupEntry(name); ok {
+		r.mu.Unlock()
+		log.WithField("name", name).Debug("Name resolved from cache")
+		return e.addr, e.err
+	}
+	call, ok := r.inflight[name]
+	if !ok {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &lookupCall{done: make(chan struct{}), cancel: cancel}
+		r.inflight[name] = call
+		go r.lookup(callCtx, name, call)
+	} else {
+		log.WithField("name", name).Debug("Joining lookup in progress")
+	}
+	call.waiters++
+	r.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.addr, call.err
+	case <-ctx.Done():
+		r.mu.Lock()
+		if call.waiters--; call.waiters == 0 {
+			// later callers start a new lookup instead of joining this one
+			call.cancel()
+			if r.inflight[name] == call {
+				delete(r.inflight, name)
+			}
+		}
+		r.mu.Unlock()
+		return i2pkeys.I2PAddr(""), ctx.Err()
+	}
+}
+
+// resolverContext bounds lookups made without a context by the Timeout of
+// the SAM behind r, if there is one.
+func resolverContext(r Resolver) (context.Context, context.CancelFunc) {
+	if s, ok := r.(*SAMResolver); ok && s.SAM != nil {
+		return s.ContextWithTimeout()
+	}
+	return context.WithCancel(context.Background())
+}
+
+// lookup asks the parent for name on behalf of everyone waiting for call.
+func (r *CachingResolver) lookup(ctx context.Context, name string, call *lookupCall) {
+	defer call.cancel()
+	call.addr, call.err = r.resolver.ResolveContext(ctx, name)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.inflight[name] == call {
+		delete(r.inflight, name)
+	}
+	switch {
+	case call.err == nil:
+		r.store(name, call.addr, nil, r.ttl)
+	case errors.Is(call.err, ErrKeyNotFound) && r.negativeTTL > 0:
+		r.store(name, call.addr, call.err, r.negativeTTL)
+	}
+	close(call.done)
+}
+
+// lookupEntry returns the unexpired entry for name and marks it as recently
+// used. r.mu must be held.
+func (r *CachingResolver) lookupEntry(name string) (*cacheEntry, bool) {
+	el, ok := r.index[name]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if !r.now().Before(e.expires) {
+		r.entries.Remove(el)
+		delete(r.index, name)
+		return nil, false
+	}
+	r.entries.MoveToFront(el)
+	return e, true
+}
+
+// store caches the result of a lookup, dropping the least recently used
+// entry if the cache is full. r.mu must be held.
+func (r *CachingResolver) store(name string, addr i2pkeys.I2PAddr, err error, ttl time.Duration) {
+	e := &cacheEntry{name: name, addr: addr, err: err, expires: r.now().Add(ttl)}
+	if el, ok := r.index[name]; ok {
+		el.Value = e
+		r.entries.MoveToFront(el)
+		return
+	}
+	r.index[name] = r.entries.PushFront(e)
+	for r.entries.Len() > r.size {
+		oldest := r.entries.Back()
+		r.entries.Remove(oldest)
+		delete(r.index, oldest.Value.(*cacheEntry).name)
+	}
+}
+
+// ResolveAll resolves names, running up to the configured parallelism of
+// lookups at once. It returns the addresses of the names which resolved,
+// and the failures of the others joined into one error, each wrapped with
+// its name.
+func (r *CachingResolver) ResolveAll(names []string) (map[string]i2pkeys.I2PAddr, error) {
+	ctx, cancel := resolverContext(r.resolver)
+	defer cancel()
+	return r.ResolveAllContext(ctx, names)
+}
+
+// ResolveAllContext is like ResolveAll, but gives up when ctx is done.
+func (r *CachingResolver) ResolveAllContext(ctx context.Context, names []string) (map[string]i2pkeys.I2PAddr, error) {
+	log.WithFields(logrus.Fields{"names": len(names), "parallelism": r.parallelism}).Debug("Resolving names")
+	var unique []string
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+
+	addrs := make([]i2pkeys.I2PAddr, len(unique))
+	errs := make([]error, len(unique))
+	sem := make(chan struct{}, r.parallelism)
+	var wg sync.WaitGroup
+	for i, name := range unique {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				addrs[i], errs[i] = r.ResolveContext(ctx, name)
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+			}
+		}()
+	}
+	wg.Wait()
+
+	result := make(map[string]i2pkeys.I2PAddr, len(unique))
+	var failed []error
+	for i, name := range unique {
+		if errs[i] != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", name, errs[i]))
+			continue
+		}
+		result[name] = addrs[i]
+	}
+	return result, errors.Join(failed...)
+}
+
+// Forget drops name from the cache.
+func (r *CachingResolver) Forget(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if el, ok := r.index[name]; ok {
+		r.entries.Remove(el)
+		delete(r.index, name)
+	}
+}
+
+// Purge empties the cache.
+func (r *CachingResolver) Purge() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries.Init()
+	clear(r.index)
+}
+
+// Len returns the number of cached names, including expired ones which
+// were not looked up since.
+func (r *CachingResolver) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.entries.Len()
+}
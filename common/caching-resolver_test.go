@@ -0,0 +1,264 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/samtest"
+)
+
+// lookups counts the NAMING LOOKUP commands b received for name.
+func lookups(b *samtest.Bridge, name string) int {
+	n := 0
+	for _, cmd := range b.Commands() {
+		if cmd == "NAMING LOOKUP NAME="+name || strings.HasPrefix(cmd, "NAMING LOOKUP NAME="+name+" ") {
+			n++
+		}
+	}
+	return n
+}
+
+func newCacheTestSAM(t *testing.T) (*samtest.Bridge, *SAM) {
+	t.Helper()
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	sam, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	t.Cleanup(func() { sam.Close() })
+	return b, sam
+}
+
+func TestCachingResolver_Expiry(t *testing.T) {
+	tests := []struct {
+		name        string
+		known       bool
+		opts        []CacheOption
+		advance     time.Duration
+		wantLookups int
+	}{
+		{"resolved name is cached", true, nil, 9 * time.Minute, 1},
+		{"resolved name expires", true, nil, 10 * time.Minute, 2},
+		{"custom TTL", true, []CacheOption{CacheTTL(time.Second)}, 2 * time.Second, 2},
+		{"unknown name is cached", false, nil, 59 * time.Second, 1},
+		{"unknown name expires", false, nil, time.Minute, 2},
+		{"negative caching disabled", false, []CacheOption{CacheNegativeTTL(0)}, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, sam := newCacheTestSAM(t)
+			pub, _ := samtest.NewDestination()
+			if tt.known {
+				b.AddName("site.i2p", pub)
+			}
+			r, err := NewCachingResolver(sam.SAMResolver, tt.opts...)
+			if err != nil {
+				t.Fatalf("NewCachingResolver() error = %v", err)
+			}
+			now := time.Now()
+			r.now = func() time.Time { return now }
+
+			for i := 0; i < 2; i++ {
+				addr, err := r.Resolve("site.i2p")
+				if tt.known && (err != nil || string(addr) != pub) {
+					t.Errorf("Resolve() = %.16s, %v, want the destination", addr, err)
+				}
+				if !tt.known && !errors.Is(err, ErrKeyNotFound) {
+					t.Errorf("Resolve() error = %v, want ErrKeyNotFound", err)
+				}
+				now = now.Add(tt.advance)
+			}
+			if got := lookups(b, "site.i2p"); got != tt.wantLookups {
+				t.Errorf("bridge saw %d lookups, want %d", got, tt.wantLookups)
+			}
+		})
+	}
+}
+
+func TestCachingResolver_LRU(t *testing.T) {
+	b, sam := newCacheTestSAM(t)
+	for _, name := range []string{"a.i2p", "b.i2p", "c.i2p"} {
+		pub, _ := samtest.NewDestination()
+		b.AddName(name, pub)
+	}
+	r, err := NewCachingResolver(sam.SAMResolver, CacheSize(2))
+	if err != nil {
+		t.Fatalf("NewCachingResolver() error = %v", err)
+	}
+	// a is used again after b, so b is the one dropped for c
+	for _, name := range []string{"a.i2p", "b.i2p", "a.i2p", "c.i2p", "a.i2p", "b.i2p"} {
+		if _, err := r.Resolve(name); err != nil {
+			t.Fatalf("Resolve(%s) error = %v", name, err)
+		}
+	}
+	want := map[string]int{"a.i2p": 1, "b.i2p": 2, "c.i2p": 1}
+	for name, n := range want {
+		if got := lookups(b, name); got != n {
+			t.Errorf("bridge saw %d lookups of %s, want %d", got, name, n)
+		}
+	}
+	if r.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", r.Len())
+	}
+	r.Forget("b.i2p")
+	r.Resolve("b.i2p")
+	if got := lookups(b, "b.i2p"); got != 3 {
+		t.Errorf("bridge saw %d lookups of b.i2p after Forget, want 3", got)
+	}
+	r.Purge()
+	if r.Len() != 0 {
+		t.Errorf("Len() after Purge = %d, want 0", r.Len())
+	}
+}
+
+func TestCachingResolver_SingleFlight(t *testing.T) {
+	b, sam := newCacheTestSAM(t)
+	pub, _ := samtest.NewDestination()
+	release := make(chan struct{})
+	b.Handle("NAMING LOOKUP", func(req *samtest.Request) string {
+		<-release
+		return "NAMING REPLY RESULT=OK NAME=" + req.Get("NAME", "") + " VALUE=" + pub
+	})
+	r, err := NewCachingResolver(sam.SAMResolver)
+	if err != nil {
+		t.Fatalf("NewCachingResolver() error = %v", err)
+	}
+
+	// a caller giving up does not cancel the lookup of the others
+	ctx, cancel := context.WithCancel(context.Background())
+	quitter := make(chan error)
+	go func() {
+		_, err := r.ResolveContext(ctx, "slow.i2p")
+		quitter <- err
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if addr, err := r.Resolve("slow.i2p"); err != nil || string(addr) != pub {
+				t.Errorf("Resolve() = %.16s, %v, want the destination", addr, err)
+			}
+		}()
+	}
+	waiting := func() int {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if call, ok := r.inflight["slow.i2p"]; ok {
+			return call.waiters
+		}
+		return 0
+	}
+	for waiting() != 11 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-quitter; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled ResolveContext() error = %v, want context.Canceled", err)
+	}
+	close(release)
+	wg.Wait()
+	if got := lookups(b, "slow.i2p"); got != 1 {
+		t.Errorf("bridge saw %d lookups, want 1", got)
+	}
+}
+
+func TestCachingResolver_ResolveAll(t *testing.T) {
+	b, sam := newCacheTestSAM(t)
+	var names []string
+	want := make(map[string]string)
+	for i := 0; i < 12; i++ {
+		pub, _ := samtest.NewDestination()
+		name := fmt.Sprintf("host%d.i2p", i)
+		b.AddName(name, pub)
+		names = append(names, name)
+		want[name] = pub
+	}
+	r, err := NewCachingResolver(sam.SAMResolver, CacheParallelism(3))
+	if err != nil {
+		t.Fatalf("NewCachingResolver() error = %v", err)
+	}
+
+	got, err := r.ResolveAll(append(names, "missing.i2p", names[0]))
+	if !errors.Is(err, ErrKeyNotFound) || !strings.Contains(err.Error(), "missing.i2p") {
+		t.Errorf("ResolveAll() error = %v, want ErrKeyNotFound for missing.i2p", err)
+	}
+	if len(got) != len(want) {
+		t.Errorf("ResolveAll() resolved %d names, want %d", len(got), len(want))
+	}
+	for name, pub := range want {
+		if string(got[name]) != pub {
+			t.Errorf("ResolveAll()[%s] is not its destination", name)
+		}
+	}
+	if n := lookups(b, names[0]); n != 1 {
+		t.Errorf("bridge saw %d lookups of a name given twice, want 1", n)
+	}
+}
+
+func TestNewCachingResolver_InvalidOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  CacheOption
+	}{
+		{"size", CacheSize(0)},
+		{"TTL", CacheTTL(0)},
+		{"negative TTL", CacheNegativeTTL(-time.Second)},
+		{"parallelism", CacheParallelism(0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCachingResolver(&SAMResolver{}, tt.opt); err == nil {
+				t.Error("NewCachingResolver() accepted an invalid option")
+			}
+		})
+	}
+}
+
+func TestCachingResolver_SharedByRedial(t *testing.T) {
+	b, sam := newCacheTestSAM(t)
+	for _, name := range []string{"a.i2p", "b.i2p"} {
+		pub, _ := samtest.NewDestination()
+		b.AddName(name, pub)
+	}
+	if _, err := sam.Lookup("a.i2p"); err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	data, err := sam.Redial()
+	if err != nil {
+		t.Fatalf("Redial() error = %v", err)
+	}
+	defer data.Close()
+	reconnected, err := sam.ReconnectContext(context.Background())
+	if err != nil {
+		t.Fatalf("ReconnectContext() error = %v", err)
+	}
+	defer reconnected.Close()
+	if _, err := data.Lookup("a.i2p"); err != nil {
+		t.Fatalf("Lookup() on the redialed SAM error = %v", err)
+	}
+	if got := lookups(b, "a.i2p"); got != 1 {
+		t.Errorf("bridge saw %d lookups of a.i2p, want 1 answered from the shared cache", got)
+	}
+
+	// the reconnected SAM looks names up on its own connection
+	sam.Close()
+	if _, err := reconnected.Lookup("b.i2p"); err != nil {
+		t.Fatalf("Lookup() after closing the first SAM error = %v", err)
+	}
+	if _, err := data.Lookup("b.i2p"); err != nil || sam.Cache.Len() != 2 {
+		t.Errorf("Lookup() = %v with %d cached names, want b.i2p shared", err, sam.Cache.Len())
+	}
+	if got := lookups(b, "b.i2p"); got != 1 {
+		t.Errorf("bridge saw %d lookups of b.i2p, want 1", got)
+	}
+}
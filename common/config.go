@@ -111,17 +111,17 @@ func (f *I2PConfig) LeaseSetSettings() (string, string, string) {
 	return leaseSetKey, privateKey, privateSigningKey
 }
 
-// FromPort returns the FROM_PORT configuration string for SAM bridges >= 3.1
-// Returns an empty string if SAM version < 3.1 or if fromport is "0"
+// FromPort returns the FROM_PORT configuration string for SAM bridges >= 3.2
+// Returns an empty string if the negotiated SAM version < 3.2 or if fromport is "0"
 func (f *I2PConfig) FromPort() string {
 	// Check SAM version compatibility
-	if f.SamMax == "" || f.samMax() < 3.1 {
-		log.Debug("SAM version < 3.1, FromPort not applicable")
+	if !f.supports(FeaturePorts) {
+		log.Debug("SAM version < 3.2, FromPort not applicable")
 		return ""
 	}
 
 	// Return formatted FROM_PORT if fromport is set
-	if f.Fromport != "0" {
+	if f.Fromport != "0" && f.Fromport != "" {
 		log.WithField("fromPort", f.Fromport).Debug("FromPort set")
 		return fmt.Sprintf(" FROM_PORT=%s ", f.Fromport)
 	}
@@ -130,17 +130,17 @@ func (f *I2PConfig) FromPort() string {
 	return ""
 }
 
-// ToPort returns the TO_PORT configuration string for SAM bridges >= 3.1
-// Returns an empty string if SAM version < 3.1 or if toport is "0"
+// ToPort returns the TO_PORT configuration string for SAM bridges >= 3.2
+// Returns an empty string if the negotiated SAM version < 3.2 or if toport is "0"
 func (f *I2PConfig) ToPort() string {
 	// Check SAM version compatibility
-	if f.samMax() < 3.1 {
-		log.Debug("SAM version < 3.1, ToPort not applicable")
+	if !f.supports(FeaturePorts) {
+		log.Debug("SAM version < 3.2, ToPort not applicable")
 		return ""
 	}
 
 	// Return formatted TO_PORT if toport is set
-	if f.Toport != "0" {
+	if f.Toport != "0" && f.Toport != "" {
 		log.WithField("toPort", f.Toport).Debug("ToPort set")
 		return fmt.Sprintf(" TO_PORT=%s ", f.Toport)
 	}
@@ -163,19 +163,14 @@ func (f *I2PConfig) SessionStyle() string {
 	return " STYLE=STREAM "
 }
 
-// samMax returns the maximum SAM version supported as a float64
-// If parsing fails, returns default value 3.1
-func (f *I2PConfig) samMax() float64 {
-	// Parse SAM max version to integer
-	i, err := strconv.ParseFloat(f.SamMax, 64)
-	if err != nil {
-		log.WithError(err).Warn("Failed to parse SamMax, using default 3.1")
-		return 3.1
+// Credentials returns the USER and PASSWORD arguments for HELLO, or an empty
+// string if no credentials are configured
+func (f *I2PConfig) Credentials() string {
+	if f.User == "" && f.Password == "" {
+		return ""
 	}
-
-	// Log the parsed version and return
-	log.WithField("samMax", i).Debug("SAM max version parsed")
-	return i
+	log.WithField("user", f.User).Debug("Using SAM credentials")
+	return " USER=" + quoteValue(f.User) + " PASSWORD=" + quoteValue(f.Password)
 }
 
 // MinSAM returns the minimum SAM version supported as a string
@@ -190,11 +185,12 @@ func (f *I2PConfig) MinSAM() string {
 }
 
 // MaxSAM returns the maximum SAM version supported as a string
-// If no maximum version is set, returns default value "3.1"
+// If no maximum version is set, returns DEFAULT_SAM_MAX, so bridges which
+// support PING and PONG negotiate a version that allows keepalives
 func (f *I2PConfig) MaxSAM() string {
 	if f.SamMax == "" {
-		log.Debug("Using default MaxSAM: 3.1")
-		return "3.1"
+		log.Debug("Using default MaxSAM: " + DEFAULT_SAM_MAX)
+		return DEFAULT_SAM_MAX
 	}
 	log.WithField("maxSAM", f.SamMax).Debug("MaxSAM set")
 	return f.SamMax
@@ -216,18 +212,23 @@ func (f *I2PConfig) DestinationKey() string {
 }
 
 // SignatureType returns the SIGNATURE_TYPE configuration string for SAM bridges >= 3.1
-// Returns empty string if SAM version < 3.1 or if no signature type is set
+// Returns empty string if the negotiated SAM version < 3.1 or if no signature type is set
 func (f *I2PConfig) SignatureType() string {
 	// Check SAM version compatibility
-	if f.samMax() < 3.1 {
+	if !f.supports(FeatureSignatureType) {
 		log.Debug("SAM version < 3.1, SignatureType not applicable")
 		return ""
 	}
 
 	// Return formatted signature type if set
-	if f.SigType != "" {
-		log.WithField("sigType", f.SigType).Debug("Signature type set")
-		return fmt.Sprintf(" SIGNATURE_TYPE=%s ", f.SigType)
+	if f.SigType != SIG_DEFAULT {
+		sig, err := f.SigType.normalized()
+		if err != nil {
+			log.WithError(err).Error("Ignoring invalid signature type")
+			return ""
+		}
+		log.WithField("sigType", sig).Debug("Signature type set")
+		return fmt.Sprintf(" SIGNATURE_TYPE=%s ", sig)
 	}
 
 	log.Debug("Signature type not set")
@@ -271,8 +272,8 @@ func (f *I2PConfig) Reduce() string {
 		}).Debug("Reduce idle settings applied")
 
 		// Return formatted configuration string using Sprintf
-		return fmt.Sprintf("i2cp.reduceOnIdle=%t"+
-			"i2cp.reduceIdleTime=%d"+
+		return fmt.Sprintf("i2cp.reduceOnIdle=%t "+
+			"i2cp.reduceIdleTime=%d "+
 			"i2cp.reduceQuantity=%d",
 			f.ReduceIdle,
 			f.ReduceIdleTime,
@@ -295,7 +296,7 @@ func (f *I2PConfig) Close() string {
 		}).Debug("Close idle settings applied")
 
 		// Return formatted configuration string using Sprintf
-		return fmt.Sprintf("i2cp.closeOnIdle=%t"+
+		return fmt.Sprintf("i2cp.closeOnIdle=%t "+
 			"i2cp.closeIdleTime=%d",
 			f.CloseIdle,
 			f.CloseIdleTime)
@@ -440,25 +441,19 @@ func (f *I2PConfig) Accesslist() string {
 
 // LeaseSetEncryptionType returns the I2CP lease set encryption type configuration string.
 // If no encryption type is set, returns default value "4,0".
-// Validates that all encryption types are valid integers.
+// Invalid encryption types are replaced by the default, session creation
+// rejects them with ErrInvalidEncType.
 func (f *I2PConfig) LeaseSetEncryptionType() string {
-	// Use default encryption type if none specified
-	if f.LeaseSetEncryption == "" {
-		log.Debug("Using default lease set encryption type: 4,0")
-		return "i2cp.leaseSetEncType=4,0"
-	}
-
-	// Validate each encryption type is a valid integer
-	for _, s := range strings.Split(f.LeaseSetEncryption, ",") {
-		if _, err := strconv.Atoi(s); err != nil {
-			log.WithField("invalidType", s).Panic("Invalid encrypted leaseSet type")
-			// panic("Invalid encrypted leaseSet type: " + s)
-		}
+	types, err := f.leaseSetEncTypes()
+	if err != nil {
+		log.WithError(err).Error("Invalid lease set encryption type, using the default")
+		return "i2cp.leaseSetEncType=" + DEFAULT_LEASESET_ENCS
 	}
 
 	// Log and return the configured encryption type
-	log.WithField("leaseSetEncType", f.LeaseSetEncryption).Debug("Lease set encryption type set")
-	return fmt.Sprintf("i2cp.leaseSetEncType=%s", f.LeaseSetEncryption)
+	encTypes := joinEncTypes(types)
+	log.WithField("leaseSetEncType", encTypes).Debug("Lease set encryption type set")
+	return "i2cp.leaseSetEncType=" + encTypes
 }
 
 func NewConfig(opts ...func(*I2PConfig) error) (*I2PConfig, error) {
@@ -13,15 +13,6 @@ const (
 	SESSION_I2P_ERROR      = "SESSION STATUS RESULT=I2P_ERROR MESSAGE="
 )
 
-const (
-	SIG_NONE                 = "SIGNATURE_TYPE=EdDSA_SHA512_Ed25519"
-	SIG_DSA_SHA1             = "SIGNATURE_TYPE=DSA_SHA1"
-	SIG_ECDSA_SHA256_P256    = "SIGNATURE_TYPE=ECDSA_SHA256_P256"
-	SIG_ECDSA_SHA384_P384    = "SIGNATURE_TYPE=ECDSA_SHA384_P384"
-	SIG_ECDSA_SHA512_P521    = "SIGNATURE_TYPE=ECDSA_SHA512_P521"
-	SIG_EdDSA_SHA512_Ed25519 = "SIGNATURE_TYPE=EdDSA_SHA512_Ed25519"
-)
-
 const (
 	SAM_RESULT_OK            = "RESULT=OK"
 	SAM_RESULT_INVALID_KEY   = "RESULT=INVALID_KEY"
@@ -37,6 +28,8 @@ const (
 	SESSION_STYLE_STREAM   = "STREAM"
 	SESSION_STYLE_DATAGRAM = "DATAGRAM"
 	SESSION_STYLE_RAW      = "RAW"
+	SESSION_STYLE_PRIMARY  = "PRIMARY"
+	SESSION_STYLE_MASTER   = "MASTER"
 )
 
 const (
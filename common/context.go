@@ -0,0 +1,135 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// aLongTimeAgo is a deadline in the past, used to unblock pending reads and
+// writes when a context is cancelled.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// ContextWithTimeout returns the SAM's Context, or context.Background if it
+// is nil, bounded by Timeout if that is set. It is used by the methods which
+// have no Context variant argument.
+func (sam *SAM) ContextWithTimeout() (context.Context, context.CancelFunc) {
+	ctx := sam.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if sam.Timeout > 0 {
+		return context.WithTimeout(ctx, sam.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// watch applies ctx to the control connection until the returned function
+// is called: the connection deadline follows ctx's deadline and cancelling
+// ctx unblocks pending reads and writes. The returned function takes the
+// error of the operation and restores the connection. If ctx ended the
+// operation the connection is left mid-command, so it is closed and ctx's
+// error is returned instead.
+func (sam *SAM) watch(ctx context.Context) func(error) error {
+	conn := sam.Conn
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		conn.SetDeadline(deadline)
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	if ctx.Done() != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case <-ctx.Done():
+				conn.SetDeadline(aLongTimeAgo)
+			case <-stop:
+			}
+		}()
+	}
+	return func(err error) error {
+		close(stop)
+		wg.Wait()
+		ctxErr := ctx.Err()
+		if err != nil && ctxErr == nil && hasDeadline && errors.Is(err, os.ErrDeadlineExceeded) {
+			// the connection deadline fired before the context noticed
+			ctxErr = context.DeadlineExceeded
+		}
+		if err != nil && ctxErr != nil {
+			log.WithError(ctxErr).Debug("SAM operation interrupted, closing connection")
+			conn.Close()
+			return ctxErr
+		}
+		conn.SetDeadline(time.Time{})
+		return err
+	}
+}
+
+// CommandContext is like Command, but gives up when ctx is done. If ctx
+// ends while the command is on a connection without a running session the
+// connection is closed, since the reply can no longer be matched to its
+// command.
+func (sam *SAM) CommandContext(ctx context.Context, cmd string) (*Message, error) {
+	return sam.dispatch(ctx, cmd, nil)
+}
+
+// dispatch sends cmd and returns its reply to the caller, whichever other
+// goroutines use the connection. Until a session runs, commands take turns:
+// each one is written and its reply read before the next is sent. Once the
+// monitor reads the connection, commands are written as they come and the
+// monitor hands replies out in the same order. then, if not nil, is called
+// with the reply before any other command is sent, it is used to start the
+// monitor after SESSION CREATE.
+func (sam *SAM) dispatch(ctx context.Context, cmd string, then func(*Message)) (*Message, error) {
+	log.WithField("command", redacted(strings.TrimSpace(cmd))).Debug("Sending SAM command")
+	m := sam.monitor
+	if m == nil {
+		// a SAM put together by hand, there is nothing to share
+		return sam.direct(ctx, cmd, then)
+	}
+	if !m.owns(sam.Conn) {
+		if err := m.acquire(ctx); err != nil {
+			return nil, err
+		}
+		// the session may have been created while we waited
+		if !m.owns(sam.Conn) {
+			defer m.release()
+			return sam.direct(ctx, cmd, then)
+		}
+		m.release()
+	}
+	// a session is running, its monitor reads the replies
+	reply, err := m.command(ctx, cmd)
+	if err == nil && then != nil {
+		then(reply)
+	}
+	return reply, err
+}
+
+// direct writes cmd and reads its reply from the connection itself.
+func (sam *SAM) direct(ctx context.Context, cmd string, then func(*Message)) (*Message, error) {
+	stop := sam.watch(ctx)
+	reply, err := sam.command(cmd)
+	if err = stop(err); err != nil {
+		return nil, err
+	}
+	if then != nil {
+		then(reply)
+	}
+	return reply, nil
+}
+
+// ReadLineContext is like ReadLine, but gives up when ctx is done.
+func (sam *SAM) ReadLineContext(ctx context.Context) (string, error) {
+	stop := sam.watch(ctx)
+	line, err := sam.ReadLine()
+	if err = stop(err); err != nil {
+		return "", err
+	}
+	return line, nil
+}
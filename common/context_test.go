@@ -0,0 +1,130 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/samtest"
+)
+
+// silentBridge accepts control connections and never answers, like a hung
+// SAM bridge. Accepted connections are sent on the returned channel.
+func silentBridge(t *testing.T) (string, <-chan net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	conns := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			conns <- conn
+		}
+	}()
+	return l.Addr().String(), conns
+}
+
+func TestNewSAMContext_HungBridge(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     func() (context.Context, context.CancelFunc)
+		wantErr error
+	}{
+		{
+			name: "deadline",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 100*time.Millisecond)
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name: "cancel",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(100*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			wantErr: context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, conns := silentBridge(t)
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			start := time.Now()
+			sam, err := NewSAMContext(ctx, addr)
+			if err == nil {
+				sam.Close()
+				t.Fatal("NewSAMContext() succeeded against a silent bridge")
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("NewSAMContext() error = %v, want %v", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("NewSAMContext() took %v to give up", elapsed)
+			}
+
+			// the half-open control connection must have been closed
+			conn := <-conns
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, 64)
+			for {
+				if _, err := conn.Read(buf); err != nil {
+					if errors.Is(err, os.ErrDeadlineExceeded) {
+						t.Error("client did not close the control connection")
+					}
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestCommandContext_AbandonedReply(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	pub, _ := samtest.NewDestination()
+	b.AddName("slow.i2p", pub)
+
+	var slowed atomic.Bool
+	b.Handle("NAMING LOOKUP", func(req *samtest.Request) string {
+		if req.Get("NAME", "") == "slow.i2p" && slowed.CompareAndSwap(false, true) {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return ""
+	})
+
+	sam := newMonitoredSession(t, b, "ctx", DefaultKeepalive, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := sam.LookupContext(ctx, "slow.i2p"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("LookupContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// the late reply to the abandoned lookup must not be taken for this one
+	if _, err := sam.Lookup("missing.i2p"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Lookup() after abandoned command error = %v, want %v", err, ErrKeyNotFound)
+	}
+	select {
+	case <-sam.Done():
+		t.Errorf("Done() closed after an abandoned command: %v", sam.Err())
+	default:
+	}
+}
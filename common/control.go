@@ -0,0 +1,69 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// messages returns the reader for the control connection, creating it on
+// first use or when Conn has been replaced.
+func (sam *SAM) messages() *MessageReader {
+	if sam.reader == nil || sam.reader.src != sam.Conn {
+		sam.reader = NewMessageReader(sam.Conn)
+	}
+	return sam.reader
+}
+
+// ReadMessage reads the next message from the control connection.
+func (sam *SAM) ReadMessage() (*Message, error) {
+	return sam.messages().ReadMessage()
+}
+
+// ReadLine reads the next raw line from the control connection. It is used
+// for lines which are not SAM messages, like the peer destination sent after
+// a STREAM ACCEPT.
+func (sam *SAM) ReadLine() (string, error) {
+	return sam.messages().ReadLine()
+}
+
+// Command writes cmd to the control connection and returns the parsed reply.
+// It honors the SAM's Context and Timeout.
+func (sam *SAM) Command(cmd string) (*Message, error) {
+	ctx, cancel := sam.ContextWithTimeout()
+	defer cancel()
+	return sam.CommandContext(ctx, cmd)
+}
+
+// command writes cmd and reads the reply directly from the connection.
+func (sam *SAM) command(cmd string) (*Message, error) {
+	if !strings.HasSuffix(cmd, "\n") {
+		cmd += "\n"
+	}
+	if _, err := io.WriteString(sam.Conn, cmd); err != nil {
+		return nil, fmt.Errorf("error writing to SAM: %w", err)
+	}
+	reply, err := sam.ReadMessage()
+	if err != nil {
+		return nil, fmt.Errorf("error reading from SAM: %w", err)
+	}
+	return reply, nil
+}
+
+// redacted hides the PASSWORD value, and anything after it, in cmd so the
+// command can be logged.
+func redacted(cmd string) string {
+	i := strings.Index(cmd, "PASSWORD=")
+	if i < 0 {
+		return cmd
+	}
+	return cmd[:i] + "PASSWORD=<redacted>"
+}
+
+// DataConn returns the control connection for use as a data stream, after a
+// successful STREAM CONNECT or STREAM ACCEPT. Bytes which were read ahead
+// together with the reply are returned first.
+func (sam *SAM) DataConn() net.Conn {
+	return sam.messages().Conn()
+}
@@ -0,0 +1,107 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/go-i2p/go-sam-go/samtest"
+)
+
+func TestSAM_ConcurrentCommands(t *testing.T) {
+	tests := []struct {
+		name    string
+		session bool
+	}{
+		{"before SESSION CREATE", false},
+		{"with a running session", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := samtest.NewBridge()
+			if err != nil {
+				t.Fatalf("NewBridge() error = %v", err)
+			}
+			defer b.Close()
+			names := make(map[string]string)
+			for i := 0; i < 20; i++ {
+				pub, _ := samtest.NewDestination()
+				name := fmt.Sprintf("host%d.i2p", i)
+				b.AddName(name, pub)
+				names[name] = pub
+			}
+
+			sam, err := NewSAM(b.Addr())
+			if err != nil {
+				t.Fatalf("NewSAM() error = %v", err)
+			}
+			defer sam.Close()
+			if tt.session {
+				keys, err := sam.NewKeys()
+				if err != nil {
+					t.Fatalf("NewKeys() error = %v", err)
+				}
+				if _, err := sam.NewGenericSession("STREAM", "concurrent", keys, nil); err != nil {
+					t.Fatalf("NewGenericSession() error = %v", err)
+				}
+			}
+
+			var wg sync.WaitGroup
+			for name, want := range names {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					got, err := sam.Lookup(name)
+					if err != nil {
+						t.Errorf("Lookup(%s) error = %v", name, err)
+						return
+					}
+					if string(got) != want {
+						t.Errorf("Lookup(%s) got the reply to another command", name)
+					}
+				}()
+				go func() {
+					defer wg.Done()
+					if _, err := sam.NewKeys(); err != nil {
+						t.Errorf("NewKeys() error = %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestSAM_ConcurrentSessionCreate(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	sam, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	defer sam.Close()
+	keys, err := sam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+
+	// lookups racing the SESSION CREATE must not read its reply, nor read
+	// alongside the monitor once it has started
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := sam.Lookup("missing.i2p"); err == nil {
+				t.Error("Lookup(missing.i2p) succeeded")
+			}
+		}()
+	}
+	if _, err := sam.NewGenericSession("STREAM", "racing", keys, nil); err != nil {
+		t.Errorf("NewGenericSession() error = %v", err)
+	}
+	wg.Wait()
+}
@@ -1,10 +1,13 @@
 package common
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"strconv"
 	"strings"
 
+	"github.com/go-i2p/i2pkeys"
 	"github.com/sirupsen/logrus"
 )
 
@@ -14,21 +17,14 @@ type Option func(*SAMEmit) error
 // SetType sets the type of the forwarder server
 func SetType(s string) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
-		if s == SESSION_STYLE_STREAM {
-			c.Style = s
-			log.WithField("style", s).Debug("Set session style")
-			return nil
-		} else if s == SESSION_STYLE_DATAGRAM {
-			c.Style = s
-			log.WithField("style", s).Debug("Set session style")
-			return nil
-		} else if s == SESSION_STYLE_RAW {
+		switch s {
+		case SESSION_STYLE_STREAM, SESSION_STYLE_DATAGRAM, SESSION_STYLE_RAW, SESSION_STYLE_PRIMARY, SESSION_STYLE_MASTER:
 			c.Style = s
 			log.WithField("style", s).Debug("Set session style")
 			return nil
 		}
 		log.WithField("style", s).Error("Invalid session style")
-		return fmt.Errorf("Invalid session STYLE=%s, must be STREAM, DATAGRAM, or RAW", s)
+		return fmt.Errorf("Invalid session STYLE=%s, must be STREAM, DATAGRAM, RAW, PRIMARY or MASTER", s)
 	}
 }
 
@@ -84,6 +80,99 @@ func SetSAMPort(s string) func(*SAMEmit) error {
 	}
 }
 
+// SetSAMAuth sets the USER and PASSWORD sent in HELLO, for bridges that
+// require authentication
+func SetSAMAuth(user, password string) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if user == "" || password == "" {
+			log.WithField("user", user).Error("Invalid SAM credentials")
+			return fmt.Errorf("Invalid SAM credentials: USER and PASSWORD must both be set")
+		}
+		c.I2PConfig.User = user
+		c.I2PConfig.Password = password
+		log.WithField("user", user).Debug("Set SAM credentials")
+		return nil
+	}
+}
+
+// SetSAMDialer makes every connection to the SAM bridge go through d, for
+// example an SSH tunnel or a SOCKS proxy
+func SetSAMDialer(d Dialer) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if d == nil {
+			log.Error("Invalid SAM dialer")
+			return fmt.Errorf("Invalid SAM dialer: nil")
+		}
+		c.I2PConfig.Dialer = d
+		log.WithField("dialer", fmt.Sprintf("%T", d)).Debug("Set SAM dialer")
+		return nil
+	}
+}
+
+// SetSAMUnixSocket connects to a SAM bridge listening on the Unix socket at
+// path instead of TCP. The address passed to NewSAM is then only used to
+// find the bridge's UDP port for datagram and raw sessions
+func SetSAMUnixSocket(path string) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if path == "" {
+			log.Error("Invalid SAM socket path")
+			return fmt.Errorf("Invalid SAM socket path: empty")
+		}
+		c.I2PConfig.Dialer = unixDialer(path)
+		log.WithField("path", path).Debug("Set SAM Unix socket")
+		return nil
+	}
+}
+
+// SetSAMTLS wraps the connections to the SAM bridge in TLS, for routers
+// running SAM with sam.useSSL=true. config may be nil for the defaults; it is
+// cloned, so later changes to it have no effect
+func SetSAMTLS(config *tls.Config) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if config == nil {
+			config = &tls.Config{}
+		}
+		c.I2PConfig.TLS = config.Clone()
+		log.Debug("Enabled TLS for SAM bridge")
+		return nil
+	}
+}
+
+// SetSAMTLSCA enables TLS and trusts only the PEM encoded certificates in
+// pemCerts to sign the bridge's certificate
+func SetSAMTLSCA(pemCerts []byte) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemCerts) {
+			log.Error("Invalid SAM CA certificates")
+			return fmt.Errorf("Invalid SAM CA certificates: no PEM certificate found")
+		}
+		if c.I2PConfig.TLS == nil {
+			c.I2PConfig.TLS = &tls.Config{}
+		}
+		c.I2PConfig.TLS.RootCAs = pool
+		log.Debug("Set SAM CA certificates")
+		return nil
+	}
+}
+
+// SetSAMTLSFingerprint enables TLS and pins the bridge's certificate to the
+// hex SHA-256 fingerprint of its DER encoding, colons allowed. The Java
+// bridge generates a self-signed certificate, so pinning is usually simpler
+// than a CA. The option may be given several times to allow a rotation
+func SetSAMTLSFingerprint(fingerprint string) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		pin, err := parseFingerprint(fingerprint)
+		if err != nil {
+			log.WithError(err).Error("Invalid SAM certificate fingerprint")
+			return err
+		}
+		c.I2PConfig.tlsPins = append(c.I2PConfig.tlsPins, pin)
+		log.WithField("fingerprint", fingerprint).Debug("Pinned SAM certificate")
+		return nil
+	}
+}
+
 // SetName sets the host of the SAMEmit's SAM bridge
 func SetName(s string) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
@@ -93,6 +182,65 @@ func SetName(s string) func(*SAMEmit) error {
 	}
 }
 
+// SetFromPort sets the FROM_PORT of new sessions, "0" for none
+func SetFromPort(s string) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if _, err := ParsePort(s); err != nil {
+			log.WithField("fromPort", s).Error("Invalid FROM_PORT")
+			return err
+		}
+		c.I2PConfig.Fromport = s
+		log.WithField("fromPort", s).Debug("Set FROM_PORT")
+		return nil
+	}
+}
+
+// SetToPort sets the TO_PORT of new sessions, "0" for none
+func SetToPort(s string) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if _, err := ParsePort(s); err != nil {
+			log.WithField("toPort", s).Error("Invalid TO_PORT")
+			return err
+		}
+		c.I2PConfig.Toport = s
+		log.WithField("toPort", s).Debug("Set TO_PORT")
+		return nil
+	}
+}
+
+// SetKeys sets the keys of new sessions
+func SetKeys(keys i2pkeys.I2PKeys) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if _, _, err := DestinationTypes(keys.Addr()); err != nil || keys.String() == "" {
+			log.Error("Invalid session keys")
+			return fmt.Errorf("%w: invalid session keys", ErrInvalidKey)
+		}
+		c.I2PConfig.DestinationKeys = &keys
+		log.WithField("destination", keys.Addr().Base32()).Debug("Set session keys")
+		return nil
+	}
+}
+
+// SetTransient makes new sessions use DESTINATION=TRANSIENT, a destination
+// the bridge generates for the session
+func SetTransient() func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		c.I2PConfig.DestinationKeys = nil
+		log.Debug("Set transient destination")
+		return nil
+	}
+}
+
+// SetExtras adds options sent with SESSION CREATE, overriding the ones
+// derived from the configuration with the same key
+func SetExtras(opts ...string) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		c.I2PConfig.Extras = MergeOptions(c.I2PConfig.Extras, opts)
+		log.WithField("extras", c.I2PConfig.Extras).Debug("Set session options")
+		return nil
+	}
+}
+
 // SetInLength sets the number of hops inbound
 func SetInLength(u int) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
@@ -237,6 +385,51 @@ func SetLeaseSetPrivateSigningKey(s string) func(*SAMEmit) error {
 	}
 }
 
+// SetResolver sets the Resolver consulted first by lookups of the SAM and
+// of the sessions created from it, including the names dialed
+func SetResolver(r Resolver) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if r == nil {
+			return fmt.Errorf("nil resolver")
+		}
+		c.I2PConfig.Resolver = r
+		log.Debug("Set resolver")
+		return nil
+	}
+}
+
+// SetSigType sets the signature type of keys generated with NewKeys and
+// required of the keys of new sessions
+func SetSigType(t SigType) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		sig, err := t.normalized()
+		if err != nil {
+			return err
+		}
+		c.I2PConfig.SigType = sig
+		log.WithField("sigType", sig).Debug("Set signature type")
+		return nil
+	}
+}
+
+// SetLeaseSetEncType sets the encryption types of the lease set, in order
+// of preference
+func SetLeaseSetEncType(types ...EncType) func(*SAMEmit) error {
+	return func(c *SAMEmit) error {
+		if len(types) == 0 {
+			return fmt.Errorf("%w: no encryption type", ErrInvalidEncType)
+		}
+		for _, t := range types {
+			if err := t.Validate(); err != nil {
+				return err
+			}
+		}
+		c.I2PConfig.LeaseSetEncryption = joinEncTypes(types)
+		log.WithField("leaseSetEncType", c.I2PConfig.LeaseSetEncryption).Debug("Set lease set encryption type")
+		return nil
+	}
+}
+
 // SetMessageReliability sets the host of the SAMEmit's SAM bridge
 func SetMessageReliability(s string) func(*SAMEmit) error {
 	return func(c *SAMEmit) error {
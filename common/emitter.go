@@ -3,17 +3,23 @@ package common
 import (
 	"fmt"
 	"strings"
+
+	"github.com/sirupsen/logrus"
 )
 
 func (e *SAMEmit) SamOptionsString() string {
-	optStr := strings.Join(e.I2PConfig.Print(), " ")
+	optStr := strings.Join(e.I2PConfig.SessionOptions(e.I2PConfig.Extras), " ")
 	log.WithField("optStr", optStr).Debug("Generated option string")
 	return optStr
 }
 
 func (e *SAMEmit) Hello() string {
-	hello := fmt.Sprintf("HELLO VERSION MIN=%s MAX=%s \n", e.I2PConfig.MinSAM(), e.I2PConfig.MaxSAM())
-	log.WithField("hello", hello).Debug("Generated HELLO command")
+	hello := fmt.Sprintf("HELLO VERSION MIN=%s MAX=%s%s \n", e.I2PConfig.MinSAM(), e.I2PConfig.MaxSAM(), e.I2PConfig.Credentials())
+	log.WithFields(logrus.Fields{
+		"min":  e.I2PConfig.MinSAM(),
+		"max":  e.I2PConfig.MaxSAM(),
+		"user": e.I2PConfig.User,
+	}).Debug("Generated HELLO command")
 	return hello
 }
 
@@ -41,24 +47,24 @@ func (e *SAMEmit) LookupBytes(name string) []byte {
 	return []byte(e.Lookup(name))
 }
 
+// Create returns the SESSION CREATE command of the configuration, see
+// SessionBuilder for sending it.
 func (e *SAMEmit) Create() string {
-	create := fmt.Sprintf(
-		//             //1 2 3 4 5 6 7
-		"SESSION CREATE %s%s%s%s%s%s%s \n",
-		e.I2PConfig.SessionStyle(),   // 1
-		e.I2PConfig.FromPort(),       // 2
-		e.I2PConfig.ToPort(),         // 3
-		e.I2PConfig.ID(),             // 4
-		e.I2PConfig.DestinationKey(), // 5
-		e.I2PConfig.SignatureType(),  // 6
-		e.SamOptionsString(),         // 7
-	)
+	create := strings.Join(strings.Fields(strings.Join([]string{
+		"SESSION CREATE",
+		e.I2PConfig.SessionStyle(),
+		e.I2PConfig.FromPort(),
+		e.I2PConfig.ToPort(),
+		e.I2PConfig.ID(),
+		e.I2PConfig.DestinationKey(),
+		e.I2PConfig.SignatureType(),
+		e.SamOptionsString(),
+	}, " ")), " ") + "\n"
 	log.WithField("create", create).Debug("Generated SESSION CREATE command")
 	return create
 }
 
 func (e *SAMEmit) CreateBytes() []byte {
-	fmt.Println("sam command: " + e.Create())
 	return []byte(e.Create())
 }
 
@@ -0,0 +1,164 @@
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/go-i2p/i2pkeys"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ErrDecryptKeys is returned by an EncryptedKeyStore for a file which does
+// not decrypt: the passphrase is wrong, or the file was altered or renamed.
+var ErrDecryptKeys = errors.New("cannot decrypt keys")
+
+// kdfParams are the argon2id parameters a file was encrypted with. They are
+// stored in its header so they can be raised without breaking old files.
+type kdfParams struct {
+	time    uint32
+	memory  uint32 // KiB
+	threads uint8
+}
+
+// defaultKDF follows the second recommendation of RFC 9106.
+var defaultKDF = kdfParams{time: 3, memory: 64 * 1024, threads: 4}
+
+const (
+	encryptedMagic   = "I2PKS"
+	encryptedVersion = 1
+	saltLen          = 16
+	// magic, version, argon2id parameters, salt and nonce
+	encryptedHeaderLen = len(encryptedMagic) + 1 + 4 + 4 + 1 + saltLen + chacha20poly1305.NonceSizeX
+)
+
+// EncryptedKeyStore is a DirKeyStore whose files are encrypted with a key
+// derived from a passphrase, so private keys are never written in
+// plaintext. Each file has its own salt; argon2id derives the key and
+// XChaCha20-Poly1305 encrypts and authenticates the keys together with
+// their name.
+type EncryptedKeyStore struct {
+	dir        *DirKeyStore
+	passphrase []byte
+	kdf        kdfParams
+}
+
+// NewEncryptedKeyStore returns an EncryptedKeyStore in dir, creating the
+// directory if it does not exist.
+func NewEncryptedKeyStore(dir string, passphrase []byte) (*EncryptedKeyStore, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	d, err := NewDirKeyStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedKeyStore{
+		dir:        d,
+		passphrase: bytes.Clone(passphrase),
+		kdf:        defaultKDF,
+	}, nil
+}
+
+func (s *EncryptedKeyStore) Load(name string) (i2pkeys.I2PKeys, error) {
+	data, err := s.dir.read(name)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	plain, err := s.open(name, data)
+	if err != nil {
+		log.WithError(err).WithField("name", name).Error("Failed to decrypt keys")
+		return i2pkeys.I2PKeys{}, err
+	}
+	return decodeKeys(plain)
+}
+
+func (s *EncryptedKeyStore) Save(name string, keys i2pkeys.I2PKeys) error {
+	if err := checkKeyName(name); err != nil {
+		return err
+	}
+	plain, err := encodeKeys(keys)
+	if err != nil {
+		return err
+	}
+	data, err := s.seal(name, plain)
+	if err != nil {
+		return err
+	}
+	return s.dir.write(name, data)
+}
+
+// List returns the names of the stored keys, sorted.
+func (s *EncryptedKeyStore) List() ([]string, error) {
+	return s.dir.List()
+}
+
+func (s *EncryptedKeyStore) Delete(name string) error {
+	return s.dir.Delete(name)
+}
+
+func (s *EncryptedKeyStore) seal(name string, plain []byte) ([]byte, error) {
+	header := make([]byte, 0, encryptedHeaderLen)
+	header = append(header, encryptedMagic...)
+	header = append(header, encryptedVersion)
+	header = binary.BigEndian.AppendUint32(header, s.kdf.time)
+	header = binary.BigEndian.AppendUint32(header, s.kdf.memory)
+	header = append(header, s.kdf.threads)
+	random := make([]byte, saltLen+chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	header = append(header, random...)
+	salt, nonce := random[:saltLen], random[saltLen:]
+
+	aead, err := chacha20poly1305.NewX(s.key(salt, s.kdf))
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, nonce, plain, associatedData(header, name)), nil
+}
+
+func (s *EncryptedKeyStore) open(name string, data []byte) ([]byte, error) {
+	if len(data) < encryptedHeaderLen || string(data[:len(encryptedMagic)]) != encryptedMagic {
+		return nil, fmt.Errorf("%w: not an encrypted key file", ErrDecryptKeys)
+	}
+	rest := data[len(encryptedMagic):]
+	if rest[0] != encryptedVersion {
+		return nil, fmt.Errorf("%w: unknown version %d", ErrDecryptKeys, rest[0])
+	}
+	kdf := kdfParams{
+		time:    binary.BigEndian.Uint32(rest[1:5]),
+		memory:  binary.BigEndian.Uint32(rest[5:9]),
+		threads: rest[9],
+	}
+	// bounded so a crafted file cannot make Load run for hours
+	if kdf.time == 0 || kdf.time > 64 || kdf.threads == 0 || kdf.memory < 8*uint32(kdf.threads) || kdf.memory > 4*1024*1024 {
+		return nil, fmt.Errorf("%w: invalid key derivation parameters", ErrDecryptKeys)
+	}
+	salt := rest[10 : 10+saltLen]
+	nonce := rest[10+saltLen : 10+saltLen+chacha20poly1305.NonceSizeX]
+	header, ciphertext := data[:encryptedHeaderLen], data[encryptedHeaderLen:]
+
+	aead, err := chacha20poly1305.NewX(s.key(salt, kdf))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, nonce, ciphertext, associatedData(header, name))
+	if err != nil {
+		return nil, ErrDecryptKeys
+	}
+	return plain, nil
+}
+
+func (s *EncryptedKeyStore) key(salt []byte, kdf kdfParams) []byte {
+	return argon2.IDKey(s.passphrase, salt, kdf.time, kdf.memory, kdf.threads, chacha20poly1305.KeySize)
+}
+
+// associatedData binds the ciphertext to its header and name, so a file
+// moved to another name does not decrypt.
+func associatedData(header []byte, name string) []byte {
+	return append(bytes.Clone(header), name...)
+}
@@ -0,0 +1,118 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors for the RESULT codes defined by SAMv3. A *SAMError unwraps to one of
+// these, so callers can check for a specific failure with errors.Is:
+//
+//	if errors.Is(err, common.ErrCantReachPeer) { ... }
+var (
+	ErrCantReachPeer     = errors.New("can't reach peer")
+	ErrDuplicatedID      = errors.New("duplicated session ID")
+	ErrDuplicatedDest    = errors.New("duplicated destination")
+	ErrI2PError          = errors.New("I2P error")
+	ErrInvalidKey        = errors.New("invalid key")
+	ErrInvalidID         = errors.New("invalid session ID")
+	ErrKeyNotFound       = errors.New("key not found")
+	ErrPeerNotFound      = errors.New("peer not found")
+	ErrLeasesetNotFound  = errors.New("leaseset not found")
+	ErrTimeout           = errors.New("timeout")
+	ErrNoVersion         = errors.New("no common SAM version")
+	ErrAlreadyAccepting  = errors.New("already accepting")
+	ErrUnexpectedReply   = errors.New("unexpected SAM reply")
+	ErrAuthFailed        = errors.New("SAM authentication failed")
+	ErrKeepaliveTimeout  = errors.New("SAM bridge did not answer PING")
+	ErrConnectionLost    = errors.New("SAM control connection lost")
+	ErrUnsupported       = errors.New("not supported by SAM bridge")
+	ErrInvalidSigType    = errors.New("invalid signature type")
+	ErrInvalidEncType    = errors.New("invalid encryption type")
+	ErrUnknownResultCode = errors.New("unknown SAM result")
+)
+
+var resultErrors = map[string]error{
+	"CANT_REACH_PEER":    ErrCantReachPeer,
+	"DUPLICATED_ID":      ErrDuplicatedID,
+	"DUPLICATED_DEST":    ErrDuplicatedDest,
+	"I2P_ERROR":          ErrI2PError,
+	"INVALID_KEY":        ErrInvalidKey,
+	"INVALID_ID":         ErrInvalidID,
+	"KEY_NOT_FOUND":      ErrKeyNotFound,
+	"PEER_NOT_FOUND":     ErrPeerNotFound,
+	"LEASESET_NOT_FOUND": ErrLeasesetNotFound,
+	"TIMEOUT":            ErrTimeout,
+	"NOVERSION":          ErrNoVersion,
+	"ALREADY_ACCEPTING":  ErrAlreadyAccepting,
+}
+
+// SAMError is a non-OK reply from the SAM bridge. Verb is the verb of the
+// reply (SESSION, STREAM, NAMING, ...), Result its RESULT code and Message
+// the optional human readable MESSAGE.
+type SAMError struct {
+	Verb    string
+	Result  string
+	Message string
+}
+
+func (e *SAMError) Error() string {
+	s := "SAM " + e.Verb + " failed: " + e.Result
+	if e.Message != "" {
+		s += " (" + e.Message + ")"
+	}
+	return s
+}
+
+// Unwrap returns the sentinel error for the RESULT code, or
+// ErrUnknownResultCode.
+func (e *SAMError) Unwrap() error {
+	if err, ok := resultErrors[e.Result]; ok {
+		return err
+	}
+	return ErrUnknownResultCode
+}
+
+// Timeout reports whether the bridge gave up waiting, so a *SAMError
+// satisfies net.Error.
+func (e *SAMError) Timeout() bool {
+	return e.Result == "TIMEOUT"
+}
+
+// Temporary reports whether retrying the same command may succeed. Peers
+// that could not be reached or looked up, timeouts and generic router
+// errors are temporary; bad keys, duplicated IDs and version mismatches
+// are not.
+func (e *SAMError) Temporary() bool {
+	switch e.Result {
+	case "CANT_REACH_PEER", "PEER_NOT_FOUND", "LEASESET_NOT_FOUND", "TIMEOUT", "I2P_ERROR":
+		return true
+	}
+	return false
+}
+
+// IsTemporary reports whether err, or any error it wraps, is a temporary
+// SAM failure.
+func IsTemporary(err error) bool {
+	var samErr *SAMError
+	return errors.As(err, &samErr) && samErr.Temporary()
+}
+
+// Err returns nil for an OK reply and a *SAMError otherwise.
+func (m *Message) Err() error {
+	if m.OK() {
+		return nil
+	}
+	return &SAMError{
+		Verb:    m.Verb,
+		Result:  m.Result(),
+		Message: m.Get("MESSAGE"),
+	}
+}
+
+// UnexpectedReply wraps ErrUnexpectedReply with the offending reply. It is
+// returned when the bridge answers with a different verb than the command
+// calls for.
+func UnexpectedReply(reply *Message) error {
+	return fmt.Errorf("%w: %s", ErrUnexpectedReply, reply)
+}
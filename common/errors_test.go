@@ -0,0 +1,109 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/go-i2p/go-sam-go/samtest"
+)
+
+func TestMessageErr_Cases(t *testing.T) {
+	tests := []struct {
+		name          string
+		line          string
+		wantIs        error
+		wantTemporary bool
+		wantTimeout   bool
+	}{
+		{"cant reach peer", "STREAM STATUS RESULT=CANT_REACH_PEER", ErrCantReachPeer, true, false},
+		{"timeout", "STREAM STATUS RESULT=TIMEOUT", ErrTimeout, true, true},
+		{"i2p error", `SESSION STATUS RESULT=I2P_ERROR MESSAGE="no tunnels"`, ErrI2PError, true, false},
+		{"duplicated id", "SESSION STATUS RESULT=DUPLICATED_ID", ErrDuplicatedID, false, false},
+		{"invalid key", "NAMING REPLY RESULT=INVALID_KEY NAME=x", ErrInvalidKey, false, false},
+		{"key not found", "NAMING REPLY RESULT=KEY_NOT_FOUND NAME=x", ErrKeyNotFound, false, false},
+		{"no version", "HELLO REPLY RESULT=NOVERSION", ErrNoVersion, false, false},
+		{"unknown result", "STREAM STATUS RESULT=SOMETHING_NEW", ErrUnknownResultCode, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseMessage(tt.line)
+			if err != nil {
+				t.Fatalf("ParseMessage() error = %v", err)
+			}
+			err = m.Err()
+			if !errors.Is(err, tt.wantIs) {
+				t.Errorf("Err() = %v, want errors.Is %v", err, tt.wantIs)
+			}
+			var samErr *SAMError
+			if !errors.As(err, &samErr) {
+				t.Fatalf("Err() = %T, want *SAMError", err)
+			}
+			if samErr.Verb != m.Verb || samErr.Result != m.Result() || samErr.Message != m.Get("MESSAGE") {
+				t.Errorf("SAMError = %+v, does not match %q", samErr, tt.line)
+			}
+			if got := IsTemporary(err); got != tt.wantTemporary {
+				t.Errorf("IsTemporary() = %v, want %v", got, tt.wantTemporary)
+			}
+			var netErr net.Error
+			if !errors.As(err, &netErr) || netErr.Timeout() != tt.wantTimeout {
+				t.Errorf("net.Error Timeout() mismatch for %q", tt.line)
+			}
+		})
+	}
+}
+
+func TestMessageErr_OK(t *testing.T) {
+	m, err := ParseMessage("SESSION STATUS RESULT=OK DESTINATION=abc")
+	if err != nil {
+		t.Fatalf("ParseMessage() error = %v", err)
+	}
+	if err := m.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil", err)
+	}
+}
+
+func TestSAMErrors_FromBridge(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	sam, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	defer sam.Close()
+	keys, err := sam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	if _, err := sam.NewGenericSession("STREAM", "dup", keys, nil); err != nil {
+		t.Fatalf("NewGenericSession() error = %v", err)
+	}
+
+	other, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	defer other.Close()
+	if _, err := other.NewGenericSession("STREAM", "dup", keys, nil); !errors.Is(err, ErrDuplicatedID) {
+		t.Errorf("duplicate NewGenericSession() error = %v, want ErrDuplicatedID", err)
+	}
+
+	resolver, err := NewFullSAMResolver(b.Addr())
+	if err != nil {
+		t.Fatalf("NewFullSAMResolver() error = %v", err)
+	}
+	defer resolver.Close()
+	if _, err := resolver.Resolve("nowhere.i2p"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Resolve() error = %v, want ErrKeyNotFound", err)
+	}
+
+	b.SetVersion("2.0")
+	if _, err := NewSAM(b.Addr()); !errors.Is(err, ErrNoVersion) {
+		t.Errorf("NewSAM() against 2.0 bridge error = %v, want ErrNoVersion", err)
+	}
+}
@@ -0,0 +1,319 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-i2p/i2pkeys"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrInvalidHostsEntry is returned for a line of a hosts.txt file which is
+// not a valid name=destination entry.
+var ErrInvalidHostsEntry = errors.New("invalid hosts entry")
+
+// HostEntry is a line of a hosts.txt file: a name, its destination and the
+// metadata following "#!", as in
+//
+//	example.i2p=<base64 destination>#!date=1700000000#sig=...
+//
+// Lines holding metadata only, such as "#!action=remove#name=example.i2p",
+// give an entry with an empty Name and Addr.
+type HostEntry struct {
+	Name    string
+	Addr    i2pkeys.I2PAddr
+	Options map[string]string
+}
+
+// ParseHostsLine parses a line of a hosts.txt file. ok is false for blank
+// lines and comments.
+func ParseHostsLine(line string) (entry HostEntry, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return HostEntry{}, false, nil
+	}
+	if meta, found := strings.CutPrefix(line, "#!"); found {
+		entry.Options, err = parseHostsOptions(meta)
+		return entry, err == nil, err
+	}
+	if strings.HasPrefix(line, "#") {
+		return HostEntry{}, false, nil
+	}
+	registration, meta, hasMeta := strings.Cut(line, "#!")
+	name, dest, found := strings.Cut(registration, "=")
+	if !found {
+		return HostEntry{}, false, fmt.Errorf("%w: missing '='", ErrInvalidHostsEntry)
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	if err := checkHostname(name); err != nil {
+		return HostEntry{}, false, err
+	}
+	addr, err := i2pkeys.NewI2PAddrFromString(strings.TrimSpace(dest))
+	if err != nil {
+		return HostEntry{}, false, fmt.Errorf("%w: %s: %w", ErrInvalidHostsEntry, name, err)
+	}
+	entry = HostEntry{Name: name, Addr: addr}
+	if hasMeta {
+		if entry.Options, err = parseHostsOptions(meta); err != nil {
+			return HostEntry{}, false, err
+		}
+	}
+	return entry, true, nil
+}
+
+// parseHostsOptions parses the key=value#key=value metadata of a line.
+func parseHostsOptions(meta string) (map[string]string, error) {
+	opts := make(map[string]string)
+	for _, kv := range strings.Split(meta, "#") {
+		k, v, found := strings.Cut(kv, "=")
+		if !found || k == "" {
+			return nil, fmt.Errorf("%w: invalid metadata %q", ErrInvalidHostsEntry, kv)
+		}
+		opts[k] = v
+	}
+	return opts, nil
+}
+
+// checkHostname accepts lower case names ending in .i2p, made of labels of
+// letters, digits and dashes, which are not .b32.i2p addresses.
+func checkHostname(name string) error {
+	if len(name) > 67 || !strings.HasSuffix(name, ".i2p") || strings.HasSuffix(name, ".b32.i2p") {
+		return fmt.Errorf("%w: invalid hostname %q", ErrInvalidHostsEntry, name)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("%w: invalid hostname %q", ErrInvalidHostsEntry, name)
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return fmt.Errorf("%w: invalid hostname %q", ErrInvalidHostsEntry, name)
+			}
+		}
+	}
+	return nil
+}
+
+// ReadHosts reads the entries of a hosts.txt file. Invalid lines are
+// skipped; the returned error, if not nil, lists them by line number.
+func ReadHosts(r io.Reader) ([]HostEntry, error) {
+	var entries []HostEntry
+	var invalid []error
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 64*1024)
+	for n := 1; scanner.Scan(); n++ {
+		entry, ok, err := ParseHostsLine(scanner.Text())
+		if err != nil {
+			invalid = append(invalid, fmt.Errorf("line %d: %w", n, err))
+			continue
+		}
+		if ok {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, err
+	}
+	return entries, errors.Join(invalid...)
+}
+
+// b32Hash decodes a .b32.i2p address, without asking the router.
+func b32Hash(name string) (i2pkeys.I2PDestHash, error) {
+	hash, err := i2pkeys.DestHashFromString(strings.ToLower(name))
+	if err != nil {
+		return hash, fmt.Errorf("%w: invalid .b32.i2p address %q", ErrInvalidKey, name)
+	}
+	return hash, nil
+}
+
+// localLookup answers the names that need no address book: a base64
+// destination is its own address, and a malformed .b32.i2p address fails
+// without a round trip. ok is false for names to look up.
+func localLookup(name string) (addr i2pkeys.I2PAddr, ok bool, err error) {
+	if strings.HasSuffix(strings.ToLower(name), ".b32.i2p") {
+		if _, err := b32Hash(name); err != nil {
+			return i2pkeys.I2PAddr(""), true, err
+		}
+		return i2pkeys.I2PAddr(""), false, nil
+	}
+	if strings.HasSuffix(name, ".i2p") {
+		return i2pkeys.I2PAddr(""), false, nil
+	}
+	if addr, err := i2pkeys.NewI2PAddrFromString(name); err == nil {
+		return addr, true, nil
+	}
+	return i2pkeys.I2PAddr(""), false, nil
+}
+
+// HostsResolver resolves names from hosts.txt files, without asking the
+// router. A .b32.i2p address resolves to a destination of the files with
+// that hash. Set as a SAM's Hosts, it is consulted before the router's
+// address book, or after it with AfterSAM. A HostsResolver is safe for
+// concurrent use.
+type HostsResolver struct {
+	// AfterSAM consults the hosts only for names the router does not know
+	AfterSAM bool
+
+	mu    sync.RWMutex
+	names map[string]i2pkeys.I2PAddr
+	// byHash holds the names of each destination in the order they were
+	// added, the first one is reported by ReverseLookup
+	byHash map[i2pkeys.I2PDestHash][]HostEntry
+}
+
+// NewHostsResolver returns a HostsResolver holding the entries of files,
+// see LoadFile.
+func NewHostsResolver(files ...string) (*HostsResolver, error) {
+	h := &HostsResolver{
+		names:  make(map[string]i2pkeys.I2PAddr),
+		byHash: make(map[i2pkeys.I2PDestHash][]HostEntry),
+	}
+	for _, fname := range files {
+		if err := h.LoadFile(fname); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// LoadFile adds the entries of a hosts.txt file, see Load.
+func (h *HostsResolver) LoadFile(fname string) error {
+	log.WithField("file", fname).Debug("Loading hosts file")
+	f, err := os.Open(fname)
+	if err != nil {
+		log.WithError(err).Error("Failed to open hosts file")
+		return err
+	}
+	defer f.Close()
+	return h.Load(f)
+}
+
+// Load adds the entries read from r. As in the router's address book, a
+// name already known keeps its destination. Invalid lines are skipped and
+// logged.
+func (h *HostsResolver) Load(r io.Reader) error {
+	entries, err := ReadHosts(r)
+	if err != nil && !errors.Is(err, ErrInvalidHostsEntry) {
+		return err
+	}
+	if err != nil {
+		log.WithError(err).Warn("Skipped invalid hosts entries")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	added := 0
+	for _, e := range entries {
+		if e.Name == "" {
+			continue
+		}
+		if _, ok := h.names[e.Name]; ok {
+			continue
+		}
+		h.add(e)
+		added++
+	}
+	log.WithFields(logrus.Fields{"entries": len(entries), "added": added}).Debug("Loaded hosts")
+	return nil
+}
+
+// Add sets the destination of name, replacing the one it had.
+func (h *HostsResolver) Add(name string, addr i2pkeys.I2PAddr) error {
+	name = strings.ToLower(name)
+	if err := checkHostname(name); err != nil {
+		return err
+	}
+	if _, err := i2pkeys.NewI2PAddrFromString(string(addr)); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidHostsEntry, name, err)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if old, ok := h.names[name]; ok {
+		if old == addr {
+			return nil
+		}
+		h.remove(name, old)
+	}
+	h.add(HostEntry{Name: name, Addr: addr})
+	return nil
+}
+
+// add records e. h.mu must be held.
+func (h *HostsResolver) add(e HostEntry) {
+	h.names[e.Name] = e.Addr
+	hash := e.Addr.DestHash()
+	h.byHash[hash] = append(h.byHash[hash], e)
+}
+
+// remove forgets name, whose destination is addr. The other names of addr
+// are kept, so the next one is reported by Hostname. h.mu must be held.
+func (h *HostsResolver) remove(name string, addr i2pkeys.I2PAddr) {
+	delete(h.names, name)
+	hash := addr.DestHash()
+	entries := slices.DeleteFunc(h.byHash[hash], func(e HostEntry) bool {
+		return e.Name == name
+	})
+	if len(entries) == 0 {
+		delete(h.byHash, hash)
+		return
+	}
+	h.byHash[hash] = entries
+}
+
+// Resolve returns the destination of name, or an error wrapping
+// ErrKeyNotFound if the files do not have it.
+func (h *HostsResolver) Resolve(name string) (i2pkeys.I2PAddr, error) {
+	return h.ResolveContext(context.Background(), name)
+}
+
+// ResolveContext is like Resolve. It never blocks, ctx is for symmetry with
+// the other resolvers.
+func (h *HostsResolver) ResolveContext(ctx context.Context, name string) (i2pkeys.I2PAddr, error) {
+	if addr, ok, err := localLookup(name); ok {
+		return addr, err
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	lower := strings.ToLower(name)
+	if strings.HasSuffix(lower, ".b32.i2p") {
+		hash, _ := b32Hash(lower)
+		if entries, ok := h.byHash[hash]; ok {
+			return entries[0].Addr, nil
+		}
+	} else if addr, ok := h.names[lower]; ok {
+		return addr, nil
+	}
+	return i2pkeys.I2PAddr(""), fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+}
+
+// ReverseLookup returns the name of addr in the files, if any.
+func (h *HostsResolver) ReverseLookup(addr i2pkeys.I2PAddr) (string, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	entries, ok := h.byHash[addr.DestHash()]
+	if !ok {
+		return "", false
+	}
+	return entries[0].Name, true
+}
+
+// Hostname returns the name of addr in the files, or its .b32.i2p address
+// if it has none. It is meant for logging.
+func (h *HostsResolver) Hostname(addr i2pkeys.I2PAddr) string {
+	if name, ok := h.ReverseLookup(addr); ok {
+		return name
+	}
+	return addr.Base32()
+}
+
+// Len returns the number of names known.
+func (h *HostsResolver) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.names)
+}
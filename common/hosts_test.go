@@ -0,0 +1,185 @@
+package common
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-sam-go/samtest"
+	"github.com/go-i2p/i2pkeys"
+)
+
+func TestParseHostsLine(t *testing.T) {
+	pub, _ := samtest.NewDestination()
+	tests := []struct {
+		name    string
+		line    string
+		wantOK  bool
+		wantErr bool
+		want    HostEntry
+	}{
+		{"entry", "example.i2p=" + pub, true, false, HostEntry{Name: "example.i2p", Addr: i2pkeys.I2PAddr(pub)}},
+		{"upper case name", "  Example.I2P=" + pub + "\r", true, false, HostEntry{Name: "example.i2p", Addr: i2pkeys.I2PAddr(pub)}},
+		{"metadata", "example.i2p=" + pub + "#!date=1700000000#sig=abc", true, false,
+			HostEntry{Name: "example.i2p", Addr: i2pkeys.I2PAddr(pub), Options: map[string]string{"date": "1700000000", "sig": "abc"}}},
+		{"metadata only", "#!action=remove#name=example.i2p", true, false,
+			HostEntry{Options: map[string]string{"action": "remove", "name": "example.i2p"}}},
+		{"comment", "# hosts.txt", false, false, HostEntry{}},
+		{"blank", "   ", false, false, HostEntry{}},
+		{"missing =", "example.i2p " + pub, false, true, HostEntry{}},
+		{"not .i2p", "example.com=" + pub, false, true, HostEntry{}},
+		{"b32 name", samtest.B32(pub) + "=" + pub, false, true, HostEntry{}},
+		{"invalid label", "-example.i2p=" + pub, false, true, HostEntry{}},
+		{"invalid destination", "example.i2p=AAAA", false, true, HostEntry{}},
+		{"invalid metadata", "example.i2p=" + pub + "#!date", false, true, HostEntry{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := ParseHostsLine(tt.line)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInvalidHostsEntry)) {
+				t.Fatalf("ParseHostsLine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOK {
+				t.Errorf("ParseHostsLine() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got.Name != tt.want.Name || got.Addr != tt.want.Addr || len(got.Options) != len(tt.want.Options) {
+				t.Fatalf("ParseHostsLine() = %+v, want %+v", got, tt.want)
+			}
+			for k, v := range tt.want.Options {
+				if got.Options[k] != v {
+					t.Errorf("Options[%s] = %q, want %q", k, got.Options[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestReadHosts(t *testing.T) {
+	a, _ := samtest.NewDestination()
+	b, _ := samtest.NewDestination()
+	input := strings.Join([]string{
+		"# subscription",
+		"a.i2p=" + a,
+		"broken",
+		"",
+		"b.i2p=" + b + "#!date=1",
+	}, "\n")
+	entries, err := ReadHosts(strings.NewReader(input))
+	if !errors.Is(err, ErrInvalidHostsEntry) || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("ReadHosts() error = %v, want ErrInvalidHostsEntry on line 3", err)
+	}
+	if len(entries) != 2 || entries[0].Name != "a.i2p" || entries[1].Name != "b.i2p" {
+		t.Errorf("ReadHosts() = %+v, want the a.i2p and b.i2p entries", entries)
+	}
+}
+
+func TestHostsResolver(t *testing.T) {
+	first, _ := samtest.NewDestination()
+	second, _ := samtest.NewDestination()
+	unknown, _ := samtest.NewDestination()
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "hosts.txt"), filepath.Join(dir, "privatehosts.txt")}
+	os.WriteFile(files[0], []byte("site.i2p="+first+"\nalias.i2p="+first+"\n"), 0o644)
+	os.WriteFile(files[1], []byte("site.i2p="+second+"\nother.i2p="+second+"\n"), 0o644)
+	h, err := NewHostsResolver(files...)
+	if err != nil {
+		t.Fatalf("NewHostsResolver() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr error
+	}{
+		// the first file wins
+		{"site.i2p", first, nil},
+		{"SITE.i2p", first, nil},
+		{"other.i2p", second, nil},
+		{samtest.B32(first), first, nil},
+		{strings.ToUpper(samtest.B32(second)[:52]) + ".b32.i2p", second, nil},
+		{samtest.B32(unknown), "", ErrKeyNotFound},
+		{"tooshort.b32.i2p", "", ErrInvalidKey},
+		{"missing.i2p", "", ErrKeyNotFound},
+		{unknown, unknown, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name[:min(len(tt.name), 20)], func(t *testing.T) {
+			got, err := h.Resolve(tt.name)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("Resolve() error = %v, want %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Resolve() = %.16s, want %.16s", got, tt.want)
+			}
+		})
+	}
+
+	if name, ok := h.ReverseLookup(i2pkeys.I2PAddr(first)); !ok || name != "site.i2p" {
+		t.Errorf("ReverseLookup() = %q, %v, want site.i2p", name, ok)
+	}
+	if got := h.Hostname(i2pkeys.I2PAddr(unknown)); got != samtest.B32(unknown) {
+		t.Errorf("Hostname() of an unknown destination = %q, want its b32 address", got)
+	}
+	if err := h.Add("site.i2p", i2pkeys.I2PAddr(second)); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if got, _ := h.Resolve("site.i2p"); string(got) != second {
+		t.Error("Add() did not replace the destination")
+	}
+	// the remaining alias of the first destination is reported instead
+	if got := h.Hostname(i2pkeys.I2PAddr(first)); got != "alias.i2p" {
+		t.Errorf("Hostname() after Add() = %q, want alias.i2p", got)
+	}
+	if got, _ := h.Resolve(samtest.B32(first)); string(got) != first {
+		t.Error("Add() lost the b32 address of the first destination")
+	}
+	if got := h.Hostname(i2pkeys.I2PAddr(second)); got != "other.i2p" {
+		t.Errorf("Hostname() of the second destination = %q, want other.i2p", got)
+	}
+	if err := h.Add("bad name.i2p", i2pkeys.I2PAddr(second)); !errors.Is(err, ErrInvalidHostsEntry) {
+		t.Errorf("Add() of an invalid name error = %v, want ErrInvalidHostsEntry", err)
+	}
+}
+
+func TestSAM_LookupHosts(t *testing.T) {
+	local, _ := samtest.NewDestination()
+	router, _ := samtest.NewDestination()
+	tests := []struct {
+		name        string
+		afterSAM    bool
+		lookup      string
+		want        string
+		wantLookups int
+	}{
+		{"hosts first", false, "site.i2p", local, 0},
+		{"hosts after SAM", true, "site.i2p", router, 1},
+		{"hosts after SAM for unknown names", true, "private.i2p", local, 1},
+		{"destination", false, local, local, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, sam := newCacheTestSAM(t)
+			b.AddName("site.i2p", router)
+			hosts, _ := NewHostsResolver()
+			hosts.Load(strings.NewReader("site.i2p=" + local + "\nprivate.i2p=" + local + "\n"))
+			hosts.AfterSAM = tt.afterSAM
+			sam.Hosts = hosts
+
+			got, err := sam.Lookup(tt.lookup)
+			if err != nil || string(got) != tt.want {
+				t.Errorf("Lookup() = %.16s, %v, want %.16s", got, err, tt.want)
+			}
+			n := 0
+			for _, cmd := range b.Commands() {
+				if strings.HasPrefix(cmd, "NAMING LOOKUP") {
+					n++
+				}
+			}
+			if n != tt.wantLookups {
+				t.Errorf("bridge saw %d lookups, want %d", n, tt.wantLookups)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultKeepalive is the interval between PINGs on a session's control
+// connection unless changed with SetKeepalive.
+const DefaultKeepalive = 30 * time.Second
+
+// monitor watches the control connection of a session once it has been
+// created. It owns every read from the connection: PINGs from the bridge
+// are answered, PONGs are recorded and every other message is handed to the
+// Command waiting for it. Before that, it lets one Command at a time use the
+// connection. The monitor is shared by all copies of a SAM.
+type monitor struct {
+	mu       sync.Mutex
+	interval time.Duration
+	onError  func(error)
+	conn     net.Conn
+	waiters  []chan *Message
+	awaiting bool
+	err      error
+
+	wmu      sync.Mutex
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// turn is held by the Command reading its own reply, until the monitor
+	// takes over reading
+	turn chan struct{}
+}
+
+func newMonitor() *monitor {
+	return &monitor{
+		interval: DefaultKeepalive,
+		done:     make(chan struct{}),
+		turn:     make(chan struct{}, 1),
+	}
+}
+
+// acquire waits for the connection to be free of other Commands reading
+// their reply.
+func (m *monitor) acquire(ctx context.Context) error {
+	select {
+	case m.turn <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *monitor) release() {
+	<-m.turn
+}
+
+// start takes over reading from conn. PINGs are only sent if the bridge
+// supports them.
+func (m *monitor) start(conn net.Conn, r *MessageReader, ping bool) {
+	m.mu.Lock()
+	if m.conn != nil {
+		m.mu.Unlock()
+		return
+	}
+	m.conn = conn
+	interval := m.interval
+	m.mu.Unlock()
+
+	log.WithFields(logrus.Fields{
+		"interval": interval,
+		"ping":     ping,
+	}).Debug("Monitoring SAM control connection")
+	go m.readLoop(r)
+	if ping && interval > 0 {
+		go m.pingLoop(interval)
+	}
+}
+
+// owns reports whether the monitor is reading from conn.
+func (m *monitor) owns(conn net.Conn) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.conn != nil && m.conn == conn
+}
+
+func (m *monitor) readLoop(r *MessageReader) {
+	for {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			m.stop(err)
+			return
+		}
+		switch msg.Verb {
+		case "PING":
+			log.Debug("Answering PING from SAM bridge")
+			if err := m.write("PONG" + strings.TrimPrefix(msg.Raw, "PING")); err != nil {
+				m.stop(err)
+				return
+			}
+			continue
+		case "PONG":
+			m.mu.Lock()
+			m.awaiting = false
+			m.mu.Unlock()
+			log.Debug("Received PONG from SAM bridge")
+			continue
+		}
+		m.mu.Lock()
+		if len(m.waiters) == 0 {
+			m.mu.Unlock()
+			log.WithField("message", msg.String()).Warn("Dropping unsolicited SAM message")
+			continue
+		}
+		// replies come back in the order the commands were written
+		waiter := m.waiters[0]
+		m.waiters = m.waiters[1:]
+		m.mu.Unlock()
+		waiter <- msg
+	}
+}
+
+func (m *monitor) pingLoop(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-t.C:
+		}
+		m.mu.Lock()
+		if m.awaiting {
+			m.mu.Unlock()
+			m.stop(ErrKeepaliveTimeout)
+			return
+		}
+		m.awaiting = true
+		m.mu.Unlock()
+		if err := m.write("PING " + strconv.FormatInt(time.Now().UnixNano(), 10)); err != nil {
+			m.stop(err)
+			return
+		}
+	}
+}
+
+func (m *monitor) write(line string) error {
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	_, err := io.WriteString(m.conn, line+"\n")
+	return err
+}
+
+// command sends cmd and waits for the reply delivered by the read loop. If
+// ctx ends first the reply is discarded when it arrives.
+func (m *monitor) command(ctx context.Context, cmd string) (*Message, error) {
+	select {
+	case <-m.done:
+		return nil, fmt.Errorf("error writing to SAM: %w", m.cause())
+	default:
+	}
+	// buffered, so the read loop never blocks on an abandoned command
+	waiter := make(chan *Message, 1)
+	m.wmu.Lock()
+	m.mu.Lock()
+	m.waiters = append(m.waiters, waiter)
+	m.mu.Unlock()
+	if d, ok := ctx.Deadline(); ok {
+		m.conn.SetWriteDeadline(d)
+	}
+	_, err := io.WriteString(m.conn, strings.TrimSuffix(cmd, "\n")+"\n")
+	m.conn.SetWriteDeadline(time.Time{})
+	if err != nil {
+		m.mu.Lock()
+		m.waiters = m.waiters[:len(m.waiters)-1]
+		m.mu.Unlock()
+		m.wmu.Unlock()
+		return nil, fmt.Errorf("error writing to SAM: %w", err)
+	}
+	m.wmu.Unlock()
+	select {
+	case reply := <-waiter:
+		return reply, nil
+	case <-m.done:
+		return nil, fmt.Errorf("error reading from SAM: %w", m.cause())
+	case <-ctx.Done():
+		log.WithField("command", redacted(cmd)).Debug("SAM command abandoned")
+		return nil, ctx.Err()
+	}
+}
+
+// stop ends monitoring. A connection closed by our side is a normal
+// shutdown, anything else is reported to the error handler.
+func (m *monitor) stop(err error) {
+	m.stopOnce.Do(func() {
+		switch {
+		case errors.Is(err, net.ErrClosed):
+			err = nil
+		case errors.Is(err, ErrKeepaliveTimeout):
+		default:
+			err = fmt.Errorf("%w: %w", ErrConnectionLost, err)
+		}
+		m.mu.Lock()
+		m.err = err
+		onError := m.onError
+		conn := m.conn
+		m.mu.Unlock()
+		close(m.done)
+		conn.Close()
+		if err == nil {
+			log.Debug("SAM control connection closed")
+			return
+		}
+		log.WithError(err).Error("SAM control connection died")
+		if onError != nil {
+			go onError(err)
+		}
+	})
+}
+
+// cause returns why the monitor stopped.
+func (m *monitor) cause() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	return net.ErrClosed
+}
+
+// SetKeepalive sets the interval between PINGs sent on the control
+// connection once a session is created. If no PONG arrives before the next
+// PING is due, the connection is considered dead. Zero disables PINGs. It
+// must be called before the session is created.
+func (sam *SAM) SetKeepalive(interval time.Duration) {
+	if sam.monitor == nil {
+		sam.monitor = newMonitor()
+	}
+	sam.monitor.mu.Lock()
+	defer sam.monitor.mu.Unlock()
+	sam.monitor.interval = interval
+}
+
+// SetErrorHandler registers fn to be called, once, when the control
+// connection of the session dies for any reason other than Close.
+func (sam *SAM) SetErrorHandler(fn func(error)) {
+	if sam.monitor == nil {
+		sam.monitor = newMonitor()
+	}
+	sam.monitor.mu.Lock()
+	defer sam.monitor.mu.Unlock()
+	sam.monitor.onError = fn
+}
+
+// Done returns a channel which is closed when the control connection of the
+// session is closed or dies. The session is unusable afterwards. Done
+// returns nil for a SAM which was not created with NewSAM.
+func (sam *SAM) Done() <-chan struct{} {
+	if sam.monitor == nil {
+		return nil
+	}
+	return sam.monitor.done
+}
+
+// Err returns why the control connection died: ErrKeepaliveTimeout if the
+// bridge stopped answering PINGs, an error wrapping ErrConnectionLost if
+// the connection failed. It returns nil while the session is alive and
+// after Close.
+func (sam *SAM) Err() error {
+	if sam.monitor == nil {
+		return nil
+	}
+	sam.monitor.mu.Lock()
+	defer sam.monitor.mu.Unlock()
+	return sam.monitor.err
+}
+
+// startMonitor hands the control connection over to the monitor after a
+// successful SESSION CREATE.
+func (sam *SAM) startMonitor() {
+	if sam.monitor == nil {
+		sam.monitor = newMonitor()
+	}
+	sam.monitor.start(sam.Conn, sam.messages(), sam.Supports(FeaturePing))
+}
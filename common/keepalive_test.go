@@ -0,0 +1,142 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/samtest"
+)
+
+func newMonitoredSession(t *testing.T, b *samtest.Bridge, id string, keepalive time.Duration, onError func(error)) *SAM {
+	t.Helper()
+	sam, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	t.Cleanup(func() { sam.Close() })
+	sam.SetKeepalive(keepalive)
+	sam.SetErrorHandler(onError)
+	keys, err := sam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	if _, err := sam.NewGenericSession("STREAM", id, keys, nil); err != nil {
+		t.Fatalf("NewGenericSession() error = %v", err)
+	}
+	return sam
+}
+
+func waitDone(t *testing.T, sam *SAM) {
+	t.Helper()
+	select {
+	case <-sam.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done() was not closed")
+	}
+}
+
+func TestKeepalive_Failures(t *testing.T) {
+	tests := []struct {
+		name    string
+		kill    func(b *samtest.Bridge, id string)
+		wantErr error
+	}{
+		{"bridge stops answering", func(b *samtest.Bridge, id string) { b.IgnorePings(true) }, ErrKeepaliveTimeout},
+		{"connection dropped", func(b *samtest.Bridge, id string) { b.Disconnect(id) }, ErrConnectionLost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := samtest.NewBridge()
+			if err != nil {
+				t.Fatalf("NewBridge() error = %v", err)
+			}
+			defer b.Close()
+
+			reported := make(chan error, 1)
+			sam := newMonitoredSession(t, b, "ka", 50*time.Millisecond, func(err error) { reported <- err })
+			// a few healthy rounds first
+			time.Sleep(200 * time.Millisecond)
+			if err := sam.Err(); err != nil {
+				t.Fatalf("Err() on healthy session = %v", err)
+			}
+			tt.kill(b, "ka")
+			waitDone(t, sam)
+			if err := sam.Err(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Err() = %v, want %v", err, tt.wantErr)
+			}
+			select {
+			case err := <-reported:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error handler got %v, want %v", err, tt.wantErr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Error("error handler was not called")
+			}
+		})
+	}
+}
+
+func TestKeepalive_Close(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	reported := make(chan error, 1)
+	sam := newMonitoredSession(t, b, "closing", DefaultKeepalive, func(err error) { reported <- err })
+	sam.Close()
+	waitDone(t, sam)
+	if err := sam.Err(); err != nil {
+		t.Errorf("Err() after Close() = %v, want nil", err)
+	}
+	select {
+	case err := <-reported:
+		t.Errorf("error handler called after Close() with %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestKeepalive_AnswersPingBetweenCommands(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	sam := newMonitoredSession(t, b, "pinged", 10*time.Millisecond, nil)
+	for i := 0; i < 5; i++ {
+		if err := b.Ping("pinged"); err != nil {
+			t.Fatalf("Ping() error = %v", err)
+		}
+		// replies keep reaching commands while PINGs and PONGs interleave
+		if _, err := sam.Lookup("ME"); err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		time.Sleep(15 * time.Millisecond)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var pongs int
+		for _, cmd := range b.Commands() {
+			if strings.HasPrefix(cmd, "PONG ") {
+				pongs++
+			}
+		}
+		if pongs >= 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("bridge received %d PONGs, want 5", pongs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-sam.Done():
+		t.Fatalf("Done() closed on a healthy session: %v", sam.Err())
+	default:
+	}
+}
@@ -0,0 +1,253 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-i2p/i2pkeys"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrInvalidKeyName is returned by a KeyStore for names which are empty,
+// start with a dot or contain a path separator.
+var ErrInvalidKeyName = errors.New("invalid key name")
+
+// KeyStore keeps destination keys by name. Load and Delete of a name which
+// was never saved fail with an error wrapping fs.ErrNotExist. KeyStores are
+// safe for concurrent use.
+type KeyStore interface {
+	Load(name string) (i2pkeys.I2PKeys, error)
+	Save(name string, keys i2pkeys.I2PKeys) error
+	List() ([]string, error)
+	Delete(name string) error
+}
+
+// checkKeyName rejects names which could escape the store's directory or
+// collide with its temporary files.
+func checkKeyName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) || strings.ContainsRune(name, 0) {
+		return fmt.Errorf("%w: %q", ErrInvalidKeyName, name)
+	}
+	return nil
+}
+
+// encodeKeys serializes keys in the format of i2pkeys.StoreKeysIncompat,
+// which EnsureKeyfile also uses.
+func encodeKeys(keys i2pkeys.I2PKeys) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := i2pkeys.StoreKeysIncompat(keys, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeKeys(data []byte) (i2pkeys.I2PKeys, error) {
+	keys, err := i2pkeys.LoadKeysIncompat(bytes.NewReader(data))
+	if err != nil {
+		return i2pkeys.I2PKeys{}, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+	return keys, nil
+}
+
+// WriteFileAtomic replaces fname with data, with permissions perm. Readers
+// see either the old or the new content, never a partial write.
+func WriteFileAtomic(fname string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(fname)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), fname); err != nil {
+		return err
+	}
+	// make the rename itself durable, where the platform allows it
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// DirKeyStore keeps each set of keys in a file of the same name in a
+// directory, in the plaintext format of EnsureKeyfile.
+type DirKeyStore struct {
+	dir string
+}
+
+// NewDirKeyStore returns a DirKeyStore in dir, creating the directory,
+// accessible by the owner only, if it does not exist.
+func NewDirKeyStore(dir string) (*DirKeyStore, error) {
+	log.WithField("dir", dir).Debug("Opening key store directory")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.WithError(err).Error("Failed to create key store directory")
+		return nil, err
+	}
+	return &DirKeyStore{dir: dir}, nil
+}
+
+// Dir returns the directory of the store.
+func (s *DirKeyStore) Dir() string {
+	return s.dir
+}
+
+func (s *DirKeyStore) read(name string) ([]byte, error) {
+	if err := checkKeyName(name); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(s.dir, name))
+}
+
+func (s *DirKeyStore) write(name string, data []byte) error {
+	if err := checkKeyName(name); err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(s.dir, name), data, 0o600)
+}
+
+func (s *DirKeyStore) Load(name string) (i2pkeys.I2PKeys, error) {
+	data, err := s.read(name)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	return decodeKeys(data)
+}
+
+func (s *DirKeyStore) Save(name string, keys i2pkeys.I2PKeys) error {
+	log.WithFields(logrus.Fields{"dir": s.dir, "name": name}).Debug("Saving keys")
+	data, err := encodeKeys(keys)
+	if err != nil {
+		return err
+	}
+	return s.write(name, data)
+}
+
+// List returns the names of the stored keys, sorted.
+func (s *DirKeyStore) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && checkKeyName(e.Name()) == nil {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s *DirKeyStore) Delete(name string) error {
+	if err := checkKeyName(name); err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{"dir": s.dir, "name": name}).Debug("Deleting keys")
+	return os.Remove(filepath.Join(s.dir, name))
+}
+
+// MemoryKeyStore keeps keys in memory, for tests and transient services.
+type MemoryKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]i2pkeys.I2PKeys
+}
+
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{keys: make(map[string]i2pkeys.I2PKeys)}
+}
+
+func (s *MemoryKeyStore) Load(name string) (i2pkeys.I2PKeys, error) {
+	if err := checkKeyName(name); err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys, ok := s.keys[name]
+	if !ok {
+		return i2pkeys.I2PKeys{}, &fs.PathError{Op: "load", Path: name, Err: fs.ErrNotExist}
+	}
+	return keys, nil
+}
+
+func (s *MemoryKeyStore) Save(name string, keys i2pkeys.I2PKeys) error {
+	if err := checkKeyName(name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[name] = keys
+	return nil
+}
+
+// List returns the names of the stored keys, sorted.
+func (s *MemoryKeyStore) List() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.keys))
+	for name := range s.keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *MemoryKeyStore) Delete(name string) error {
+	if err := checkKeyName(name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[name]; !ok {
+		return &fs.PathError{Op: "delete", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(s.keys, name)
+	return nil
+}
+
+// EnsureKeys returns the keys saved in store as name, generating and saving
+// them first if there are none. The keys become the SAM's DestinationKeys.
+func (sam *SAM) EnsureKeys(store KeyStore, name string) (i2pkeys.I2PKeys, error) {
+	keys, err := store.Load(name)
+	switch {
+	case err == nil:
+		log.WithField("name", name).Debug("Loaded existing keys")
+	case errors.Is(err, fs.ErrNotExist):
+		if keys, err = sam.NewKeys(); err != nil {
+			return i2pkeys.I2PKeys{}, err
+		}
+		if err = store.Save(name, keys); err != nil {
+			log.WithError(err).Error("Failed to save new keys")
+			return i2pkeys.I2PKeys{}, err
+		}
+		log.WithField("name", name).Debug("Generated and saved new keys")
+	default:
+		log.WithError(err).Error("Failed to load keys")
+		return i2pkeys.I2PKeys{}, err
+	}
+	sam.SAMEmit.I2PConfig.DestinationKeys = &keys
+	return keys, nil
+}
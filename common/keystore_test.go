@@ -0,0 +1,231 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/go-i2p/go-sam-go/samtest"
+	"github.com/go-i2p/i2pkeys"
+)
+
+func testKeys() i2pkeys.I2PKeys {
+	pub, priv := samtest.NewDestination()
+	return i2pkeys.NewKeys(i2pkeys.I2PAddr(pub), priv)
+}
+
+// cheapKDF keeps the encrypted store fast under the race detector.
+var cheapKDF = kdfParams{time: 1, memory: 64, threads: 1}
+
+func newEncryptedTestStore(t *testing.T, dir string, passphrase string) *EncryptedKeyStore {
+	t.Helper()
+	s, err := NewEncryptedKeyStore(dir, []byte(passphrase))
+	if err != nil {
+		t.Fatalf("NewEncryptedKeyStore() error = %v", err)
+	}
+	s.kdf = cheapKDF
+	return s
+}
+
+func TestKeyStore(t *testing.T) {
+	stores := []struct {
+		name string
+		new  func(t *testing.T) KeyStore
+	}{
+		{"memory", func(t *testing.T) KeyStore { return NewMemoryKeyStore() }},
+		{"directory", func(t *testing.T) KeyStore {
+			s, err := NewDirKeyStore(filepath.Join(t.TempDir(), "keys"))
+			if err != nil {
+				t.Fatalf("NewDirKeyStore() error = %v", err)
+			}
+			return s
+		}},
+		{"encrypted", func(t *testing.T) KeyStore { return newEncryptedTestStore(t, t.TempDir(), "correct horse") }},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			s := st.new(t)
+			if _, err := s.Load("web"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Load() of a missing name error = %v, want fs.ErrNotExist", err)
+			}
+			web, irc := testKeys(), testKeys()
+			if err := s.Save("web", testKeys()); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			// overwritten
+			if err := s.Save("web", web); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			if err := s.Save("irc", irc); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			got, err := s.Load("web")
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if got.String() != web.String() || got.Addr() != web.Addr() {
+				t.Error("Load() did not return the saved keys")
+			}
+			if names, err := s.List(); err != nil || !slices.Equal(names, []string{"irc", "web"}) {
+				t.Errorf("List() = %v, %v, want [irc web]", names, err)
+			}
+			if err := s.Delete("irc"); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if err := s.Delete("irc"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("second Delete() error = %v, want fs.ErrNotExist", err)
+			}
+			if names, _ := s.List(); !slices.Equal(names, []string{"web"}) {
+				t.Errorf("List() after Delete() = %v, want [web]", names)
+			}
+			for _, name := range []string{"", ".hidden", "../escape", `a\b`} {
+				if err := s.Save(name, web); !errors.Is(err, ErrInvalidKeyName) {
+					t.Errorf("Save(%q) error = %v, want ErrInvalidKeyName", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDirKeyStore_Files(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDirKeyStore(dir)
+	if err != nil {
+		t.Fatalf("NewDirKeyStore() error = %v", err)
+	}
+	long := testKeys()
+	if err := s.Save("svc", long); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	// a shorter file replacing a longer one must not keep its tail
+	if err := s.Save("svc", i2pkeys.NewKeys(long.Addr(), long.String()[:len(long.Addr())+10])); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	fi, err := os.Stat(filepath.Join(dir, "svc"))
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Errorf("key file mode = %v, want 0600", perm)
+	}
+	got, err := s.Load("svc")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(got.String()) != len(long.Addr())+10 {
+		t.Errorf("Load() read %d bytes of private key, stale data left behind", len(got.String()))
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only the key file", len(entries))
+	}
+}
+
+func TestEncryptedKeyStore(t *testing.T) {
+	dir := t.TempDir()
+	s := newEncryptedTestStore(t, dir, "correct horse")
+	keys := testKeys()
+	if err := s.Save("svc", keys); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "svc"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if bytes.Contains(data, []byte(keys.String()[:64])) || bytes.Contains(data, []byte(keys.Addr()[:64])) {
+		t.Error("key file contains the keys in plaintext")
+	}
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T) (KeyStore, string)
+	}{
+		{"wrong passphrase", func(t *testing.T) (KeyStore, string) {
+			return newEncryptedTestStore(t, dir, "battery staple"), "svc"
+		}},
+		{"renamed file", func(t *testing.T) (KeyStore, string) {
+			other := t.TempDir()
+			if err := os.WriteFile(filepath.Join(other, "moved"), data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			return newEncryptedTestStore(t, other, "correct horse"), "moved"
+		}},
+		{"tampered file", func(t *testing.T) (KeyStore, string) {
+			other := t.TempDir()
+			tampered := bytes.Clone(data)
+			tampered[len(tampered)-1] ^= 1
+			if err := os.WriteFile(filepath.Join(other, "svc"), tampered, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			return newEncryptedTestStore(t, other, "correct horse"), "svc"
+		}},
+		{"excessive key derivation time", func(t *testing.T) (KeyStore, string) {
+			other := t.TempDir()
+			crafted := bytes.Clone(data)
+			binary.BigEndian.PutUint32(crafted[len(encryptedMagic)+1:], 1<<31)
+			if err := os.WriteFile(filepath.Join(other, "svc"), crafted, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			return newEncryptedTestStore(t, other, "correct horse"), "svc"
+		}},
+		{"plaintext file", func(t *testing.T) (KeyStore, string) {
+			other := t.TempDir()
+			plain, _ := NewDirKeyStore(other)
+			plain.Save("svc", keys)
+			return newEncryptedTestStore(t, other, "correct horse"), "svc"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, name := tt.setup(t)
+			if _, err := store.Load(name); !errors.Is(err, ErrDecryptKeys) {
+				t.Errorf("Load() error = %v, want ErrDecryptKeys", err)
+			}
+		})
+	}
+
+	got, err := newEncryptedTestStore(t, dir, "correct horse").Load("svc")
+	if err != nil {
+		t.Fatalf("Load() with the passphrase error = %v", err)
+	}
+	if got.String() != keys.String() {
+		t.Error("Load() did not return the saved keys")
+	}
+	if _, err := NewEncryptedKeyStore(dir, nil); err == nil {
+		t.Error("NewEncryptedKeyStore() accepted an empty passphrase")
+	}
+}
+
+func TestSAM_EnsureKeys(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	sam, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	defer sam.Close()
+
+	store := NewMemoryKeyStore()
+	first, err := sam.EnsureKeys(store, "svc")
+	if err != nil {
+		t.Fatalf("EnsureKeys() error = %v", err)
+	}
+	again, err := sam.EnsureKeys(store, "svc")
+	if err != nil {
+		t.Fatalf("second EnsureKeys() error = %v", err)
+	}
+	if again.Addr() != first.Addr() {
+		t.Error("EnsureKeys() generated new keys although some were saved")
+	}
+	if sam.DestinationKeys == nil || sam.DestinationKeys.Addr() != first.Addr() {
+		t.Error("EnsureKeys() did not set DestinationKeys")
+	}
+}
@@ -0,0 +1,217 @@
+package common
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Message is a single line of the SAMv3 control protocol, e.g.
+//
+//	SESSION STATUS RESULT=I2P_ERROR MESSAGE="tunnel build failed"
+//
+// Verb and Action are the leading bare words, Args holds every KEY=VALUE
+// pair with quotes and escapes removed. Keys given without a value map to
+// the empty string.
+type Message struct {
+	Verb   string
+	Action string
+	Args   map[string]string
+	Raw    string
+}
+
+// ParseMessage parses one SAM line according to the SAMv3 grammar. Values may
+// be double-quoted, in which case they can contain spaces and the escapes
+// \" and \\. The trailing newline, if any, is ignored.
+func ParseMessage(line string) (*Message, error) {
+	line = strings.TrimRight(line, "\r\n")
+	toks, err := tokenize(line)
+	if err != nil {
+		log.WithError(err).WithField("line", line).Error("Failed to tokenize SAM message")
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, errors.New("empty SAM message")
+	}
+	m := &Message{
+		Args: make(map[string]string, len(toks)),
+		Raw:  line,
+	}
+	for i, tok := range toks {
+		key, value, isPair := strings.Cut(tok.text, "=")
+		switch {
+		case i == 0 && !isPair && !tok.quoted:
+			m.Verb = tok.text
+		case i == 1 && !isPair && !tok.quoted && m.Verb != "":
+			m.Action = tok.text
+		default:
+			if tok.quoted && !isPair {
+				// a bare quoted word, keep it verbatim
+				m.Args[tok.text] = ""
+				continue
+			}
+			m.Args[key] = value
+		}
+	}
+	log.WithFields(logrus.Fields{
+		"verb":   m.Verb,
+		"action": m.Action,
+		"args":   len(m.Args),
+	}).Debug("Parsed SAM message")
+	return m, nil
+}
+
+// Get returns the value of key, or the empty string.
+func (m *Message) Get(key string) string {
+	return m.Args[key]
+}
+
+// Has reports whether key was present in the message.
+func (m *Message) Has(key string) bool {
+	_, ok := m.Args[key]
+	return ok
+}
+
+// Is reports whether the message has the given verb and action.
+func (m *Message) Is(verb, action string) bool {
+	return m.Verb == verb && m.Action == action
+}
+
+// Result returns the RESULT value of a reply, or the empty string.
+func (m *Message) Result() string {
+	return m.Args["RESULT"]
+}
+
+// OK reports whether the reply carries RESULT=OK.
+func (m *Message) OK() bool {
+	return m.Result() == "OK"
+}
+
+// String returns the message as it was received.
+func (m *Message) String() string {
+	return m.Raw
+}
+
+// quoteValue quotes v if it cannot be sent as a bare SAM value.
+func quoteValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t\"\\=") {
+		return v
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(v) + `"`
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+// tokenize splits a line on unquoted whitespace. Quotes are removed from
+// values, so KEY="a b" becomes the single token KEY=a b.
+func tokenize(line string) ([]token, error) {
+	var (
+		toks    []token
+		cur     strings.Builder
+		quoted  bool
+		inQuote bool
+	)
+	flush := func() {
+		if cur.Len() > 0 || quoted {
+			toks = append(toks, token{text: cur.String(), quoted: quoted})
+		}
+		cur.Reset()
+		quoted = false
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case inQuote && c == '\\' && i+1 < len(line):
+			i++
+			cur.WriteByte(line[i])
+		case c == '"':
+			inQuote = !inQuote
+			quoted = true
+		case !inQuote && (c == ' ' || c == '\t'):
+			flush()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote in SAM message: %q", line)
+	}
+	flush()
+	return toks, nil
+}
+
+// MessageReader reads newline-terminated SAM messages from a stream,
+// independently of how the bytes are split across TCP segments.
+type MessageReader struct {
+	src net.Conn
+	r   *bufio.Reader
+}
+
+// NewMessageReader returns a MessageReader reading from r.
+func NewMessageReader(r io.Reader) *MessageReader {
+	mr := &MessageReader{r: bufio.NewReader(r)}
+	if c, ok := r.(net.Conn); ok {
+		mr.src = c
+	}
+	return mr
+}
+
+// ReadLine returns the next line without its line terminator.
+func (mr *MessageReader) ReadLine() (string, error) {
+	line, err := mr.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			// the bridge hung up mid-line, the line is incomplete
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// ReadMessage reads and parses the next non-empty line.
+func (mr *MessageReader) ReadMessage() (*Message, error) {
+	for {
+		line, err := mr.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		log.WithField("line", line).Debug("Read SAM message")
+		return ParseMessage(line)
+	}
+}
+
+// Conn returns a net.Conn for the underlying connection which first yields
+// the bytes the reader has already buffered. Use it once the control socket
+// turns into a data stream, so nothing read ahead is lost. It returns nil
+// if the reader does not read from a net.Conn.
+func (mr *MessageReader) Conn() net.Conn {
+	if mr.src == nil {
+		return nil
+	}
+	return &bufferedConn{Conn: mr.src, r: mr.r}
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	if c.r.Buffered() > 0 {
+		return c.r.Read(b)
+	}
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,146 @@
+package common
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseMessage_Cases(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		wantVerb   string
+		wantAction string
+		wantArgs   map[string]string
+		wantErr    bool
+	}{
+		{
+			name:       "hello reply",
+			line:       "HELLO REPLY RESULT=OK VERSION=3.3\n",
+			wantVerb:   "HELLO",
+			wantAction: "REPLY",
+			wantArgs:   map[string]string{"RESULT": "OK", "VERSION": "3.3"},
+		},
+		{
+			name:       "quoted message with spaces",
+			line:       `SESSION STATUS RESULT=I2P_ERROR MESSAGE="tunnel build failed, try again"`,
+			wantVerb:   "SESSION",
+			wantAction: "STATUS",
+			wantArgs:   map[string]string{"RESULT": "I2P_ERROR", "MESSAGE": "tunnel build failed, try again"},
+		},
+		{
+			name:       "escaped quote",
+			line:       `NAMING REPLY RESULT=KEY_NOT_FOUND MESSAGE="no \"such\" host"`,
+			wantVerb:   "NAMING",
+			wantAction: "REPLY",
+			wantArgs:   map[string]string{"RESULT": "KEY_NOT_FOUND", "MESSAGE": `no "such" host`},
+		},
+		{
+			name:       "value containing equals",
+			line:       "DEST REPLY PUB=abc PRIV=def MESSAGE=\"a=b\"\r\n",
+			wantVerb:   "DEST",
+			wantAction: "REPLY",
+			wantArgs:   map[string]string{"PUB": "abc", "PRIV": "def", "MESSAGE": "a=b"},
+		},
+		{
+			name:     "verb only with pairs",
+			line:     "dest123 FROM_PORT=1 TO_PORT=2",
+			wantVerb: "dest123",
+			wantArgs: map[string]string{"FROM_PORT": "1", "TO_PORT": "2"},
+		},
+		{
+			name:       "key without value",
+			line:       "STREAM STATUS RESULT=OK SILENT",
+			wantVerb:   "STREAM",
+			wantAction: "STATUS",
+			wantArgs:   map[string]string{"RESULT": "OK", "SILENT": ""},
+		},
+		{
+			name:    "unterminated quote",
+			line:    `SESSION STATUS MESSAGE="oops`,
+			wantErr: true,
+		},
+		{
+			name:    "empty line",
+			line:    "  \n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseMessage(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMessage(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if m.Verb != tt.wantVerb || m.Action != tt.wantAction {
+				t.Errorf("ParseMessage(%q) = %q %q, want %q %q", tt.line, m.Verb, m.Action, tt.wantVerb, tt.wantAction)
+			}
+			if len(m.Args) != len(tt.wantArgs) {
+				t.Errorf("ParseMessage(%q) args = %v, want %v", tt.line, m.Args, tt.wantArgs)
+			}
+			for k, v := range tt.wantArgs {
+				if got := m.Get(k); got != v {
+					t.Errorf("ParseMessage(%q) %s = %q, want %q", tt.line, k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestMessageReader_PartialAndCoalesced(t *testing.T) {
+	input := "HELLO REPLY RESULT=OK VERSION=3.1\nSESSION STATUS RESULT=I2P_ERROR MESSAGE=\"no tunnels\"\n\nNAMING REPLY RESULT=OK NAME=a VALUE=b\n"
+	// one byte at a time simulates the worst possible TCP segmentation
+	mr := NewMessageReader(iotest.OneByteReader(strings.NewReader(input)))
+	want := []string{"HELLO", "SESSION", "NAMING"}
+	for _, verb := range want {
+		m, err := mr.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() error = %v", err)
+		}
+		if m.Verb != verb {
+			t.Errorf("ReadMessage() verb = %q, want %q", m.Verb, verb)
+		}
+	}
+	if _, err := mr.ReadMessage(); err != io.EOF {
+		t.Errorf("ReadMessage() at end error = %v, want io.EOF", err)
+	}
+}
+
+func TestMessageReader_TruncatedLine(t *testing.T) {
+	mr := NewMessageReader(strings.NewReader("HELLO REPLY RES"))
+	if _, err := mr.ReadMessage(); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadMessage() error = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if conn := mr.Conn(); conn != nil {
+		t.Errorf("Conn() of a reader over a string = %v, want nil", conn)
+	}
+}
+
+func TestMessageReader_ConnKeepsReadAhead(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		// reply and the first stream bytes arrive in one write
+		server.Write([]byte("STREAM STATUS RESULT=OK\nhello"))
+		server.Close()
+	}()
+	mr := NewMessageReader(client)
+	m, err := mr.ReadMessage()
+	if err != nil || !m.OK() {
+		t.Fatalf("ReadMessage() = %v, %v", m, err)
+	}
+	data, err := io.ReadAll(mr.Conn())
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("data after reply = %q, want %q", data, "hello")
+	}
+}
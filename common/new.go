@@ -1,15 +1,15 @@
 package common
 
 import (
+	"context"
 	"fmt"
 	"net"
-	"strings"
 )
 
 // Creates a new controller for the I2P routers SAM bridge.
 func OldNewSAM(address string) (*SAM, error) {
 	log.WithField("address", address).Debug("Creating new SAM instance")
-	var s SAM
+	s := SAM{monitor: newMonitor()}
 	// TODO: clean this up by refactoring the connection setup and error handling logic
 	conn, err := net.Dial("tcp", address)
 	if err != nil {
@@ -21,39 +21,74 @@ func OldNewSAM(address string) (*SAM, error) {
 		conn.Close()
 		return nil, fmt.Errorf("error writing to address '%s': %w", address, err)
 	}
-	buf := make([]byte, 256)
-	n, err := conn.Read(buf)
+	s.Conn = conn
+	reply, err := s.ReadMessage()
 	if err != nil {
 		log.WithError(err).Error("Failed to read SAM response")
 		conn.Close()
 		return nil, fmt.Errorf("error reading onto buffer: %w", err)
 	}
-	if strings.Contains(string(buf[:n]), HELLO_REPLY_OK) {
+	if reply.Is("HELLO", "REPLY") && reply.OK() {
 		log.Debug("SAM hello successful")
 		s.SAMEmit.I2PConfig.SetSAMAddress(address)
-		s.Conn = conn
 		s.SAMResolver, err = NewSAMResolver(&s)
 		if err != nil {
 			log.WithError(err).Error("Failed to create SAM resolver")
 			return nil, fmt.Errorf("error creating resolver: %w", err)
 		}
+		if s.Cache, err = NewCachingResolver(s.SAMResolver); err != nil {
+			return nil, fmt.Errorf("error creating resolver cache: %w", err)
+		}
 		return &s, nil
-	} else if string(buf[:n]) == HELLO_REPLY_NOVERSION {
+	} else if reply.Result() == "NOVERSION" {
 		log.Error("SAM bridge does not support SAMv3")
 		conn.Close()
 		return nil, fmt.Errorf("That SAM bridge does not support SAMv3.")
 	} else {
-		log.WithField("response", string(buf[:n])).Error("Unexpected SAM response")
+		log.WithField("response", reply.String()).Error("Unexpected SAM response")
 		conn.Close()
-		return nil, fmt.Errorf("%s", string(buf[:n]))
+		return nil, fmt.Errorf("%s", reply)
 	}
 }
 
-func NewSAM(address string) (*SAM, error) {
+// NewSAM connects to the SAM bridge at address and performs the HELLO
+// handshake. Options such as SetSAMAuth configure the connection before the
+// handshake is sent.
+func NewSAM(address string, opts ...func(*SAMEmit) error) (*SAM, error) {
+	return NewSAMContext(context.Background(), address, opts...)
+}
+
+// NewSAMContext is like NewSAM, but gives up on connecting and on the
+// handshake when ctx is done.
+func NewSAMContext(ctx context.Context, address string, opts ...func(*SAMEmit) error) (*SAM, error) {
+	s, err := dialSAM(ctx, address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if s.Cache, err = NewCachingResolver(s.SAMResolver); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to create resolver cache: %w", err)
+	}
+	return s, nil
+}
+
+// dialSAM is NewSAMContext without the resolver cache, which the SAMs
+// opened from another one share with it.
+func dialSAM(ctx context.Context, address string, opts ...func(*SAMEmit) error) (*SAM, error) {
 	logger := log.WithField("address", address)
 	logger.Debug("Creating new SAM instance")
 
-	conn, err := connectToSAM(address)
+	s := &SAM{
+		monitor: newMonitor(),
+	}
+	for _, o := range opts {
+		if err := o(&s.SAMEmit); err != nil {
+			logger.WithError(err).Error("Failed to apply option")
+			return nil, err
+		}
+	}
+
+	conn, err := connectToSAM(ctx, &s.SAMEmit.I2PConfig, address)
 	if err != nil {
 		return nil, err
 	}
@@ -62,12 +97,10 @@ func NewSAM(address string) (*SAM, error) {
 			conn.Close()
 		}
 	}()
+	s.Conn = conn
 
-	s := &SAM{
-		Conn: conn,
-	}
-
-	if err = sendHelloAndValidate(conn, s); err != nil {
+	stop := s.watch(ctx)
+	if err = stop(sendHelloAndValidate(conn, s)); err != nil {
 		return nil, err
 	}
 
@@ -79,3 +112,64 @@ func NewSAM(address string) (*SAM, error) {
 
 	return s, nil
 }
+
+// Redial opens a new control connection to the same SAM bridge, using the
+// same credentials, Dialer, TLS settings, Resolver, Hosts, Timeout and
+// Context. Data connections such as STREAM CONNECT and STREAM ACCEPT each
+// need their own connection. The new SAM shares the Cache of sam.
+func (sam *SAM) Redial() (*SAM, error) {
+	ctx, cancel := sam.ContextWithTimeout()
+	defer cancel()
+	return sam.RedialContext(ctx)
+}
+
+// RedialContext is like Redial, but gives up when ctx is done.
+func (sam *SAM) RedialContext(ctx context.Context) (*SAM, error) {
+	log.WithField("address", sam.Sam()).Debug("Opening new connection to SAM bridge")
+	opts := []func(*SAMEmit) error{sam.transport()}
+	if sam.User != "" || sam.Password != "" {
+		opts = append(opts, SetSAMAuth(sam.User, sam.Password))
+	}
+	s, err := dialSAM(ctx, sam.Sam(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	s.Resolver = sam.Resolver
+	s.Cache = sam.Cache.via(s.SAMResolver)
+	s.Hosts = sam.Hosts
+	s.Timeout = sam.Timeout
+	s.Context = sam.Context
+	return s, nil
+}
+
+// ReconnectContext opens a new control connection to the SAM bridge
+// configured like sam: same address, transport, credentials, session
+// options, Resolver, Hosts, keepalive and error handler, Timeout and
+// Context. It is used to
+// create a session again after its control connection was lost. The new
+// SAM shares the Cache of sam, but looks names up on its own connection.
+func (sam *SAM) ReconnectContext(ctx context.Context) (*SAM, error) {
+	log.WithField("address", sam.Sam()).Debug("Reconnecting to SAM bridge")
+	config := sam.SAMEmit.I2PConfig
+	// negotiated again, the bridge may have been upgraded
+	config.version = ""
+	s, err := dialSAM(ctx, sam.Sam(), func(e *SAMEmit) error {
+		e.I2PConfig = config
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.Cache = sam.Cache.via(s.SAMResolver)
+	s.Hosts = sam.Hosts
+	s.Timeout = sam.Timeout
+	s.Context = sam.Context
+	if sam.monitor != nil {
+		sam.monitor.mu.Lock()
+		interval, onError := sam.monitor.interval, sam.monitor.onError
+		sam.monitor.mu.Unlock()
+		s.SetKeepalive(interval)
+		s.SetErrorHandler(onError)
+	}
+	return s, nil
+}
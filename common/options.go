@@ -0,0 +1,63 @@
+package common
+
+import "strings"
+
+// sessionKeys are the keys of SESSION CREATE which are set from the
+// arguments of the session constructors, not from options.
+var sessionKeys = map[string]bool{
+	"STYLE":          true,
+	"ID":             true,
+	"DESTINATION":    true,
+	"SIGNATURE_TYPE": true,
+	"FROM_PORT":      true,
+	"TO_PORT":        true,
+}
+
+// optionKey returns the key of a key=value option, or the whole option for
+// a flag without a value.
+func optionKey(opt string) string {
+	key, _, _ := strings.Cut(opt, "=")
+	return key
+}
+
+// MergeOptions merges lists of key=value options into one, a later list
+// overriding the options of the earlier ones with the same key. An option
+// keeps the position its key first appeared at, so the result does not
+// depend on map ordering. Entries holding several space separated options
+// are split, and empty ones dropped. Keys which are not known to I2PConfig
+// are passed through as they are.
+func MergeOptions(lists ...[]string) []string {
+	var merged []string
+	index := make(map[string]int)
+	for _, list := range lists {
+		for _, entry := range list {
+			for _, opt := range strings.Fields(entry) {
+				key := optionKey(opt)
+				if i, ok := index[key]; ok {
+					merged[i] = opt
+					continue
+				}
+				index[key] = len(merged)
+				merged = append(merged, opt)
+			}
+		}
+	}
+	return merged
+}
+
+// SessionOptions returns the options of a SESSION CREATE: those derived
+// from the configuration, overridden by the explicit options. Options for
+// the keys set from the constructor arguments, such as ID or DESTINATION,
+// are dropped.
+func (f *I2PConfig) SessionOptions(options []string) []string {
+	merged := MergeOptions(f.Print(), options)
+	opts := merged[:0]
+	for _, opt := range merged {
+		if sessionKeys[optionKey(opt)] {
+			log.WithField("option", opt).Warn("Ignoring option set by the session constructor")
+			continue
+		}
+		opts = append(opts, opt)
+	}
+	return opts
+}
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMergeOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		lists [][]string
+		want  []string
+	}{
+		{"empty", nil, nil},
+		{"override keeps position", [][]string{{"a=1", "b=2"}, {"b=3", "a=4"}}, []string{"a=4", "b=3"}},
+		{"passthrough", [][]string{{"a=1"}, {"streaming.maxWindowSize=64"}}, []string{"a=1", "streaming.maxWindowSize=64"}},
+		{"duplicates in one list", [][]string{{"a=1", "a=2"}}, []string{"a=2"}},
+		{"split and trim", [][]string{{" a=1  b=2 ", "", "  "}}, []string{"a=1", "b=2"}},
+		{"flags", [][]string{{"flag", "a=1"}, {"flag"}}, []string{"flag", "a=1"}},
+		{"keys are case sensitive", [][]string{{"a=1"}, {"A=2"}}, []string{"a=1", "A=2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeOptions(tt.lists...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MergeOptions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewGenericSession_Options(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		want    []string
+		wantNot []string
+	}{
+		{"config defaults", nil, []string{"inbound.length=3", "inbound.quantity=2"}, nil},
+		{"explicit options override", []string{"inbound.length=1", "inbound.quantity=1"},
+			[]string{"inbound.length=1", "inbound.quantity=1"}, []string{"inbound.length=3", "inbound.quantity=2"}},
+		{"passthrough", []string{"i2p.streaming.maxWindowSize=64"}, []string{"i2p.streaming.maxWindowSize=64"}, nil},
+		{"constructor keys", []string{"ID=other", "SIGNATURE_TYPE=7"}, nil, []string{"ID=other", "SIGNATURE_TYPE=7"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, sam := newCacheTestSAM(t)
+			sam.InLength, sam.InQuantity = 3, 2
+			keys, err := sam.NewKeys()
+			if err != nil {
+				t.Fatalf("NewKeys() error = %v", err)
+			}
+			if _, err := sam.NewGenericSession("STREAM", "options", keys, tt.options); err != nil {
+				t.Fatalf("NewGenericSession() error = %v", err)
+			}
+			var create []string
+			for _, cmd := range b.Commands() {
+				if strings.HasPrefix(cmd, "SESSION CREATE ") {
+					create = strings.Fields(cmd)
+				}
+			}
+			count := make(map[string]int)
+			for _, opt := range create {
+				count[optionKey(opt)]++
+			}
+			for key, n := range count {
+				if n > 1 {
+					t.Errorf("SESSION CREATE has %d %s options", n, key)
+				}
+			}
+			for _, opt := range tt.want {
+				if !slices.Contains(create, opt) {
+					t.Errorf("SESSION CREATE %q lacks %s", create, opt)
+				}
+			}
+			for _, opt := range tt.wantNot {
+				if slices.Contains(create, opt) {
+					t.Errorf("SESSION CREATE %q has %s", create, opt)
+				}
+			}
+		})
+	}
+}
+
+func TestSamOptionsString_Idle(t *testing.T) {
+	e := &SAMEmit{I2PConfig: I2PConfig{ReduceIdle: true, ReduceIdleTime: 15, CloseIdle: true, CloseIdleTime: 300}}
+	opts := strings.Fields(e.SamOptionsString())
+	for _, want := range []string{"i2cp.reduceOnIdle=true", "i2cp.reduceIdleTime=15", "i2cp.closeOnIdle=true", "i2cp.closeIdleTime=300"} {
+		if !slices.Contains(opts, want) {
+			t.Errorf("SamOptionsString() = %q, want %s", opts, want)
+		}
+	}
+}
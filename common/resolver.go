@@ -1,14 +1,21 @@
 package common
 
 import (
-	"bufio"
-	"bytes"
-	"errors"
+	"context"
 	"strings"
 
 	"github.com/go-i2p/i2pkeys"
+	"github.com/sirupsen/logrus"
 )
 
+// Resolver turns a name into a destination. A Resolver fails with an error
+// wrapping ErrKeyNotFound for names it does not know, so resolvers can be
+// combined, see ChainResolver. SAMResolver, CachingResolver and
+// HostsResolver are Resolvers.
+type Resolver interface {
+	ResolveContext(ctx context.Context, name string) (i2pkeys.I2PAddr, error)
+}
+
 func NewSAMResolver(parent *SAM) (*SAMResolver, error) {
 	log.Debug("Creating new SAMResolver from existing SAM instance")
 	var s SAMResolver
@@ -31,52 +38,77 @@ func NewFullSAMResolver(address string) (*SAMResolver, error) {
 // Performs a lookup, probably this order: 1) routers known addresses, cached
 // addresses, 3) by asking peers in the I2P network.
 func (sam *SAMResolver) Resolve(name string) (i2pkeys.I2PAddr, error) {
-	log.WithField("name", name).Debug("Resolving name")
+	ctx, cancel := sam.ContextWithTimeout()
+	defer cancel()
+	return sam.ResolveContext(ctx, name)
+}
 
-	if _, err := sam.Conn.Write([]byte("NAMING LOOKUP NAME=" + name + "\r\n")); err != nil {
-		log.WithError(err).Error("Failed to write to SAM connection")
-		sam.Close()
+// ResolveContext is like Resolve, but gives up when ctx is done.
+func (sam *SAMResolver) ResolveContext(ctx context.Context, name string) (i2pkeys.I2PAddr, error) {
+	result, err := sam.lookupContext(ctx, name, false)
+	if err != nil {
 		return i2pkeys.I2PAddr(""), err
 	}
-	buf := make([]byte, 4096)
-	n, err := sam.Conn.Read(buf)
+	return result.Addr, nil
+}
+
+// LookupResult is the reply to a NAMING LOOKUP.
+type LookupResult struct {
+	Name string
+	Addr i2pkeys.I2PAddr
+	// Options are the options published in the lease set of the
+	// destination, as returned for OPTIONS=true
+	Options map[string]string
+	// Result is the RESULT code of the reply, OK on success
+	Result string
+}
+
+// lookupOptionPrefix prefixes the keys of the lease set options in a NAMING REPLY.
+const lookupOptionPrefix = "OPTION:"
+
+// lookupContext sends a NAMING LOOKUP and parses the reply. For a failure
+// reported by the bridge, the result is returned along with the error.
+func (sam *SAMResolver) lookupContext(ctx context.Context, name string, withOptions bool) (*LookupResult, error) {
+	log.WithFields(logrus.Fields{"name": name, "options": withOptions}).Debug("Resolving name")
+	cmd := "NAMING LOOKUP NAME=" + name
+	if withOptions {
+		cmd += " OPTIONS=true"
+	}
+
+	reply, err := sam.CommandContext(ctx, cmd)
 	if err != nil {
-		log.WithError(err).Error("Failed to read from SAM connection")
-		sam.Close()
-		return i2pkeys.I2PAddr(""), err
+		// CommandContext already closed the connection if it is unusable,
+		// a running session survives an abandoned lookup
+		log.WithError(err).Error("Failed to talk to SAM")
+		return nil, err
 	}
-	if n <= 13 || !strings.HasPrefix(string(buf[:n]), "NAMING REPLY ") {
+	if !reply.Is("NAMING", "REPLY") {
 		log.Error("Failed to parse SAM response")
-		return i2pkeys.I2PAddr(""), errors.New("Failed to parse.")
+		return nil, UnexpectedReply(reply)
 	}
-	s := bufio.NewScanner(bytes.NewReader(buf[13:n]))
-	s.Split(bufio.ScanWords)
-
-	errStr := ""
-	for s.Scan() {
-		text := s.Text()
-		log.WithField("text", text).Debug("Parsing SAM response token")
-		// log.Println("SAM3", text)
-		if text == SAM_RESULT_OK {
-			continue
-		} else if text == SAM_RESULT_INVALID_KEY {
-			errStr += "Invalid key - resolver."
-			log.Error("Invalid key in resolver")
-		} else if text == SAM_RESULT_KEY_NOT_FOUND {
-			errStr += "Unable to resolve " + name
-			log.WithField("name", name).Error("Unable to resolve name")
-		} else if text == "NAME="+name {
-			continue
-		} else if strings.HasPrefix(text, "VALUE=") {
-			addr := i2pkeys.I2PAddr(text[6:])
-			log.WithField("addr", addr).Debug("Name resolved successfully")
-			return i2pkeys.I2PAddr(text[6:]), nil
-		} else if strings.HasPrefix(text, "MESSAGE=") {
-			errStr += " " + text[8:]
-			log.WithField("message", text[8:]).Warn("Received message from SAM")
-		} else {
-			continue
+	result := &LookupResult{Name: name, Result: reply.Result()}
+	if err := reply.Err(); err != nil {
+		log.WithFields(logrus.Fields{
+			"name":    name,
+			"result":  reply.Result(),
+			"message": reply.Get("MESSAGE"),
+		}).Error("Unable to resolve name")
+		return result, err
+	}
+	value := reply.Get("VALUE")
+	if value == "" {
+		log.WithField("name", name).Error("NAMING REPLY without VALUE")
+		return nil, UnexpectedReply(reply)
+	}
+	result.Addr = i2pkeys.I2PAddr(value)
+	for k, v := range reply.Args {
+		if key, ok := strings.CutPrefix(k, lookupOptionPrefix); ok {
+			if result.Options == nil {
+				result.Options = make(map[string]string)
+			}
+			result.Options[key] = v
 		}
 	}
-	return i2pkeys.I2PAddr(""), errors.New(errStr)
+	log.WithFields(logrus.Fields{"addr": result.Addr, "options": len(result.Options)}).Debug("Name resolved successfully")
+	return result, nil
 }
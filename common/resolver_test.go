@@ -0,0 +1,96 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-sam-go/samtest"
+	"github.com/go-i2p/i2pkeys"
+)
+
+func TestSAM_LookupOptions(t *testing.T) {
+	pub, _ := samtest.NewDestination()
+	published := map[string]string{"service": "web", "motd": "hello there"}
+	tests := []struct {
+		name        string
+		version     string
+		lookup      string
+		wantOptions map[string]string
+		wantResult  string
+		wantErr     error
+	}{
+		{"options", "3.3", "site.i2p", published, "OK", nil},
+		{"unknown name", "3.3", "missing.i2p", nil, "KEY_NOT_FOUND", ErrKeyNotFound},
+		{"old bridge", "3.2", "site.i2p", nil, "", ErrUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := samtest.NewBridge()
+			if err != nil {
+				t.Fatalf("NewBridge() error = %v", err)
+			}
+			defer b.Close()
+			b.SetVersion(tt.version)
+			b.AddName("site.i2p", pub)
+			b.SetOptions(pub, published)
+			// the pinned address must not hide the published options
+			sam, err := NewSAM(b.Addr(), SetResolver(StaticResolver{"site.i2p": i2pkeys.I2PAddr(pub)}))
+			if err != nil {
+				t.Fatalf("NewSAM() error = %v", err)
+			}
+			defer sam.Close()
+
+			result, err := sam.LookupOptions(tt.lookup)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("LookupOptions() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantResult == "" {
+				for _, cmd := range b.Commands() {
+					if strings.HasPrefix(cmd, "NAMING LOOKUP") {
+						t.Errorf("bridge saw %q, want no lookup", cmd)
+					}
+				}
+				return
+			}
+			if result == nil || result.Result != tt.wantResult || result.Name != tt.lookup {
+				t.Fatalf("LookupOptions() = %+v, want RESULT=%s", result, tt.wantResult)
+			}
+			if tt.wantErr == nil && string(result.Addr) != pub {
+				t.Errorf("LookupOptions() Addr = %.16s, want the destination", result.Addr)
+			}
+			if len(result.Options) != len(tt.wantOptions) {
+				t.Errorf("LookupOptions() Options = %v, want %v", result.Options, tt.wantOptions)
+			}
+			for k, v := range tt.wantOptions {
+				if result.Options[k] != v {
+					t.Errorf("Options[%s] = %q, want %q", k, result.Options[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestSAM_LookupSelf(t *testing.T) {
+	b, sam := newCacheTestSAM(t)
+	if _, err := sam.LookupSelf(); err == nil {
+		t.Error("LookupSelf() without a session succeeded")
+	}
+	keys, err := sam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	if _, err := sam.NewGenericSession("STREAM", "self", keys, nil); err != nil {
+		t.Fatalf("NewGenericSession() error = %v", err)
+	}
+	result, err := sam.LookupSelf()
+	if err != nil {
+		t.Fatalf("LookupSelf() error = %v", err)
+	}
+	if result.Addr != keys.Addr() || result.Result != "OK" {
+		t.Errorf("LookupSelf() = %+v, want the session's destination", result)
+	}
+	if cmds := b.Commands(); cmds[len(cmds)-1] != "NAMING LOOKUP NAME=ME" {
+		t.Errorf("last command = %q, want NAMING LOOKUP NAME=ME", cmds[len(cmds)-1])
+	}
+}
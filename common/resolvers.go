@@ -0,0 +1,84 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-i2p/i2pkeys"
+)
+
+// ResolverFunc adapts a function to a Resolver.
+type ResolverFunc func(ctx context.Context, name string) (i2pkeys.I2PAddr, error)
+
+func (f ResolverFunc) ResolveContext(ctx context.Context, name string) (i2pkeys.I2PAddr, error) {
+	return f(ctx, name)
+}
+
+// StaticResolver resolves the names of the map, to pin addresses in tests
+// or pre-seed names. Names are matched without regard to case.
+type StaticResolver map[string]i2pkeys.I2PAddr
+
+func (s StaticResolver) ResolveContext(ctx context.Context, name string) (i2pkeys.I2PAddr, error) {
+	if addr, ok := s[name]; ok {
+		return addr, nil
+	}
+	for n, addr := range s {
+		if strings.EqualFold(n, name) {
+			return addr, nil
+		}
+	}
+	return i2pkeys.I2PAddr(""), fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+}
+
+// ChainResolver asks each resolver in turn, until one resolves the name. If
+// none does, the error wraps the failures of all of them, so it wraps
+// ErrKeyNotFound if any of them did not know the name.
+type ChainResolver []Resolver
+
+func (c ChainResolver) ResolveContext(ctx context.Context, name string) (i2pkeys.I2PAddr, error) {
+	var errs []error
+	for _, r := range c {
+		addr, err := r.ResolveContext(ctx, name)
+		if err == nil {
+			return addr, nil
+		}
+		if ctx.Err() != nil {
+			return i2pkeys.I2PAddr(""), err
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return i2pkeys.I2PAddr(""), fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	return i2pkeys.I2PAddr(""), errors.Join(errs...)
+}
+
+// SuffixResolver routes each name to the resolver of its longest matching
+// domain suffix: "example.i2p" gets the names example.i2p and
+// *.example.i2p, "b32.i2p" gets every .b32.i2p address. The resolver of ""
+// gets the names no suffix matches; without one they fail with
+// ErrKeyNotFound.
+type SuffixResolver map[string]Resolver
+
+func (s SuffixResolver) ResolveContext(ctx context.Context, name string) (i2pkeys.I2PAddr, error) {
+	lower := strings.ToLower(name)
+	for domain := lower; ; {
+		if r, ok := s[domain]; ok && domain != "" {
+			return r.ResolveContext(ctx, name)
+		}
+		if r, ok := s["."+domain]; ok && domain != "" {
+			return r.ResolveContext(ctx, name)
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+	}
+	if r, ok := s[""]; ok {
+		return r.ResolveContext(ctx, name)
+	}
+	return i2pkeys.I2PAddr(""), fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+}
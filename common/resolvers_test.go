@@ -0,0 +1,147 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-sam-go/samtest"
+	"github.com/go-i2p/i2pkeys"
+)
+
+func TestResolvers(t *testing.T) {
+	a, _ := samtest.NewDestination()
+	b, _ := samtest.NewDestination()
+	c, _ := samtest.NewDestination()
+	errDown := errors.New("bridge down")
+	static := StaticResolver{"a.i2p": i2pkeys.I2PAddr(a), "Mixed.i2p": i2pkeys.I2PAddr(c)}
+	other := StaticResolver{"a.i2p": i2pkeys.I2PAddr(b), "b.i2p": i2pkeys.I2PAddr(b)}
+	anyB := ResolverFunc(func(ctx context.Context, name string) (i2pkeys.I2PAddr, error) {
+		return i2pkeys.I2PAddr(b), nil
+	})
+	down := ResolverFunc(func(ctx context.Context, name string) (i2pkeys.I2PAddr, error) {
+		return i2pkeys.I2PAddr(""), errDown
+	})
+
+	tests := []struct {
+		name     string
+		resolver Resolver
+		lookup   string
+		want     string
+		wantErr  []error
+	}{
+		{"static", static, "a.i2p", a, nil},
+		{"static ignores case", static, "mixed.I2P", c, nil},
+		{"static unknown", static, "b.i2p", "", []error{ErrKeyNotFound}},
+		{"chain first wins", ChainResolver{static, other}, "a.i2p", a, nil},
+		{"chain falls through", ChainResolver{static, other}, "b.i2p", b, nil},
+		{"chain past failures", ChainResolver{down, other}, "b.i2p", b, nil},
+		{"chain unknown", ChainResolver{down, static}, "c.i2p", "", []error{errDown, ErrKeyNotFound}},
+		{"empty chain", ChainResolver{}, "a.i2p", "", []error{ErrKeyNotFound}},
+		{"suffix", SuffixResolver{"i2p": static, "a.i2p": other}, "a.i2p", b, nil},
+		{"suffix subdomain", SuffixResolver{"i2p": static, ".a.i2p": anyB}, "www.A.i2p", b, nil},
+		{"suffix parent", SuffixResolver{"i2p": static, "www.a.i2p": other}, "a.i2p", a, nil},
+		{"suffix default", SuffixResolver{"onion": down, "": other}, "b.i2p", b, nil},
+		{"suffix no match", SuffixResolver{"onion": down}, "b.i2p", "", []error{ErrKeyNotFound}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.resolver.ResolveContext(context.Background(), tt.lookup)
+			if len(tt.wantErr) == 0 && err != nil {
+				t.Fatalf("ResolveContext() error = %v", err)
+			}
+			for _, want := range tt.wantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("ResolveContext() error = %v, want %v", err, want)
+				}
+			}
+			if string(got) != tt.want {
+				t.Errorf("ResolveContext() = %.16s, want %.16s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetResolver(t *testing.T) {
+	pinned, _ := samtest.NewDestination()
+	known, _ := samtest.NewDestination()
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	b.AddName("site.i2p", known)
+	b.AddName("pinned.i2p", known)
+	sam, err := NewSAM(b.Addr(), SetResolver(StaticResolver{"pinned.i2p": i2pkeys.I2PAddr(pinned)}))
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	defer sam.Close()
+
+	if got, err := sam.Lookup("pinned.i2p"); err != nil || string(got) != pinned {
+		t.Errorf("Lookup() of a pinned name = %.16s, %v, want the pinned destination", got, err)
+	}
+	if got, err := sam.Lookup("site.i2p"); err != nil || string(got) != known {
+		t.Errorf("Lookup() of another name = %.16s, %v, want the bridge's destination", got, err)
+	}
+	for _, cmd := range b.Commands() {
+		if strings.Contains(cmd, "NAME=pinned.i2p") {
+			t.Error("pinned name was looked up on the bridge")
+		}
+	}
+	if _, err := NewSAM(b.Addr(), SetResolver(nil)); err == nil {
+		t.Error("NewSAM() accepted a nil resolver")
+	}
+}
+
+func TestSetResolver_Reconnect(t *testing.T) {
+	pinned, _ := samtest.NewDestination()
+	hosted, _ := samtest.NewDestination()
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	sam, err := NewSAM(b.Addr(), SetResolver(StaticResolver{"pinned.i2p": i2pkeys.I2PAddr(pinned)}))
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	defer sam.Close()
+	if sam.Hosts, err = NewHostsResolver(); err != nil {
+		t.Fatalf("NewHostsResolver() error = %v", err)
+	}
+	if err := sam.Hosts.Add("hosted.i2p", i2pkeys.I2PAddr(hosted)); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		reopen func() (*SAM, error)
+	}{
+		{"Redial", sam.Redial},
+		{"ReconnectContext", func() (*SAM, error) {
+			return sam.ReconnectContext(context.Background())
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, err := tt.reopen()
+			if err != nil {
+				t.Fatalf("%s() error = %v", tt.name, err)
+			}
+			defer next.Close()
+			if got, err := next.Lookup("pinned.i2p"); err != nil || string(got) != pinned {
+				t.Errorf("Lookup() of a pinned name = %.16s, %v, want the pinned destination", got, err)
+			}
+			if got, err := next.Lookup("hosted.i2p"); err != nil || string(got) != hosted {
+				t.Errorf("Lookup() of a hosts name = %.16s, %v, want the hosts destination", got, err)
+			}
+		})
+	}
+	for _, cmd := range b.Commands() {
+		if strings.Contains(cmd, "NAME=pinned.i2p") || strings.Contains(cmd, "NAME=hosted.i2p") {
+			t.Errorf("%q was sent to the bridge", cmd)
+		}
+	}
+}
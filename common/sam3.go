@@ -1,13 +1,13 @@
 package common
 
 import (
+	"context"
 	"fmt"
 	"net"
-	"strings"
 )
 
-func connectToSAM(address string) (net.Conn, error) {
-	conn, err := net.Dial("tcp", address)
+func connectToSAM(ctx context.Context, config *I2PConfig, address string) (net.Conn, error) {
+	conn, err := config.dial(ctx, address)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to SAM bridge at %s: %w", address, err)
 	}
@@ -19,20 +19,24 @@ func sendHelloAndValidate(conn net.Conn, s *SAM) error {
 		return fmt.Errorf("failed to send hello message: %w", err)
 	}
 
-	buf := make([]byte, 256)
-	n, err := conn.Read(buf)
+	reply, err := s.ReadMessage()
 	if err != nil {
 		return fmt.Errorf("failed to read SAM response: %w", err)
 	}
 
-	response := string(buf[:n])
-	switch {
-	case strings.Contains(response, HELLO_REPLY_OK):
-		log.Debug("SAM hello successful")
-		return nil
-	case response == HELLO_REPLY_NOVERSION:
-		return fmt.Errorf("SAM bridge does not support SAMv3")
-	default:
-		return fmt.Errorf("unexpected SAM response: %s", response)
+	if !reply.Is("HELLO", "REPLY") {
+		return UnexpectedReply(reply)
 	}
+	if err := reply.Err(); err != nil {
+		if reply.Result() == "I2P_ERROR" {
+			// the only I2P_ERROR a bridge sends for HELLO is a rejected
+			// or missing USER/PASSWORD
+			log.WithField("message", reply.Get("MESSAGE")).Error("SAM authentication failed")
+			return fmt.Errorf("%w: %w", ErrAuthFailed, err)
+		}
+		return err
+	}
+	s.SAMEmit.I2PConfig.version = reply.Get("VERSION")
+	log.WithField("version", s.Version()).Debug("SAM hello successful")
+	return nil
 }
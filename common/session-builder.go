@@ -0,0 +1,197 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/go-i2p/i2pkeys"
+	"github.com/sirupsen/logrus"
+)
+
+// SessionBuilder creates a session of any style from the configuration of
+// a SAM, changed with the Set* options NewSAM takes:
+//
+//	conn, err := sam.NewSessionBuilder(SESSION_STYLE_STREAM).
+//		ID("tunnel").
+//		Transient().
+//		With(SetInLength(2), SetCloseIdle(true)).
+//		Create()
+//
+// The builder changes a copy of the configuration; the SAM's is only
+// updated once the bridge created the session. The first error of an
+// option is returned by Create.
+type SessionBuilder struct {
+	sam  *SAM
+	emit SAMEmit
+	err  error
+}
+
+// NewSessionBuilder returns a builder for a session of style, which starts
+// from the SAM's configuration, including its keys if it has some.
+func (sam *SAM) NewSessionBuilder(style string) *SessionBuilder {
+	b := &SessionBuilder{sam: sam, emit: sam.SAMEmit}
+	b.emit.Extras = append([]string(nil), sam.Extras...)
+	return b.With(SetType(style))
+}
+
+// With applies opts to the configuration of the session.
+func (b *SessionBuilder) With(opts ...func(*SAMEmit) error) *SessionBuilder {
+	for _, o := range opts {
+		if b.err != nil {
+			break
+		}
+		b.err = o(&b.emit)
+	}
+	return b
+}
+
+// ID sets the name of the session.
+func (b *SessionBuilder) ID(id string) *SessionBuilder {
+	return b.With(SetName(id))
+}
+
+// Keys sets the destination of the session.
+func (b *SessionBuilder) Keys(keys i2pkeys.I2PKeys) *SessionBuilder {
+	return b.With(SetKeys(keys))
+}
+
+// Transient makes the bridge generate a destination for the session.
+func (b *SessionBuilder) Transient() *SessionBuilder {
+	return b.With(SetTransient())
+}
+
+// Ports sets the virtual ports of the session, "0" for none.
+func (b *SessionBuilder) Ports(from, to string) *SessionBuilder {
+	return b.With(SetFromPort(from), SetToPort(to))
+}
+
+// SigType requires keys of t, or makes a transient destination of t.
+// SIG_DEFAULT keeps the SAM's SigType.
+func (b *SessionBuilder) SigType(t SigType) *SessionBuilder {
+	if t == SIG_DEFAULT {
+		return b
+	}
+	return b.With(SetSigType(t))
+}
+
+// Options adds options sent as they are, see SetExtras.
+func (b *SessionBuilder) Options(opts ...string) *SessionBuilder {
+	return b.With(SetExtras(opts...))
+}
+
+// Command validates the configuration and returns the SESSION CREATE it
+// sends.
+func (b *SessionBuilder) Command() (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+	e := &b.emit
+	if strings.ContainsAny(e.TunName, " \t\r\n\"=") {
+		return "", fmt.Errorf("Invalid session ID %q", e.TunName)
+	}
+	if err := b.sam.requireSession(e.Style, e.Fromport, e.Toport); err != nil {
+		return "", err
+	}
+	sig, err := b.sam.signatureType(e.SigType)
+	if err != nil {
+		return "", err
+	}
+	if e.DestinationKeys != nil {
+		if err := keysOfType(*e.DestinationKeys, sig); err != nil {
+			log.WithError(err).Error("Keys do not match the requested signature type")
+			return "", err
+		}
+	}
+	if _, err := e.leaseSetEncTypes(); err != nil {
+		return "", err
+	}
+	if e.Style == SESSION_STYLE_DATAGRAM || e.Style == SESSION_STYLE_RAW {
+		hasPort := false
+		for _, opt := range e.Extras {
+			hasPort = hasPort || optionKey(opt) == "PORT"
+		}
+		if !hasPort {
+			return "", fmt.Errorf("%s sessions need the PORT datagrams are forwarded to", e.Style)
+		}
+	}
+	return e.Create(), nil
+}
+
+// Create creates the session, giving up after the SAM's Timeout. It
+// returns the control connection, which the session lives on.
+func (b *SessionBuilder) Create() (net.Conn, error) {
+	ctx, cancel := b.sam.ContextWithTimeout()
+	defer cancel()
+	return b.CreateContext(ctx)
+}
+
+// CreateContext is like Create, but gives up when ctx is done. The control
+// connection is closed in that case. Once the session exists, the SAM's
+// DestinationKeys are its keys, those the bridge generated for a transient
+// destination.
+func (b *SessionBuilder) CreateContext(ctx context.Context) (net.Conn, error) {
+	sam, e := b.sam, &b.emit
+	log.WithFields(logrus.Fields{"style": e.Style, "id": e.TunName, "from": e.Fromport, "to": e.Toport, "sigType": e.SigType}).Debug("Creating new session")
+	scmsg, err := b.Command()
+	if err != nil {
+		log.WithError(err).Error("Invalid session configuration")
+		return nil, err
+	}
+	conn := sam.Conn
+
+	var keys i2pkeys.I2PKeys
+	created := func(reply *Message) bool {
+		if !reply.Is("SESSION", "STATUS") || reply.Result() != "OK" {
+			return false
+		}
+		if e.DestinationKeys != nil {
+			keys = *e.DestinationKeys
+			return keys.String() == reply.Get("DESTINATION")
+		}
+		var err error
+		keys, err = keysFromPrivate(reply.Get("DESTINATION"))
+		return err == nil
+	}
+	reply, err := sam.dispatch(ctx, scmsg, func(reply *Message) {
+		if !created(reply) {
+			return
+		}
+		sam.SAMEmit.I2PConfig.Style = e.Style
+		sam.SAMEmit.I2PConfig.TunName = e.TunName
+		sam.SAMEmit.I2PConfig.Fromport = e.Fromport
+		sam.SAMEmit.I2PConfig.Toport = e.Toport
+		sam.SAMEmit.I2PConfig.DestinationKeys = &keys
+		// before any other command reads a reply
+		sam.startMonitor()
+	})
+	if err != nil {
+		log.WithError(err).Error("Failed to create session")
+		conn.Close()
+		return nil, err
+	}
+	log.WithField("response", reply.String()).Debug("Received SAM response")
+	if !reply.Is("SESSION", "STATUS") {
+		log.WithField("reply", reply.String()).Error("Unable to parse SAMv3 reply")
+		conn.Close()
+		return nil, UnexpectedReply(reply)
+	}
+	switch reply.Result() {
+	case "OK":
+		if !created(reply) {
+			log.Error("SAM created a tunnel with different keys than requested")
+			conn.Close()
+			return nil, fmt.Errorf("SAMv3 created a tunnel with keys other than the ones we asked it for")
+		}
+		log.WithField("destination", keys.Addr().Base32()).Debug("Successfully created new session")
+		return conn, nil
+	default:
+		log.WithFields(logrus.Fields{
+			"result":  reply.Result(),
+			"message": reply.Get("MESSAGE"),
+		}).Error("Failed to create session")
+		conn.Close()
+		return nil, reply.Err()
+	}
+}
@@ -0,0 +1,124 @@
+package common
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/i2pkeys"
+)
+
+func TestSessionBuilder_Command(t *testing.T) {
+	_, sam := newCacheTestSAM(t)
+	keys, err := sam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		build   func() *SessionBuilder
+		want    []string
+		wantErr error
+	}{
+		{"transient", func() *SessionBuilder {
+			return sam.NewSessionBuilder(SESSION_STYLE_STREAM).ID("t").Transient()
+		}, []string{"STYLE=STREAM", "ID=t", "DESTINATION=TRANSIENT"}, nil},
+		{"keys and ports", func() *SessionBuilder {
+			return sam.NewSessionBuilder(SESSION_STYLE_STREAM).ID("k").Keys(keys).Ports("80", "0")
+		}, []string{"FROM_PORT=80", "DESTINATION=" + keys.String()}, nil},
+		{"transient signature type", func() *SessionBuilder {
+			return sam.NewSessionBuilder(SESSION_STYLE_STREAM).ID("s").Transient().SigType(SIG_EdDSA_SHA512_Ed25519)
+		}, []string{"SIGNATURE_TYPE=EdDSA_SHA512_Ed25519"}, nil},
+		{"set options", func() *SessionBuilder {
+			return sam.NewSessionBuilder(SESSION_STYLE_PRIMARY).ID("p").Transient().With(SetInLength(1)).Options("inbound.quantity=4")
+		}, []string{"STYLE=PRIMARY", "inbound.length=1", "inbound.quantity=4"}, nil},
+		{"datagram", func() *SessionBuilder {
+			return sam.NewSessionBuilder(SESSION_STYLE_DATAGRAM).ID("d").Transient().Options("PORT=7655")
+		}, []string{"STYLE=DATAGRAM", "PORT=7655"}, nil},
+		{"datagram without PORT", func() *SessionBuilder {
+			return sam.NewSessionBuilder(SESSION_STYLE_DATAGRAM).ID("d").Transient()
+		}, nil, errors.New("PORT")},
+		{"invalid style", func() *SessionBuilder {
+			return sam.NewSessionBuilder("TCP").ID("x")
+		}, nil, errors.New("STYLE")},
+		{"invalid ID", func() *SessionBuilder {
+			return sam.NewSessionBuilder(SESSION_STYLE_STREAM).ID("two words").Transient()
+		}, nil, errors.New("session ID")},
+		{"invalid port", func() *SessionBuilder {
+			return sam.NewSessionBuilder(SESSION_STYLE_STREAM).ID("x").Ports("70000", "0")
+		}, nil, errors.New("port")},
+		{"invalid keys", func() *SessionBuilder {
+			return sam.NewSessionBuilder(SESSION_STYLE_STREAM).ID("x").Keys(i2pkeys.I2PKeys{})
+		}, nil, ErrInvalidKey},
+		{"keys of another signature type", func() *SessionBuilder {
+			return sam.NewSessionBuilder(SESSION_STYLE_STREAM).ID("x").Keys(keys).SigType(SIG_EdDSA_SHA512_Ed25519)
+		}, nil, ErrInvalidSigType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := tt.build().Command()
+			if tt.wantErr != nil {
+				if err == nil || (!errors.Is(err, tt.wantErr) && !strings.Contains(err.Error(), tt.wantErr.Error())) {
+					t.Errorf("Command() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Command() error = %v", err)
+			}
+			if !strings.HasPrefix(cmd, "SESSION CREATE ") || !strings.HasSuffix(cmd, "\n") || strings.Contains(cmd, "  ") {
+				t.Errorf("Command() = %q, want a single spaced SESSION CREATE line", cmd)
+			}
+			fields := strings.Fields(cmd)
+			for _, want := range tt.want {
+				if !slices.Contains(fields, want) {
+					t.Errorf("Command() = %q, lacks %s", cmd, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionBuilder_CreateTransient(t *testing.T) {
+	for _, sig := range []SigType{SIG_DEFAULT, SIG_EdDSA_SHA512_Ed25519} {
+		t.Run("SIGNATURE_TYPE="+string(sig), func(t *testing.T) {
+			b, sam := newCacheTestSAM(t)
+			if _, err := sam.NewSessionBuilder(SESSION_STYLE_STREAM).ID("transient").Transient().SigType(sig).Create(); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			keys := sam.Keys()
+			if keys == nil {
+				t.Fatal("Keys() = nil after creating a transient session")
+			}
+			self, err := sam.LookupSelf()
+			if err != nil {
+				t.Fatalf("LookupSelf() error = %v", err)
+			}
+			if keys.Addr() != self.Addr || keys.String() == "" {
+				t.Errorf("Keys() = %.16s, want the destination the bridge generated", keys.Addr().Base64())
+			}
+			if err := keysOfType(*keys, sig); err != nil {
+				t.Errorf("transient keys: %v", err)
+			}
+			if ids := b.Sessions(); len(ids) != 1 || ids[0] != "transient" {
+				t.Errorf("bridge sessions = %q, want transient", ids)
+			}
+			if sam.TunName != "transient" {
+				t.Errorf("TunName = %q, want transient", sam.TunName)
+			}
+		})
+	}
+}
+
+func TestSetType(t *testing.T) {
+	for _, style := range []string{SESSION_STYLE_STREAM, SESSION_STYLE_DATAGRAM, SESSION_STYLE_RAW, SESSION_STYLE_PRIMARY, SESSION_STYLE_MASTER} {
+		emit := &SAMEmit{}
+		if err := SetType(style)(emit); err != nil || emit.Style != style {
+			t.Errorf("SetType(%s) error = %v, Style = %q", style, err, emit.Style)
+		}
+	}
+	if err := SetType("TCP")(&SAMEmit{}); err == nil {
+		t.Error("SetType(TCP) accepted an invalid style")
+	}
+}
@@ -0,0 +1,282 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-i2p/i2pkeys"
+)
+
+// SigType is the signature type of an I2P destination, named as SAM expects
+// it in SIGNATURE_TYPE. The zero value, SIG_DEFAULT, leaves the choice to
+// the router for new keys and to the keys themselves for sessions.
+type SigType string
+
+const (
+	SIG_DEFAULT                SigType = ""
+	SIG_DSA_SHA1               SigType = "DSA_SHA1"
+	SIG_ECDSA_SHA256_P256      SigType = "ECDSA_SHA256_P256"
+	SIG_ECDSA_SHA384_P384      SigType = "ECDSA_SHA384_P384"
+	SIG_ECDSA_SHA512_P521      SigType = "ECDSA_SHA512_P521"
+	SIG_RSA_SHA256_2048        SigType = "RSA_SHA256_2048"
+	SIG_RSA_SHA384_3072        SigType = "RSA_SHA384_3072"
+	SIG_RSA_SHA512_4096        SigType = "RSA_SHA512_4096"
+	SIG_EdDSA_SHA512_Ed25519   SigType = "EdDSA_SHA512_Ed25519"
+	SIG_EdDSA_SHA512_Ed25519ph SigType = "EdDSA_SHA512_Ed25519ph"
+	SIG_RedDSA_SHA512_Ed25519  SigType = "RedDSA_SHA512_Ed25519"
+
+	// SIG_NONE is kept for compatibility, it asks for the recommended
+	// EdDSA_SHA512_Ed25519
+	SIG_NONE = SIG_EdDSA_SHA512_Ed25519
+)
+
+// sigTypeCodes are the numeric codes of the signature types, as found in
+// key certificates and accepted by SAM in place of the name.
+var sigTypeCodes = map[SigType]int{
+	SIG_DSA_SHA1:               0,
+	SIG_ECDSA_SHA256_P256:      1,
+	SIG_ECDSA_SHA384_P384:      2,
+	SIG_ECDSA_SHA512_P521:      3,
+	SIG_RSA_SHA256_2048:        4,
+	SIG_RSA_SHA384_3072:        5,
+	SIG_RSA_SHA512_4096:        6,
+	SIG_EdDSA_SHA512_Ed25519:   7,
+	SIG_EdDSA_SHA512_Ed25519ph: 8,
+	SIG_RedDSA_SHA512_Ed25519:  11,
+}
+
+// ParseSigType returns the SigType named by s. s may be a name, matched
+// regardless of case, a numeric code or either prefixed with
+// "SIGNATURE_TYPE=". An empty s is SIG_DEFAULT.
+func ParseSigType(s string) (SigType, error) {
+	s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "SIGNATURE_TYPE="))
+	if s == "" {
+		return SIG_DEFAULT, nil
+	}
+	if code, err := strconv.Atoi(s); err == nil {
+		for t, c := range sigTypeCodes {
+			if c == code {
+				return t, nil
+			}
+		}
+		return SIG_DEFAULT, fmt.Errorf("%w: %d", ErrInvalidSigType, code)
+	}
+	for t := range sigTypeCodes {
+		if strings.EqualFold(string(t), s) {
+			return t, nil
+		}
+	}
+	return SIG_DEFAULT, fmt.Errorf("%w: %q", ErrInvalidSigType, s)
+}
+
+// Code returns the numeric code of t, or -1 for SIG_DEFAULT and unknown
+// types.
+func (t SigType) Code() int {
+	if code, ok := sigTypeCodes[t]; ok {
+		return code
+	}
+	return -1
+}
+
+// Validate returns an error wrapping ErrInvalidSigType if t is not a known
+// signature type or SIG_DEFAULT.
+func (t SigType) Validate() error {
+	if t == SIG_DEFAULT {
+		return nil
+	}
+	_, err := ParseSigType(string(t))
+	return err
+}
+
+// normalized returns the canonical name of t, which may have been built
+// from a string such as "SIGNATURE_TYPE=7".
+func (t SigType) normalized() (SigType, error) {
+	if _, ok := sigTypeCodes[t]; ok {
+		return t, nil
+	}
+	return ParseSigType(string(t))
+}
+
+func (t SigType) String() string {
+	return string(t)
+}
+
+// EncType is an encryption type of an I2P destination or lease set, as
+// used in i2cp.leaseSetEncType.
+type EncType int
+
+const (
+	ENC_ELGAMAL_2048     EncType = 0
+	ENC_ECIES_X25519     EncType = 4
+	ENC_MLKEM512_X25519  EncType = 5
+	ENC_MLKEM768_X25519  EncType = 6
+	ENC_MLKEM1024_X25519 EncType = 7
+)
+
+// DEFAULT_LEASESET_ENCS is the i2cp.leaseSetEncType sent unless changed:
+// ECIES_X25519, with ElGamal for older routers.
+const DEFAULT_LEASESET_ENCS = "4,0"
+
+var encTypeNames = map[EncType]string{
+	ENC_ELGAMAL_2048:     "ELGAMAL_2048",
+	ENC_ECIES_X25519:     "ECIES_X25519",
+	ENC_MLKEM512_X25519:  "MLKEM512_X25519",
+	ENC_MLKEM768_X25519:  "MLKEM768_X25519",
+	ENC_MLKEM1024_X25519: "MLKEM1024_X25519",
+}
+
+// ParseEncType returns the EncType named by s, either a numeric code or a
+// name matched regardless of case.
+func ParseEncType(s string) (EncType, error) {
+	s = strings.TrimSpace(s)
+	if code, err := strconv.Atoi(s); err == nil {
+		t := EncType(code)
+		return t, t.Validate()
+	}
+	for t, name := range encTypeNames {
+		if strings.EqualFold(name, s) {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrInvalidEncType, s)
+}
+
+// ParseEncTypes parses a comma separated list of encryption types, as in
+// i2cp.leaseSetEncType.
+func ParseEncTypes(s string) ([]EncType, error) {
+	var types []EncType
+	for _, field := range strings.Split(s, ",") {
+		t, err := ParseEncType(field)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// Validate returns an error wrapping ErrInvalidEncType if t is not a known
+// encryption type.
+func (t EncType) Validate() error {
+	if _, ok := encTypeNames[t]; !ok {
+		return fmt.Errorf("%w: %d", ErrInvalidEncType, int(t))
+	}
+	return nil
+}
+
+func (t EncType) String() string {
+	if name, ok := encTypeNames[t]; ok {
+		return name
+	}
+	return strconv.Itoa(int(t))
+}
+
+// joinEncTypes formats types for i2cp.leaseSetEncType.
+func joinEncTypes(types []EncType) string {
+	codes := make([]string, len(types))
+	for i, t := range types {
+		codes[i] = strconv.Itoa(int(t))
+	}
+	return strings.Join(codes, ",")
+}
+
+const (
+	// public encryption key and signing key, before the certificate
+	destKeysLen = 384
+	certNull    = 0
+	certKey     = 5
+)
+
+// DestinationTypes returns the signature and encryption types of addr, read
+// from its certificate. Destinations with a NULL certificate are DSA_SHA1
+// and ElGamal.
+func DestinationTypes(addr i2pkeys.I2PAddr) (SigType, EncType, error) {
+	raw, err := addr.ToBytes()
+	if err != nil {
+		return SIG_DEFAULT, 0, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+	if len(raw) < destKeysLen+3 {
+		return SIG_DEFAULT, 0, fmt.Errorf("%w: destination too short", ErrInvalidKey)
+	}
+	cert := raw[destKeysLen:]
+	switch cert[0] {
+	case certNull:
+		return SIG_DSA_SHA1, ENC_ELGAMAL_2048, nil
+	case certKey:
+		if len(cert) < 7 {
+			return SIG_DEFAULT, 0, fmt.Errorf("%w: truncated key certificate", ErrInvalidKey)
+		}
+		code := strconv.Itoa(int(binary.BigEndian.Uint16(cert[3:5])))
+		sig, err := ParseSigType(code)
+		if err != nil {
+			return SIG_DEFAULT, 0, err
+		}
+		return sig, EncType(binary.BigEndian.Uint16(cert[5:7])), nil
+	default:
+		return SIG_DEFAULT, 0, fmt.Errorf("%w: certificate type %d", ErrInvalidKey, cert[0])
+	}
+}
+
+// keysFromPrivate returns the keys whose base64 private key blob is priv, as
+// sent back by SESSION CREATE with DESTINATION=TRANSIENT: the destination,
+// with its certificate, followed by the private keys.
+func keysFromPrivate(priv string) (i2pkeys.I2PKeys, error) {
+	raw, err := i2pkeys.I2PAddr(priv).ToBytes()
+	if err != nil {
+		return i2pkeys.I2PKeys{}, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+	if len(raw) < destKeysLen+3 {
+		return i2pkeys.I2PKeys{}, fmt.Errorf("%w: private key too short", ErrInvalidKey)
+	}
+	end := destKeysLen + 3 + int(binary.BigEndian.Uint16(raw[destKeysLen+1:destKeysLen+3]))
+	if len(raw) <= end {
+		return i2pkeys.I2PKeys{}, fmt.Errorf("%w: truncated private key", ErrInvalidKey)
+	}
+	addr, err := i2pkeys.NewI2PAddrFromBytes(raw[:end])
+	if err != nil {
+		return i2pkeys.I2PKeys{}, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+	return i2pkeys.NewKeys(addr, priv), nil
+}
+
+// signatureType validates t and checks that the bridge supports choosing
+// a signature type.
+func (sam *SAM) signatureType(t SigType) (SigType, error) {
+	sig, err := t.normalized()
+	if err != nil {
+		return SIG_DEFAULT, err
+	}
+	if sig != SIG_DEFAULT {
+		if err := sam.Require(FeatureSignatureType); err != nil {
+			return SIG_DEFAULT, err
+		}
+	}
+	return sig, nil
+}
+
+// keysOfType fails with ErrInvalidSigType unless keys are of sig. Any keys
+// are of SIG_DEFAULT.
+func keysOfType(keys i2pkeys.I2PKeys, sig SigType) error {
+	if sig == SIG_DEFAULT {
+		return nil
+	}
+	have, _, err := DestinationTypes(keys.Addr())
+	if err != nil {
+		return err
+	}
+	if have != sig {
+		return fmt.Errorf("%w: keys are %s, not %s", ErrInvalidSigType, have, sig)
+	}
+	return nil
+}
+
+// leaseSetEncTypes returns the lease set encryption types configured with
+// SetLeaseSetEncType, DEFAULT_LEASESET_ENCS if none were.
+func (f *I2PConfig) leaseSetEncTypes() ([]EncType, error) {
+	if f.LeaseSetEncryption == "" {
+		return ParseEncTypes(DEFAULT_LEASESET_ENCS)
+	}
+	return ParseEncTypes(f.LeaseSetEncryption)
+}
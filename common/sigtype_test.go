@@ -0,0 +1,176 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-sam-go/samtest"
+)
+
+func TestParseSigType(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    SigType
+		wantErr bool
+	}{
+		{"", SIG_DEFAULT, false},
+		{"EdDSA_SHA512_Ed25519", SIG_EdDSA_SHA512_Ed25519, false},
+		{"eddsa_sha512_ed25519", SIG_EdDSA_SHA512_Ed25519, false},
+		{"7", SIG_EdDSA_SHA512_Ed25519, false},
+		{"SIGNATURE_TYPE=ECDSA_SHA256_P256", SIG_ECDSA_SHA256_P256, false},
+		{"SIGNATURE_TYPE=11", SIG_RedDSA_SHA512_Ed25519, false},
+		{"0", SIG_DSA_SHA1, false},
+		{"9", SIG_DEFAULT, true},
+		{"Ed448", SIG_DEFAULT, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSigType(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSigType(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrInvalidSigType) {
+			t.Errorf("ParseSigType(%q) error = %v, want ErrInvalidSigType", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("ParseSigType(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if got := SIG_EdDSA_SHA512_Ed25519.Code(); got != 7 {
+		t.Errorf("Code() = %d, want 7", got)
+	}
+}
+
+func TestLeaseSetEncType(t *testing.T) {
+	tests := []struct {
+		name    string
+		types   []EncType
+		want    string
+		wantErr bool
+	}{
+		{"default", nil, "i2cp.leaseSetEncType=4,0", false},
+		{"ECIES only", []EncType{ENC_ECIES_X25519}, "i2cp.leaseSetEncType=4", false},
+		{"post-quantum first", []EncType{ENC_MLKEM768_X25519, ENC_ECIES_X25519}, "i2cp.leaseSetEncType=6,4", false},
+		{"unknown type", []EncType{3}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e SAMEmit
+			if tt.types != nil {
+				err := SetLeaseSetEncType(tt.types...)(&e)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("SetLeaseSetEncType() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err != nil {
+					return
+				}
+			}
+			if got := e.LeaseSetEncryptionType(); got != tt.want {
+				t.Errorf("LeaseSetEncryptionType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// a hand-set invalid value no longer panics, sessions refuse it
+	e := SAMEmit{I2PConfig: I2PConfig{LeaseSetEncryption: "4,x"}}
+	if got := e.LeaseSetEncryptionType(); got != "i2cp.leaseSetEncType=4,0" {
+		t.Errorf("LeaseSetEncryptionType() = %q, want the default", got)
+	}
+}
+
+func TestNewKeys_SigType(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	sam, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	defer sam.Close()
+
+	tests := []struct {
+		sigType SigType
+		want    SigType
+		wantCmd string
+	}{
+		{SIG_DEFAULT, SIG_DSA_SHA1, "DEST GENERATE"},
+		{SIG_EdDSA_SHA512_Ed25519, SIG_EdDSA_SHA512_Ed25519, "DEST GENERATE SIGNATURE_TYPE=EdDSA_SHA512_Ed25519"},
+		// constants of older releases carried the SIGNATURE_TYPE= prefix
+		{"SIGNATURE_TYPE=ECDSA_SHA256_P256", SIG_ECDSA_SHA256_P256, "DEST GENERATE SIGNATURE_TYPE=ECDSA_SHA256_P256"},
+	}
+	for _, tt := range tests {
+		keys, err := sam.NewKeys(tt.sigType)
+		if err != nil {
+			t.Fatalf("NewKeys(%q) error = %v", tt.sigType, err)
+		}
+		got, _, err := DestinationTypes(keys.Addr())
+		if err != nil {
+			t.Fatalf("DestinationTypes() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("NewKeys(%q) made %s keys, want %s", tt.sigType, got, tt.want)
+		}
+		cmds := b.Commands()
+		if last := cmds[len(cmds)-1]; last != tt.wantCmd {
+			t.Errorf("NewKeys(%q) sent %q, want %q", tt.sigType, last, tt.wantCmd)
+		}
+	}
+	if _, err := sam.NewKeys("Ed448"); !errors.Is(err, ErrInvalidSigType) {
+		t.Errorf("NewKeys(Ed448) error = %v, want ErrInvalidSigType", err)
+	}
+}
+
+func TestNewGenericSessionWithSignature(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    SigType
+		sigType SigType
+		wantErr error
+	}{
+		{"matching keys", SIG_EdDSA_SHA512_Ed25519, SIG_EdDSA_SHA512_Ed25519, nil},
+		{"default accepts any keys", SIG_DSA_SHA1, SIG_DEFAULT, nil},
+		{"mismatched keys", SIG_DSA_SHA1, SIG_EdDSA_SHA512_Ed25519, ErrInvalidSigType},
+		{"unknown type", SIG_EdDSA_SHA512_Ed25519, "Ed448", ErrInvalidSigType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := samtest.NewBridge()
+			if err != nil {
+				t.Fatalf("NewBridge() error = %v", err)
+			}
+			defer b.Close()
+			sam, err := NewSAM(b.Addr())
+			if err != nil {
+				t.Fatalf("NewSAM() error = %v", err)
+			}
+			defer sam.Close()
+			keys, err := sam.NewKeys(tt.keys)
+			if err != nil {
+				t.Fatalf("NewKeys() error = %v", err)
+			}
+			_, err = sam.NewGenericSessionWithSignature("STREAM", "signed", keys, tt.sigType, nil)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("NewGenericSessionWithSignature() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewGenericSessionWithSignature() error = %v", err)
+			}
+			var create string
+			for _, cmd := range b.Commands() {
+				if strings.HasPrefix(cmd, "SESSION CREATE ") {
+					create = cmd
+				}
+			}
+			hasSig := strings.Contains(create, " SIGNATURE_TYPE="+tt.sigType.String()+" ")
+			if hasSig != (tt.sigType != SIG_DEFAULT) || strings.Contains(create, "SIGNATURE_TYPE=SIGNATURE_TYPE") {
+				t.Errorf("SESSION CREATE = %q, want SIGNATURE_TYPE only for %q", create, tt.sigType)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Session is a SAM session a Supervisor can keep alive. StreamSession,
+// DatagramSession, RawSession and PrimarySession all implement it.
+type Session interface {
+	// Done is closed when the session's control connection ends
+	Done() <-chan struct{}
+	// Err is nil if the session was closed, and why it died otherwise
+	Err() error
+	Close() error
+}
+
+// Supervisor keeps a session alive: when the session's control connection
+// dies, because the router restarted or the bridge stopped answering PINGs,
+// it calls create with exponential backoff until a new session is up.
+// A session closed with Close is not created again, supervision ends
+// instead.
+type Supervisor struct {
+	create  func(ctx context.Context) (Session, error)
+	close   func(Session) error
+	backoff Backoff
+
+	mu      sync.Mutex
+	current Session
+	// ready is closed, and replaced, whenever current is replaced
+	ready chan struct{}
+	// ended is set once supervision stopped, Wait fails afterwards
+	ended bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewSupervisor starts supervising first. create must return a new session
+// equivalent to first: same ID, same keys, same options.
+func NewSupervisor(first Session, create func(ctx context.Context) (Session, error), backoff Backoff) *Supervisor {
+	return NewSupervisorWithClose(first, create, Session.Close, backoff)
+}
+
+// NewSupervisorWithClose is like NewSupervisor, but closes sessions with
+// close rather than their Close method. It is used for the sub-sessions of
+// a primary session, whose Close would close the control connection they
+// share with the primary session.
+func NewSupervisorWithClose(first Session, create func(ctx context.Context) (Session, error), close func(Session) error, backoff Backoff) *Supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Supervisor{
+		create:  create,
+		close:   close,
+		backoff: backoff.withDefaults(),
+		current: first,
+		ready:   make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Current returns the latest session. It may be dead while a new one is
+// being created.
+func (s *Supervisor) Current() Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+// Wait returns the current session once it is alive, waiting for it to be
+// created again if it was lost. It fails with net.ErrClosed once
+// supervision ended, or with ctx's error.
+func (s *Supervisor) Wait(ctx context.Context) (Session, error) {
+	for {
+		s.mu.Lock()
+		current, ready, ended := s.current, s.ready, s.ended
+		s.mu.Unlock()
+		if ended {
+			return nil, net.ErrClosed
+		}
+		select {
+		case <-current.Done():
+		default:
+			return current, nil
+		}
+		select {
+		case <-ready:
+		case <-s.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Lost reports whether err, returned by an operation on session, was caused
+// by losing the session, so the operation should be retried on the next
+// one. Errors from the bridge only count if the session is dead. Connection
+// errors wait a moment for the session's death to be noticed, and count
+// either way, since the bridge may be restarting.
+func (s *Supervisor) Lost(ctx context.Context, session Session, err error) bool {
+	select {
+	case <-session.Done():
+		return true
+	default:
+	}
+	var samErr *SAMError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &samErr):
+		return false
+	case errors.As(err, &netErr) && netErr.Timeout():
+		// a deadline set by the caller
+		return false
+	case errors.As(err, &netErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed):
+	default:
+		return false
+	}
+	t := time.NewTimer(s.backoff.Initial)
+	defer t.Stop()
+	select {
+	case <-session.Done():
+	case <-t.C:
+	case <-ctx.Done():
+		return false
+	case <-s.done:
+		return false
+	}
+	return true
+}
+
+// Done is closed once supervision ended, after Close or after the
+// supervised session was closed.
+func (s *Supervisor) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close ends supervision and closes the current session.
+func (s *Supervisor) Close() error {
+	s.cancel()
+	<-s.done
+	if err := s.close(s.Current()); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Supervisor) end() {
+	s.mu.Lock()
+	s.ended = true
+	s.mu.Unlock()
+	close(s.done)
+}
+
+func (s *Supervisor) run() {
+	defer s.end()
+	for {
+		current := s.Current()
+		select {
+		case <-current.Done():
+		case <-s.ctx.Done():
+			return
+		}
+		cause := current.Err()
+		if cause == nil {
+			log.Debug("Supervised session closed")
+			return
+		}
+		log.WithError(cause).Warn("SAM session lost, creating it again")
+		// free what the dead session still holds, such as its UDP socket,
+		// and unblock readers waiting on it
+		s.close(current)
+		next, ok := s.recreate()
+		if !ok {
+			return
+		}
+		s.mu.Lock()
+		s.current = next
+		close(s.ready)
+		s.ready = make(chan struct{})
+		s.mu.Unlock()
+	}
+}
+
+// recreate calls create until it succeeds or supervision is cancelled.
+func (s *Supervisor) recreate() (Session, bool) {
+	for attempt := 0; ; attempt++ {
+		next, err := s.create(s.ctx)
+		if err == nil {
+			if s.ctx.Err() != nil {
+				s.close(next)
+				return nil, false
+			}
+			log.WithField("attempts", attempt+1).Info("SAM session created again")
+			return next, true
+		}
+		if s.ctx.Err() != nil {
+			return nil, false
+		}
+		delay := s.backoff.Delay(attempt)
+		log.WithFields(logrus.Fields{
+			"attempt": attempt + 1,
+			"retry":   delay,
+		}).WithError(err).Warn("Failed to create SAM session again")
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-s.ctx.Done():
+			t.Stop()
+			return nil, false
+		}
+	}
+}
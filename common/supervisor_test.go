@@ -0,0 +1,193 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSession is a Session whose death is triggered by the test.
+type fakeSession struct {
+	n    int
+	done chan struct{}
+	once sync.Once
+	mu   sync.Mutex
+	err  error
+}
+
+func newFakeSession(n int) *fakeSession {
+	return &fakeSession{n: n, done: make(chan struct{})}
+}
+
+func (f *fakeSession) Done() <-chan struct{} { return f.done }
+
+func (f *fakeSession) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+func (f *fakeSession) die(err error) {
+	f.once.Do(func() {
+		f.mu.Lock()
+		f.err = err
+		f.mu.Unlock()
+		close(f.done)
+	})
+}
+
+func (f *fakeSession) Close() error {
+	f.die(nil)
+	return nil
+}
+
+var fastBackoff = Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond}
+
+func TestBackoff_Delay(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{2, 400 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{50, time.Second},
+	}
+	for _, tt := range tests {
+		got := b.Delay(tt.attempt)
+		if got > tt.max || got < tt.max*3/4 {
+			t.Errorf("Delay(%d) = %v, want between %v and %v", tt.attempt, got, tt.max*3/4, tt.max)
+		}
+	}
+	if got := (Backoff{}).Delay(0); got > DefaultBackoff.Initial {
+		t.Errorf("zero Backoff Delay(0) = %v, want at most %v", got, DefaultBackoff.Initial)
+	}
+}
+
+func TestSupervisor_RecreatesLostSession(t *testing.T) {
+	first := newFakeSession(0)
+	var calls atomic.Int32
+	created := make(chan *fakeSession, 1)
+	s := NewSupervisor(first, func(ctx context.Context) (Session, error) {
+		// the bridge is still down for the first two attempts
+		if n := calls.Add(1); n < 3 {
+			return nil, &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+		}
+		next := newFakeSession(int(calls.Load()))
+		created <- next
+		return next, nil
+	}, fastBackoff)
+	defer s.Close()
+
+	if got, err := s.Wait(context.Background()); err != nil || got != first {
+		t.Fatalf("Wait() = %v, %v, want first session", got, err)
+	}
+
+	waited := make(chan Session, 1)
+	first.die(ErrConnectionLost)
+	go func() {
+		got, err := s.Wait(context.Background())
+		if err != nil {
+			t.Errorf("Wait() error = %v", err)
+		}
+		waited <- got
+	}()
+
+	var next *fakeSession
+	select {
+	case next = <-created:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session was not created again")
+	}
+	if got := <-waited; got != next {
+		t.Errorf("Wait() = %v, want the new session", got)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("create called %d times, want 3", got)
+	}
+	if s.Current() != next {
+		t.Error("Current() is not the new session")
+	}
+}
+
+func TestSupervisor_End(t *testing.T) {
+	tests := []struct {
+		name string
+		end  func(s *Supervisor, current *fakeSession)
+	}{
+		{"Close", func(s *Supervisor, current *fakeSession) { s.Close() }},
+		{"session closed", func(s *Supervisor, current *fakeSession) { current.Close() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := newFakeSession(0)
+			var calls atomic.Int32
+			s := NewSupervisor(first, func(ctx context.Context) (Session, error) {
+				calls.Add(1)
+				return newFakeSession(1), nil
+			}, fastBackoff)
+
+			tt.end(s, first)
+			select {
+			case <-s.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("Done() was not closed")
+			}
+			if _, err := s.Wait(context.Background()); !errors.Is(err, net.ErrClosed) {
+				t.Errorf("Wait() error = %v, want net.ErrClosed", err)
+			}
+			if got := calls.Load(); got != 0 {
+				t.Errorf("create called %d times after the session was closed", got)
+			}
+			select {
+			case <-first.Done():
+			default:
+				t.Error("session left open")
+			}
+		})
+	}
+}
+
+func TestSupervisor_Lost(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		dead bool
+		want bool
+	}{
+		{"bridge error on live session", &SAMError{Verb: "STREAM", Result: "I2P_ERROR"}, false, false},
+		{"bridge error on dead session", &SAMError{Verb: "STREAM", Result: "I2P_ERROR"}, true, true},
+		{"connection reset", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, false, true},
+		{"closed socket", net.ErrClosed, false, true},
+		{"caller deadline", &net.OpError{Op: "read", Err: timeoutError{}}, false, false},
+		{"other error", errors.New("datagram too large"), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := newFakeSession(0)
+			s := NewSupervisor(session, func(ctx context.Context) (Session, error) {
+				return newFakeSession(1), nil
+			}, fastBackoff)
+			defer s.Close()
+			if tt.dead {
+				session.die(ErrConnectionLost)
+			}
+			if got := s.Lost(context.Background(), session, tt.err); got != tt.want {
+				t.Errorf("Lost(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
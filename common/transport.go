@@ -0,0 +1,148 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Dialer opens the connection to the SAM bridge. *net.Dialer,
+// proxy.ContextDialer from golang.org/x/net/proxy and *ssh.Client from
+// golang.org/x/crypto/ssh all satisfy it. Every control and data connection
+// of a SAM, including the ones opened by Redial for STREAM CONNECT, STREAM
+// ACCEPT and lookups, goes through the same Dialer.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// unixDialer connects to a SAM bridge listening on a Unix socket, whatever
+// address it is asked for.
+type unixDialer string
+
+func (path unixDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", string(path))
+}
+
+// ErrFingerprintMismatch is returned when the bridge's TLS certificate does
+// not match any fingerprint pinned with SetSAMTLSFingerprint.
+var ErrFingerprintMismatch = errors.New("SAM bridge certificate does not match pinned fingerprint")
+
+// parseFingerprint decodes a hex SHA-256 fingerprint. Colons and spaces, as
+// printed by openssl and keytool, are ignored.
+func parseFingerprint(s string) ([]byte, error) {
+	s = strings.NewReplacer(":", "", " ", "").Replace(s)
+	fp, err := hex.DecodeString(s)
+	if err != nil || len(fp) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", s)
+	}
+	return fp, nil
+}
+
+// tlsConfig returns the TLS configuration for a connection to address. With
+// pinned fingerprints the leaf certificate must match one of them; the chain
+// is additionally verified when RootCAs is set.
+func (f *I2PConfig) tlsConfig(address string) *tls.Config {
+	config := &tls.Config{}
+	if f.TLS != nil {
+		config = f.TLS.Clone()
+	}
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			config.ServerName = host
+		}
+	}
+	if len(f.tlsPins) == 0 {
+		return config
+	}
+	pins := f.tlsPins
+	roots := config.RootCAs
+	serverName := config.ServerName
+	// the chain is checked below, since a self-signed bridge certificate
+	// would fail the default verification
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return ErrFingerprintMismatch
+		}
+		leaf := cs.PeerCertificates[0]
+		sum := sha256.Sum256(leaf.Raw)
+		matched := false
+		for _, pin := range pins {
+			if bytes.Equal(pin, sum[:]) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			log.WithField("fingerprint", hex.EncodeToString(sum[:])).Error("SAM bridge certificate not pinned")
+			return ErrFingerprintMismatch
+		}
+		if roots == nil {
+			return nil
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{
+			DNSName:       serverName,
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+		return err
+	}
+	return config
+}
+
+// usesTLS reports whether connections to the bridge are wrapped in TLS.
+func (f *I2PConfig) usesTLS() bool {
+	return f.TLS != nil || len(f.tlsPins) > 0
+}
+
+// dial opens a connection to the SAM bridge at address through the
+// configured Dialer, and performs the TLS handshake if TLS is configured.
+func (f *I2PConfig) dial(ctx context.Context, address string) (net.Conn, error) {
+	d := f.Dialer
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if !f.usesTLS() {
+		return conn, nil
+	}
+	tconn := tls.Client(conn, f.tlsConfig(address))
+	if err := tconn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	log.WithFields(logrus.Fields{
+		"address": address,
+		"version": tls.VersionName(tconn.ConnectionState().Version),
+	}).Debug("TLS connection to SAM bridge established")
+	return tconn, nil
+}
+
+// transport returns an option carrying the Dialer and TLS settings over to
+// another SAMEmit, so redialed connections reach the bridge the same way.
+func (f *I2PConfig) transport() func(*SAMEmit) error {
+	d, config, pins := f.Dialer, f.TLS, f.tlsPins
+	return func(c *SAMEmit) error {
+		c.I2PConfig.Dialer = d
+		c.I2PConfig.TLS = config
+		c.I2PConfig.tlsPins = pins
+		return nil
+	}
+}
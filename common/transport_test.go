@@ -0,0 +1,194 @@
+package common
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/samtest"
+)
+
+// countingDialer ignores the address it is given and connects to target,
+// like a tunnel would.
+type countingDialer struct {
+	target string
+	dials  atomic.Int32
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.dials.Add(1)
+	var nd net.Dialer
+	return nd.DialContext(ctx, network, d.target)
+}
+
+func newTestBridge(t *testing.T) *samtest.Bridge {
+	t.Helper()
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestNewSAM_Dialer(t *testing.T) {
+	b := newTestBridge(t)
+	d := &countingDialer{target: b.Addr()}
+
+	sam, err := NewSAM("bridge.invalid:7656", SetSAMDialer(d))
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	defer sam.Close()
+	data, err := sam.Redial()
+	if err != nil {
+		t.Fatalf("Redial() error = %v", err)
+	}
+	defer data.Close()
+	if _, err := data.NewKeys(); err != nil {
+		t.Errorf("NewKeys() on redialed SAM error = %v", err)
+	}
+	if got := d.dials.Load(); got != 2 {
+		t.Errorf("dialer used %d times, want 2", got)
+	}
+}
+
+func TestNewSAM_UnixSocket(t *testing.T) {
+	b := newTestBridge(t)
+	path := filepath.Join(t.TempDir(), "sam.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("Unix sockets unavailable: %v", err)
+	}
+	b.Serve(l)
+
+	sam, err := NewSAM("127.0.0.1:1", SetSAMUnixSocket(path))
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	defer sam.Close()
+	if got := sam.RemoteAddr().Network(); got != "unix" {
+		t.Errorf("RemoteAddr().Network() = %q, want unix", got)
+	}
+	data, err := sam.Redial()
+	if err != nil {
+		t.Fatalf("Redial() error = %v", err)
+	}
+	data.Close()
+}
+
+// selfSigned returns a certificate for 127.0.0.1 in PEM and its SHA-256
+// fingerprint.
+func selfSigned(t *testing.T) (tls.Certificate, []byte, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sam bridge"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), strings.Join(parts, ":")
+}
+
+func TestNewSAM_TLS(t *testing.T) {
+	b := newTestBridge(t)
+	cert, caPEM, fingerprint := selfSigned(t)
+	_, otherPEM, otherFingerprint := selfSigned(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("tls.Listen() error = %v", err)
+	}
+	b.Serve(l)
+
+	tests := []struct {
+		name    string
+		opts    []func(*SAMEmit) error
+		wantErr error
+	}{
+		{"trusted CA", []func(*SAMEmit) error{SetSAMTLSCA(caPEM)}, nil},
+		{"pinned", []func(*SAMEmit) error{SetSAMTLSFingerprint(fingerprint)}, nil},
+		{"pinned and CA", []func(*SAMEmit) error{SetSAMTLSCA(caPEM), SetSAMTLSFingerprint(fingerprint)}, nil},
+		{"rotated pin", []func(*SAMEmit) error{SetSAMTLSFingerprint(otherFingerprint), SetSAMTLSFingerprint(fingerprint)}, nil},
+		{"wrong pin", []func(*SAMEmit) error{SetSAMTLSFingerprint(otherFingerprint)}, ErrFingerprintMismatch},
+		{"pinned but wrong CA", []func(*SAMEmit) error{SetSAMTLSCA(otherPEM), SetSAMTLSFingerprint(fingerprint)}, x509.UnknownAuthorityError{}},
+		{"untrusted", []func(*SAMEmit) error{SetSAMTLS(nil)}, x509.UnknownAuthorityError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sam, err := NewSAM(l.Addr().String(), tt.opts...)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("NewSAM() error = %v", err)
+				}
+				defer sam.Close()
+				if _, ok := sam.Conn.(*tls.Conn); !ok {
+					t.Errorf("control connection is %T, want *tls.Conn", sam.Conn)
+				}
+				data, err := sam.Redial()
+				if err != nil {
+					t.Fatalf("Redial() error = %v", err)
+				}
+				defer data.Close()
+				if _, ok := data.Conn.(*tls.Conn); !ok {
+					t.Errorf("redialed connection is %T, want *tls.Conn", data.Conn)
+				}
+				return
+			}
+			if err == nil {
+				sam.Close()
+				t.Fatal("NewSAM() succeeded, want error")
+			}
+			var unknown x509.UnknownAuthorityError
+			if _, ok := tt.wantErr.(x509.UnknownAuthorityError); ok {
+				if !errors.As(err, &unknown) {
+					t.Errorf("NewSAM() error = %v, want unknown authority", err)
+				}
+			} else if !errors.Is(err, tt.wantErr) {
+				t.Errorf("NewSAM() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetSAMTLSFingerprint_Invalid(t *testing.T) {
+	for _, fp := range []string{"", "zz", "AB:CD"} {
+		emit := &SAMEmit{}
+		if err := SetSAMTLSFingerprint(fp)(emit); err == nil {
+			t.Errorf("SetSAMTLSFingerprint(%q) error = nil, want error", fp)
+		}
+	}
+}
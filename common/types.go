@@ -2,6 +2,7 @@ package common
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"time"
@@ -18,6 +19,20 @@ type I2PConfig struct {
 	SamMin string
 	SamMax string
 
+	// User and Password authenticate the HELLO when the router runs SAM
+	// with sam.auth=true
+	User     string
+	Password string
+
+	// Dialer reaches the bridge, net.Dialer over TCP if nil
+	Dialer Dialer
+	// TLS wraps every bridge connection in TLS when set, for routers
+	// running SAM with sam.useSSL=true
+	TLS *tls.Config
+	// tlsPins are the SHA-256 certificate fingerprints the bridge may
+	// present, see SetSAMTLSFingerprint
+	tlsPins [][]byte
+
 	Fromport string
 	Toport   string
 
@@ -25,8 +40,15 @@ type I2PConfig struct {
 	TunType string
 
 	DestinationKeys *i2pkeys.I2PKeys
+	// Extras are options sent with SESSION CREATE as they are, overriding
+	// the ones derived from the other fields, see SessionOptions
+	Extras []string
+
+	// Resolver answers the lookups of the SAM and of its sessions before
+	// the SAM's own DefaultResolver, which gets the names it does not know
+	Resolver Resolver
 
-	SigType                   string
+	SigType                   SigType
 	EncryptLeaseSet           bool
 	LeaseSetKey               string
 	LeaseSetPrivateKey        string
@@ -55,22 +77,42 @@ type I2PConfig struct {
 	// Streaming Library options
 	AccessListType string
 	AccessList     []string
+
+	// version is the SAM version negotiated in HELLO
+	version string
 }
 
 type SAMEmit struct {
 	I2PConfig
 }
 
-// Used for controlling I2Ps SAMv3.
+// Used for controlling I2Ps SAMv3. A SAM is safe for concurrent use: commands
+// sent from several goroutines share the control connection, and each
+// caller gets the reply to its own command.
 type SAM struct {
 	SAMEmit
 	*SAMResolver
 	net.Conn
 
+	// Cache answers Lookup and the lookups of sessions created from the
+	// SAM, through SAMResolver. It is set up by NewSAM and shared with the
+	// SAMs opened from this one by Redial and ReconnectContext; nil sends
+	// every lookup to the bridge.
+	Cache *CachingResolver
+	// Hosts resolves names from hosts.txt files, before asking the bridge
+	// or, with Hosts.AfterSAM, for names it does not know. Nil by default.
+	Hosts *HostsResolver
+
 	// Timeout for SAM connections
 	Timeout time.Duration
 	// Context for control of lifecycle
 	Context context.Context
+
+	// reader buffers the replies read from Conn
+	reader *MessageReader
+	// monitor answers PINGs and detects a dead control connection once a
+	// session is created, it is shared by copies of the SAM
+	monitor *monitor
 }
 
 type SAMResolver struct {
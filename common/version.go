@@ -0,0 +1,134 @@
+package common
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Feature is a SAM capability which depends on the protocol version the
+// bridge negotiated in HELLO.
+type Feature string
+
+const (
+	// FeatureSignatureType is SIGNATURE_TYPE in DEST GENERATE and SESSION CREATE
+	FeatureSignatureType Feature = "SIGNATURE_TYPE"
+	// FeaturePorts is FROM_PORT and TO_PORT on sessions and connections
+	FeaturePorts Feature = "PORTS"
+	// FeatureAuth is HELLO USER/PASSWORD and the AUTH commands
+	FeatureAuth Feature = "AUTH"
+	// FeaturePing is PING and PONG on control connections
+	FeaturePing Feature = "PING"
+	// FeatureConcurrentAccept is several pending STREAM ACCEPTs per session
+	FeatureConcurrentAccept Feature = "CONCURRENT_ACCEPT"
+	// FeaturePrimary is STYLE=PRIMARY (formerly MASTER) and SESSION ADD
+	FeaturePrimary Feature = "PRIMARY"
+	// FeatureDatagram2 is STYLE=DATAGRAM2. Bridges older than I2P 0.9.66
+	// report 3.3 too but reject it.
+	FeatureDatagram2 Feature = "DATAGRAM2"
+	// FeatureDatagram3 is STYLE=DATAGRAM3, with the same caveat as DATAGRAM2.
+	FeatureDatagram3 Feature = "DATAGRAM3"
+	// FeatureLookupOptions is OPTIONS=true in NAMING LOOKUP, with the same
+	// caveat as DATAGRAM2.
+	FeatureLookupOptions Feature = "LOOKUP_OPTIONS"
+)
+
+// featureVersions is the first SAM version providing each feature.
+var featureVersions = map[Feature]string{
+	FeatureSignatureType:    "3.1",
+	FeaturePorts:            "3.2",
+	FeatureAuth:             "3.2",
+	FeaturePing:             "3.2",
+	FeatureConcurrentAccept: "3.2",
+	FeaturePrimary:          "3.3",
+	FeatureDatagram2:        "3.3",
+	FeatureDatagram3:        "3.3",
+	FeatureLookupOptions:    "3.3",
+}
+
+// styleFeatures maps session styles to the feature they need.
+var styleFeatures = map[string]Feature{
+	"PRIMARY":   FeaturePrimary,
+	"MASTER":    FeaturePrimary,
+	"DATAGRAM2": FeatureDatagram2,
+	"DATAGRAM3": FeatureDatagram3,
+}
+
+// Version returns the SAM version negotiated with the bridge, e.g. "3.3",
+// or the empty string before HELLO.
+func (sam *SAM) Version() string {
+	return sam.SAMEmit.I2PConfig.version
+}
+
+// Supports reports whether the negotiated SAM version provides f.
+func (sam *SAM) Supports(f Feature) bool {
+	return sam.SAMEmit.I2PConfig.supports(f)
+}
+
+// Require returns an error wrapping ErrUnsupported if the negotiated SAM
+// version does not provide f.
+func (sam *SAM) Require(f Feature) error {
+	if sam.Supports(f) {
+		return nil
+	}
+	log.WithFields(logrus.Fields{
+		"feature": f,
+		"version": sam.Version(),
+	}).Error("Feature not supported by SAM bridge")
+	return fmt.Errorf("%w: %s needs SAM %s, bridge negotiated %s", ErrUnsupported, f, featureVersions[f], sam.Version())
+}
+
+// PrimaryStyle returns the STYLE used to create primary sessions on this
+// bridge. Bridges since I2P 0.9.47 call it PRIMARY and older ones MASTER.
+// They cannot be told apart by version, so PRIMARY is returned whenever the
+// bridge speaks SAM 3.3; the primary package falls back to MASTER if the
+// bridge rejects it.
+func (sam *SAM) PrimaryStyle() (string, error) {
+	if err := sam.Require(FeaturePrimary); err != nil {
+		return "", err
+	}
+	return "PRIMARY", nil
+}
+
+// requireSession checks the features needed by a session style and its
+// ports.
+func (sam *SAM) requireSession(style, from, to string) error {
+	if f, ok := styleFeatures[style]; ok {
+		if err := sam.Require(f); err != nil {
+			return err
+		}
+	}
+	if (from != "" && from != "0") || (to != "" && to != "0") {
+		if err := sam.Require(FeaturePorts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// samVersion returns the negotiated version if known, the configured
+// maximum otherwise.
+func (f *I2PConfig) samVersion() string {
+	if f.version != "" {
+		return f.version
+	}
+	return f.MaxSAM()
+}
+
+func (f *I2PConfig) supports(feature Feature) bool {
+	min, ok := featureVersions[feature]
+	return ok && versionAtLeast(f.samVersion(), min)
+}
+
+// versionAtLeast reports whether the SAM version v is min or newer.
+func versionAtLeast(v, min string) bool {
+	var vmaj, vmin, mmaj, mmin int
+	if _, err := fmt.Sscanf(v, "%d.%d", &vmaj, &vmin); err != nil {
+		return false
+	}
+	fmt.Sscanf(min, "%d.%d", &mmaj, &mmin)
+	if vmaj != mmaj {
+		return vmaj > mmaj
+	}
+	return vmin >= mmin
+}
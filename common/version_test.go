@@ -0,0 +1,112 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-sam-go/samtest"
+)
+
+func TestVersionAtLeast(t *testing.T) {
+	tests := []struct {
+		v, min string
+		want   bool
+	}{
+		{"3.3", "3.2", true},
+		{"3.2", "3.2", true},
+		{"3.1", "3.2", false},
+		{"3.10", "3.2", true},
+		{"4.0", "3.3", true},
+		{"", "3.0", false},
+		{"garbage", "3.0", false},
+	}
+	for _, tt := range tests {
+		if got := versionAtLeast(tt.v, tt.min); got != tt.want {
+			t.Errorf("versionAtLeast(%q, %q) = %v, want %v", tt.v, tt.min, got, tt.want)
+		}
+	}
+}
+
+func TestSAM_Supports(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	tests := []struct {
+		version string
+		feature Feature
+		want    bool
+	}{
+		{"3.0", FeatureSignatureType, false},
+		{"3.1", FeatureSignatureType, true},
+		{"3.1", FeaturePorts, false},
+		{"3.2", FeaturePorts, true},
+		{"3.2", FeatureAuth, true},
+		{"3.2", FeaturePing, true},
+		{"3.2", FeaturePrimary, false},
+		{"3.3", FeaturePrimary, true},
+		{"3.3", FeatureDatagram2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version+"/"+string(tt.feature), func(t *testing.T) {
+			b.SetVersion(tt.version)
+			sam, err := NewSAM(b.Addr())
+			if err != nil {
+				t.Fatalf("NewSAM() error = %v", err)
+			}
+			defer sam.Close()
+			if sam.Version() != tt.version {
+				t.Errorf("Version() = %q, want %q", sam.Version(), tt.version)
+			}
+			if got := sam.Supports(tt.feature); got != tt.want {
+				t.Errorf("Supports(%s) = %v, want %v", tt.feature, got, tt.want)
+			}
+			if err := sam.Require(tt.feature); (err == nil) != tt.want || (err != nil && !errors.Is(err, ErrUnsupported)) {
+				t.Errorf("Require(%s) = %v", tt.feature, err)
+			}
+		})
+	}
+}
+
+func TestNewGenericSession_Unsupported(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	b.SetVersion("3.1")
+
+	sam, err := NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	defer sam.Close()
+	keys, err := sam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+
+	if _, err := sam.NewGenericSessionWithSignatureAndPorts("STREAM", "ported", "80", "0", keys, "", nil); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("session with ports on SAM 3.1 error = %v, want ErrUnsupported", err)
+	}
+	if _, err := sam.NewGenericSession("PRIMARY", "primary", keys, nil); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("PRIMARY session on SAM 3.1 error = %v, want ErrUnsupported", err)
+	}
+	for _, cmd := range b.Commands() {
+		if strings.HasPrefix(cmd, "SESSION CREATE") {
+			t.Errorf("unsupported session was sent to the bridge: %q", cmd)
+		}
+	}
+	if err := sam.AuthEnable(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("AuthEnable() on SAM 3.1 error = %v, want ErrUnsupported", err)
+	}
+
+	// ports are left out of commands built from the config
+	sam.Fromport = "80"
+	if got := sam.FromPort(); got != "" {
+		t.Errorf("FromPort() on SAM 3.1 = %q, want empty", got)
+	}
+}
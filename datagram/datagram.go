@@ -1,6 +1,7 @@
 package datagram
 
 import (
+	"context"
 	"errors"
 	"net"
 	"strconv"
@@ -13,6 +14,14 @@ import (
 // Creates a new datagram session. udpPort is the UDP port SAM is listening on,
 // and if you set it to zero, it will use SAMs standard UDP port.
 func (s *SAM) NewDatagramSession(id string, keys i2pkeys.I2PKeys, options []string, udpPort int) (*DatagramSession, error) {
+	ctx, cancel := (*common.SAM)(s).ContextWithTimeout()
+	defer cancel()
+	return s.NewDatagramSessionContext(ctx, id, keys, options, udpPort)
+}
+
+// NewDatagramSessionContext is like NewDatagramSession, but gives up when
+// ctx is done.
+func (s *SAM) NewDatagramSessionContext(ctx context.Context, id string, keys i2pkeys.I2PKeys, options []string, udpPort int) (*DatagramSession, error) {
 	log.WithFields(logrus.Fields{
 		"id":      id,
 		"udpPort": udpPort,
@@ -59,9 +68,10 @@ func (s *SAM) NewDatagramSession(id string, keys i2pkeys.I2PKeys, options []stri
 		s.Close()
 		return nil, err
 	}
-	conn, err := s.NewGenericSession("DATAGRAM", id, keys, []string{" PORT=" + lport})
+	conn, err := (*common.SAM)(s).NewGenericSessionContext(ctx, "DATAGRAM", id, keys, common.MergeOptions(options, []string{"PORT=" + lport}))
 	if err != nil {
 		log.WithError(err).Error("Failed to create generic session")
+		udpconn.Close()
 		return nil, err
 	}
 
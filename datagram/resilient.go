@@ -0,0 +1,198 @@
+package datagram
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/i2pkeys"
+	"github.com/sirupsen/logrus"
+)
+
+// ResilientDatagramSession is a DatagramSession which creates itself
+// again, with the same ID, keys and options, when the router restarts or
+// the bridge stops answering. A ReadFrom blocked when the session is lost
+// carries on once the session has been created again.
+type ResilientDatagramSession struct {
+	supervisor *common.Supervisor
+	keys       i2pkeys.I2PKeys
+	id         string
+
+	// ctx is cancelled by Close, to unblock ReadFrom and WriteTo
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// NewResilientDatagramSession creates a datagram session like
+// NewDatagramSession and keeps it alive, creating it again with backoff
+// whenever its control connection is lost. A zero backoff uses
+// common.DefaultBackoff.
+func (s *SAM) NewResilientDatagramSession(id string, keys i2pkeys.I2PKeys, options []string, udpPort int, backoff common.Backoff) (*ResilientDatagramSession, error) {
+	log.WithFields(logrus.Fields{"id": id, "udpPort": udpPort}).Debug("Creating new ResilientDatagramSession")
+	first, err := s.NewDatagramSession(id, keys, options, udpPort)
+	if err != nil {
+		return nil, err
+	}
+	base := (*common.SAM)(first.SAM)
+	return Resilient(first, func(ctx context.Context) (*DatagramSession, error) {
+		next, err := base.ReconnectContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return (*SAM)(next).NewDatagramSessionContext(ctx, id, keys, options, udpPort)
+	}, backoff), nil
+}
+
+// Resilient keeps first alive by calling create whenever its control
+// connection is lost. create must return a session with the same ID and
+// keys as first.
+func Resilient(first *DatagramSession, create func(ctx context.Context) (*DatagramSession, error), backoff common.Backoff) *ResilientDatagramSession {
+	s := newResilient(first, strings.TrimPrefix(first.ID(), "ID="))
+	s.supervisor = common.NewSupervisor(first, s.recreate(first, create), backoff)
+	return s
+}
+
+// ResilientSubSession is like Resilient, for the sub-session id of a
+// primary session, which create adds again through the primary session.
+// Sessions are closed with remove rather than Close, which would close the
+// control connection of the primary session. remove must close the UDP
+// socket of the session as well.
+func ResilientSubSession(first *DatagramSession, id string, create func(ctx context.Context) (*DatagramSession, error), remove func(*DatagramSession) error, backoff common.Backoff) *ResilientDatagramSession {
+	s := newResilient(first, id)
+	s.supervisor = common.NewSupervisorWithClose(first, s.recreate(first, create), func(session common.Session) error {
+		return remove(session.(*DatagramSession))
+	}, backoff)
+	return s
+}
+
+func newResilient(first *DatagramSession, id string) *ResilientDatagramSession {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ResilientDatagramSession{
+		keys:   *first.DestinationKeys,
+		id:     id,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// recreate wraps create to carry the deadlines and remote address of the
+// session over.
+func (s *ResilientDatagramSession) recreate(first *DatagramSession, create func(ctx context.Context) (*DatagramSession, error)) func(ctx context.Context) (common.Session, error) {
+	return func(ctx context.Context) (common.Session, error) {
+		next, err := create(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		next.UDPConn.SetReadDeadline(s.readDeadline)
+		next.UDPConn.SetWriteDeadline(s.writeDeadline)
+		next.RemoteI2PAddr = first.RemoteI2PAddr
+		s.mu.Unlock()
+		return next, nil
+	}
+}
+
+// Session returns the current DatagramSession. It may be dead while a new
+// one is being created.
+func (s *ResilientDatagramSession) Session() *DatagramSession {
+	return s.supervisor.Current().(*DatagramSession)
+}
+
+// wait returns the current DatagramSession once it is alive.
+func (s *ResilientDatagramSession) wait() (*DatagramSession, error) {
+	session, err := s.supervisor.Wait(s.ctx)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil, net.ErrClosed
+		}
+		return nil, err
+	}
+	return session.(*DatagramSession), nil
+}
+
+// ID returns the session ID, which is kept across re-creations.
+func (s *ResilientDatagramSession) ID() string {
+	return s.id
+}
+
+// LocalI2PAddr returns the I2P destination of the session.
+func (s *ResilientDatagramSession) LocalI2PAddr() i2pkeys.I2PAddr {
+	return s.keys.Addr()
+}
+
+// Implements net.PacketConn
+func (s *ResilientDatagramSession) LocalAddr() net.Addr {
+	return s.LocalI2PAddr()
+}
+
+// ReadFrom reads one datagram like DatagramSession.ReadFrom. If the session
+// is lost meanwhile, it waits for the session to be created again and
+// keeps reading. Implements net.PacketConn
+func (s *ResilientDatagramSession) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	for {
+		session, err := s.wait()
+		if err != nil {
+			return 0, i2pkeys.I2PAddr(""), err
+		}
+		n, addr, err = session.ReadFrom(b)
+		if err == nil || !s.supervisor.Lost(s.ctx, session, err) {
+			return n, addr, err
+		}
+		log.WithError(err).Debug("ReadFrom interrupted by lost session, retrying")
+	}
+}
+
+// WriteTo sends one datagram like DatagramSession.WriteTo, waiting for the
+// session if it is being created again. Implements net.PacketConn
+func (s *ResilientDatagramSession) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	session, err := s.wait()
+	if err != nil {
+		return 0, err
+	}
+	return session.WriteTo(b, addr)
+}
+
+// Implements net.PacketConn. Deadlines carry over to re-created sessions.
+func (s *ResilientDatagramSession) SetDeadline(t time.Time) error {
+	if err := s.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return s.SetWriteDeadline(t)
+}
+
+// Implements net.PacketConn
+func (s *ResilientDatagramSession) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readDeadline = t
+	return s.Session().UDPConn.SetReadDeadline(t)
+}
+
+// Implements net.PacketConn
+func (s *ResilientDatagramSession) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeDeadline = t
+	return s.Session().UDPConn.SetWriteDeadline(t)
+}
+
+// Done is closed once the session is closed for good.
+func (s *ResilientDatagramSession) Done() <-chan struct{} {
+	return s.supervisor.Done()
+}
+
+// Close stops re-creating the session and closes it. Implements
+// net.PacketConn
+func (s *ResilientDatagramSession) Close() error {
+	log.WithField("id", s.ID()).Debug("Closing ResilientDatagramSession")
+	s.cancel()
+	return s.supervisor.Close()
+}
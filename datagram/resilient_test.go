@@ -0,0 +1,103 @@
+package datagram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/go-sam-go/samtest"
+)
+
+func newTestSAM(t *testing.T, b *samtest.Bridge) *SAM {
+	t.Helper()
+	commonSam, err := common.NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	return (*SAM)(commonSam)
+}
+
+func TestResilientDatagramSession_ReadFromSurvivesRestart(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	rxSam := newTestSAM(t, b)
+	keys, err := (*common.SAM)(rxSam).NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	rx, err := rxSam.NewResilientDatagramSession("rx", keys, nil, b.UDPPort(), common.Backoff{Initial: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewResilientDatagramSession() error = %v", err)
+	}
+	defer rx.Close()
+
+	txSam := newTestSAM(t, b)
+	txKeys, err := (*common.SAM)(txSam).NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	tx, err := txSam.NewDatagramSession("tx", txKeys, nil, b.UDPPort())
+	if err != nil {
+		t.Fatalf("NewDatagramSession() error = %v", err)
+	}
+	defer tx.Close()
+
+	type result struct {
+		data string
+		from string
+		err  error
+	}
+	read := make(chan result, 1)
+	go func() {
+		buf := make([]byte, 64)
+		n, from, err := rx.ReadFrom(buf)
+		if err != nil {
+			read <- result{err: err}
+			return
+		}
+		read <- result{data: string(buf[:n]), from: from.String()}
+	}()
+
+	first := rx.Session()
+	if err := b.Disconnect("rx"); err != nil {
+		t.Fatalf("Disconnect() error = %v", err)
+	}
+	select {
+	case <-first.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("lost session was not noticed")
+	}
+
+	// datagrams are only delivered once the session is back
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case r := <-read:
+			if r.err != nil {
+				t.Fatalf("ReadFrom() error = %v", r.err)
+			}
+			if r.data != "hello" {
+				t.Errorf("ReadFrom() = %q, want %q", r.data, "hello")
+			}
+			if r.from != txKeys.Addr().Base32() {
+				t.Errorf("ReadFrom() from %s, want %s", r.from, txKeys.Addr().Base32())
+			}
+			if rx.Session() == first {
+				t.Error("Session() still returns the lost session")
+			}
+			return
+		case <-ticker.C:
+			if _, err := tx.WriteTo([]byte("hello"), rx.LocalAddr()); err != nil {
+				t.Fatalf("WriteTo() error = %v", err)
+			}
+		case <-timeout:
+			t.Fatal("blocked ReadFrom() did not resume")
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/go-i2p/go-sam-go/common"
@@ -79,17 +80,22 @@ func (s *DatagramSession) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
 			log.WithError(err).Error("Failed to read from UDP")
 			return 0, i2pkeys.I2PAddr(""), err
 		}
-		if bytes.Equal(saddr.IP, s.SAMUDPAddress.IP) {
+		if !saddr.IP.Equal(s.SAMUDPAddress.IP) {
 			continue
 		}
 		break
 	}
-	i := bytes.IndexByte(buf, byte('\n'))
-	if i > 4096 || i > n {
+	i := bytes.IndexByte(buf[:n], byte('\n'))
+	if i < 0 || i > 4096 {
 		log.Error("Could not parse incoming message remote address")
 		return 0, i2pkeys.I2PAddr(""), errors.New("Could not parse incomming message remote address.")
 	}
-	raddr, err := i2pkeys.NewI2PAddrFromString(string(buf[:i]))
+	// SAM 3.2 and later follow the destination with FROM_PORT and TO_PORT
+	header := string(buf[:i])
+	if j := strings.IndexByte(header, ' '); j >= 0 {
+		header = header[:j]
+	}
+	raddr, err := i2pkeys.NewI2PAddrFromString(header)
 	if err != nil {
 		log.WithError(err).Error("Could not parse incoming message remote address")
 		return 0, i2pkeys.I2PAddr(""), errors.New("Could not parse incomming message remote address: " + err.Error())
@@ -124,7 +130,7 @@ func (s *DatagramSession) WriteTo(b []byte, addr net.Addr) (n int, err error) {
 		"addr":        addr,
 		"datagramLen": len(b),
 	}).Debug("Writing datagram")
-	header := []byte("3.1 " + s.ID() + " " + addr.String() + "\n")
+	header := []byte("3.1 " + s.TunName + " " + addr.String() + "\n")
 	msg := append(header, b...)
 	n, err = s.UDPConn.WriteToUDP(msg, s.SAMUDPAddress)
 	if err != nil {
@@ -171,16 +177,28 @@ func (s *DatagramSession) Addr() net.Addr {
 	return s.LocalI2PAddr()
 }
 
-func (s *DatagramSession) Lookup(name string) (a net.Addr, err error) {
+// Lookup resolves name on the session's control connection, answering from
+// the SAM's Cache when possible.
+func (s *DatagramSession) Lookup(name string) (net.Addr, error) {
 	log.WithField("name", name).Debug("Looking up address")
-	var sam *common.SAM
-	sam, err = common.NewSAM(s.Sam())
-	if err == nil {
-		defer sam.Close()
-		a, err = sam.Lookup(name)
+	addr, err := (*common.SAM)(s.SAM).Lookup(name)
+	if err != nil {
+		log.WithError(err).Error("Lookup failed")
+		return nil, err
 	}
-	log.WithField("address", a).Debug("Lookup successful")
-	return
+	log.WithField("address", addr).Debug("Lookup successful")
+	return addr, nil
+}
+
+// LookupOptions looks name up with the options its destination publishes,
+// see common.SAM.LookupOptions.
+func (s *DatagramSession) LookupOptions(name string) (*common.LookupResult, error) {
+	return (*common.SAM)(s.SAM).LookupOptions(name)
+}
+
+// LookupSelf returns the destination of the session as the bridge knows it.
+func (s *DatagramSession) LookupSelf() (*common.LookupResult, error) {
+	return (*common.SAM)(s.SAM).LookupSelf()
 }
 
 // Sets read and write deadlines for the DatagramSession. Implements
@@ -207,3 +225,14 @@ func (s *DatagramSession) SetWriteBuffer(bytes int) error {
 	log.WithField("bytes", bytes).Debug("Setting write buffer")
 	return s.UDPConn.SetWriteBuffer(bytes)
 }
+
+// Done returns a channel which is closed when the session's control
+// connection is closed or dies.
+func (s *DatagramSession) Done() <-chan struct{} {
+	return (*common.SAM)(s.SAM).Done()
+}
+
+// Err returns why the session's control connection died, or nil.
+func (s *DatagramSession) Err() error {
+	return (*common.SAM)(s.SAM).Err()
+}
@@ -15,15 +15,11 @@ type SAMEmit struct {
 }
 
 func (e *SAMEmit) SamOptionsString() string {
-	optStr := strings.Join(e.I2PConfig.Print(), " ")
-	log.WithField("optStr", optStr).Debug("Generated option string")
-	return optStr
+	return e.SAMEmit.SamOptionsString()
 }
 
 func (e *SAMEmit) Hello() string {
-	hello := fmt.Sprintf("HELLO VERSION MIN=%s MAX=%s \n", e.I2PConfig.MinSAM(), e.I2PConfig.MaxSAM())
-	log.WithField("hello", hello).Debug("Generated HELLO command")
-	return hello
+	return e.SAMEmit.Hello()
 }
 
 func (e *SAMEmit) HelloBytes() []byte {
@@ -51,23 +47,10 @@ func (e *SAMEmit) LookupBytes(name string) []byte {
 }
 
 func (e *SAMEmit) Create() string {
-	create := fmt.Sprintf(
-		//             //1 2 3 4 5 6 7
-		"SESSION CREATE %s%s%s%s%s%s%s \n",
-		e.I2PConfig.SessionStyle(),   // 1
-		e.I2PConfig.FromPort(),       // 2
-		e.I2PConfig.ToPort(),         // 3
-		e.I2PConfig.ID(),             // 4
-		e.I2PConfig.DestinationKey(), // 5
-		e.I2PConfig.SignatureType(),  // 6
-		e.SamOptionsString(),         // 7
-	)
-	log.WithField("create", create).Debug("Generated SESSION CREATE command")
-	return create
+	return e.SAMEmit.Create()
 }
 
 func (e *SAMEmit) CreateBytes() []byte {
-	fmt.Println("sam command: " + e.Create())
 	return []byte(e.Create())
 }
 
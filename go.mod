@@ -6,6 +6,7 @@ require (
 	github.com/go-i2p/i2pkeys v0.33.92
 	github.com/sirupsen/logrus v1.9.3
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/crypto v0.33.0
 )
 
 require (
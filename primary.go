@@ -14,7 +14,10 @@ type PrimarySession struct {
 	*primary.PrimarySession
 }
 
-var PrimarySessionSwitch = "MASTER"
+// PrimarySessionSwitch overrides the STYLE, PRIMARY or MASTER, of the
+// primary sessions created by SAM.NewPrimarySession. Left empty,
+// primary.PrimarySessionSwitch applies.
+var PrimarySessionSwitch = ""
 
 func (p *PrimarySession) NewStreamSubSession(id string) (*StreamSession, error) {
 	log.WithField("id", id).Debug("NewStreamSubSession called")
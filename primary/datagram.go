@@ -1,6 +1,7 @@
 package primary
 
 import (
+	"context"
 	"errors"
 	"net"
 	"strconv"
@@ -13,10 +14,44 @@ import (
 // Creates a new datagram session. udpPort is the UDP port SAM is listening on,
 // and if you set it to zero, it will use SAMs standard UDP port.
 func (s *PrimarySession) NewDatagramSubSession(id string, udpPort int) (*datagram.DatagramSession, error) {
+	ctx, cancel := (*common.SAM)(s.SAM).ContextWithTimeout()
+	defer cancel()
+	return s.NewDatagramSubSessionContext(ctx, id, udpPort)
+}
+
+// NewDatagramSubSessionContext is like NewDatagramSubSession, but gives up
+// when ctx is done.
+func (s *PrimarySession) NewDatagramSubSessionContext(ctx context.Context, id string, udpPort int) (*datagram.DatagramSession, error) {
 	log.WithFields(logrus.Fields{"id": id, "udpPort": udpPort}).Debug("NewDatagramSubSession called")
+	udpconn, rUDPAddr, lport, err := s.listenUDP(udpPort)
+	if err != nil {
+		return nil, err
+	}
+	// the sub-session shares the control connection of the primary session
+	_, err = s.NewGenericSubSessionWithSignatureAndPortsContext(ctx, "DATAGRAM", id, "0", "0", []string{"PORT=" + lport})
+	if err != nil {
+		log.WithError(err).Error("Failed to create new generic sub-session")
+		udpconn.Close()
+		return nil, err
+	}
+
+	log.WithFields(logrus.Fields{"id": id, "localPort": lport}).Debug("Created new datagram sub-session")
+	datagramSession := &datagram.DatagramSession{
+		SAM:           (*datagram.SAM)(s.SAM),
+		SAMUDPAddress: rUDPAddr,
+		UDPConn:       udpconn,
+		RemoteI2PAddr: nil,
+	}
+	return datagramSession, nil
+}
+
+// listenUDP opens the UDP socket a datagram or raw sub-session receives on.
+// It returns the socket, the address of the bridge's UDP port udpPort, 7655
+// if 0, and the local port to send as PORT.
+func (s *PrimarySession) listenUDP(udpPort int) (*net.UDPConn, *net.UDPAddr, string, error) {
 	if udpPort > 65335 || udpPort < 0 {
 		log.WithField("udpPort", udpPort).Error("Invalid UDP port")
-		return nil, errors.New("udpPort needs to be in the intervall 0-65335")
+		return nil, nil, "", errors.New("udpPort needs to be in the intervall 0-65335")
 	}
 	if udpPort == 0 {
 		udpPort = 7655
@@ -25,49 +60,33 @@ func (s *PrimarySession) NewDatagramSubSession(id string, udpPort int) (*datagra
 	lhost, _, err := common.SplitHostPort(s.conn.LocalAddr().String())
 	if err != nil {
 		log.WithError(err).Error("Failed to split local host port")
-		s.Close()
-		return nil, err
+		return nil, nil, "", err
 	}
 	lUDPAddr, err := net.ResolveUDPAddr("udp4", lhost+":0")
 	if err != nil {
 		log.WithError(err).Error("Failed to resolve local UDP address")
-		return nil, err
-	}
-	udpconn, err := net.ListenUDP("udp4", lUDPAddr)
-	if err != nil {
-		log.WithError(err).Error("Failed to listen on UDP")
-		return nil, err
+		return nil, nil, "", err
 	}
 	rhost, _, err := common.SplitHostPort(s.conn.RemoteAddr().String())
 	if err != nil {
 		log.WithError(err).Error("Failed to split remote host port")
-		s.Close()
-		return nil, err
+		return nil, nil, "", err
 	}
 	rUDPAddr, err := net.ResolveUDPAddr("udp4", rhost+":"+strconv.Itoa(udpPort))
 	if err != nil {
 		log.WithError(err).Error("Failed to resolve remote UDP address")
-		return nil, err
+		return nil, nil, "", err
 	}
-	_, lport, err := net.SplitHostPort(udpconn.LocalAddr().String())
+	udpconn, err := net.ListenUDP("udp4", lUDPAddr)
 	if err != nil {
-		log.WithError(err).Error("Failed to get local port")
-		s.Close()
-		return nil, err
+		log.WithError(err).Error("Failed to listen on UDP")
+		return nil, nil, "", err
 	}
-	conn, err := s.NewGenericSubSession("DATAGRAM", id, []string{"PORT=" + lport})
+	_, lport, err := net.SplitHostPort(udpconn.LocalAddr().String())
 	if err != nil {
-		log.WithError(err).Error("Failed to create new generic sub-session")
-		return nil, err
-	}
-
-	log.WithFields(logrus.Fields{"id": id, "localPort": lport}).Debug("Created new datagram sub-session")
-	datagramSession := &datagram.DatagramSession{
-		SAM:           (*datagram.SAM)(s.SAM),
-		SAMUDPAddress: rUDPAddr,
-		UDPConn:       udpconn,
-		RemoteI2PAddr: nil,
+		log.WithError(err).Error("Failed to get local port")
+		udpconn.Close()
+		return nil, nil, "", err
 	}
-	datagramSession.Conn = conn
-	return datagramSession, nil
+	return udpconn, rUDPAddr, lport, nil
 }
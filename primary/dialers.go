@@ -7,6 +7,7 @@ import (
 
 	"github.com/go-i2p/go-sam-go/common"
 	"github.com/go-i2p/go-sam-go/datagram"
+	"github.com/go-i2p/go-sam-go/stream"
 	"github.com/sirupsen/logrus"
 )
 
@@ -27,32 +28,18 @@ func (sam *PrimarySession) Dial(network, addr string) (net.Conn, error) {
 // DialTCP implements x/dialer
 func (sam *PrimarySession) DialTCP(network string, laddr, raddr net.Addr) (net.Conn, error) {
 	log.WithFields(logrus.Fields{"network": network, "laddr": laddr, "raddr": raddr}).Debug("DialTCP() called")
-	ts, ok := sam.stsess[network+raddr.String()[0:4]]
-	var err error
-	if !ok {
-		ts, err = sam.NewUniqueStreamSubSession(network + raddr.String()[0:4])
-		if err != nil {
-			log.WithError(err).Error("Failed to create new unique stream sub-session")
-			return nil, err
-		}
-		sam.stsess[network+raddr.String()[0:4]] = ts
-		ts, _ = sam.stsess[network+raddr.String()[0:4]]
+	ts, err := sam.streamSubSession(network+raddr.String()[0:4], network+raddr.String()[0:4])
+	if err != nil {
+		return nil, err
 	}
 	return ts.Dial(network, raddr.String())
 }
 
 func (sam *PrimarySession) DialTCPI2P(network, laddr, raddr string) (net.Conn, error) {
 	log.WithFields(logrus.Fields{"network": network, "laddr": laddr, "raddr": raddr}).Debug("DialTCPI2P() called")
-	ts, ok := sam.stsess[network+raddr[0:4]]
-	var err error
-	if !ok {
-		ts, err = sam.NewUniqueStreamSubSession(network + laddr)
-		if err != nil {
-			log.WithError(err).Error("Failed to create new unique stream sub-session")
-			return nil, err
-		}
-		sam.stsess[network+raddr[0:4]] = ts
-		ts, _ = sam.stsess[network+raddr[0:4]]
+	ts, err := sam.streamSubSession(network+raddr[0:4], network+laddr)
+	if err != nil {
+		return nil, err
 	}
 	return ts.Dial(network, raddr)
 }
@@ -60,46 +47,78 @@ func (sam *PrimarySession) DialTCPI2P(network, laddr, raddr string) (net.Conn, e
 // DialUDP implements x/dialer
 func (sam *PrimarySession) DialUDP(network string, laddr, raddr net.Addr) (net.PacketConn, error) {
 	log.WithFields(logrus.Fields{"network": network, "laddr": laddr, "raddr": raddr}).Debug("DialUDP() called")
-	ds, ok := sam.dgsess[network+raddr.String()[0:4]]
-	var err error
-	if !ok {
-		ds, err = sam.NewDatagramSubSession(network+raddr.String()[0:4], 0)
-		if err != nil {
-			log.WithError(err).Error("Failed to create new datagram sub-session")
-			return nil, err
-		}
-		sam.dgsess[network+raddr.String()[0:4]] = ds
-		ds, _ = sam.dgsess[network+raddr.String()[0:4]]
+	ds, err := sam.datagramSubSession(network+raddr.String()[0:4], network+raddr.String()[0:4])
+	if err != nil {
+		return nil, err
 	}
 	return ds.Dial(network, raddr.String())
 }
 
 func (sam *PrimarySession) DialUDPI2P(network, laddr, raddr string) (*datagram.DatagramSession, error) {
 	log.WithFields(logrus.Fields{"network": network, "laddr": laddr, "raddr": raddr}).Debug("DialUDPI2P() called")
-	ds, ok := sam.dgsess[network+raddr[0:4]]
-	var err error
-	if !ok {
-		ds, err = sam.NewDatagramSubSession(network+laddr, 0)
-		if err != nil {
-			log.WithError(err).Error("Failed to create new datagram sub-session")
-			return nil, err
-		}
-		sam.dgsess[network+raddr[0:4]] = ds
-		ds, _ = sam.dgsess[network+raddr[0:4]]
+	ds, err := sam.datagramSubSession(network+raddr[0:4], network+laddr)
+	if err != nil {
+		return nil, err
 	}
 	return ds.Dial(network, raddr)
 }
 
-func (s *PrimarySession) Lookup(name string) (a net.Addr, err error) {
+// streamSubSession returns the stream sub-session used for key, adding it
+// as id on first use.
+func (sam *PrimarySession) streamSubSession(key, id string) (*stream.StreamSession, error) {
+	sam.mu.Lock()
+	defer sam.mu.Unlock()
+	if ts, ok := sam.stsess[key]; ok {
+		return ts, nil
+	}
+	ts, err := sam.NewUniqueStreamSubSession(id)
+	if err != nil {
+		log.WithError(err).Error("Failed to create new unique stream sub-session")
+		return nil, err
+	}
+	sam.stsess[key] = ts
+	return ts, nil
+}
+
+// datagramSubSession returns the datagram sub-session used for key, adding
+// it as id on first use.
+func (sam *PrimarySession) datagramSubSession(key, id string) (*datagram.DatagramSession, error) {
+	sam.mu.Lock()
+	defer sam.mu.Unlock()
+	if ds, ok := sam.dgsess[key]; ok {
+		return ds, nil
+	}
+	ds, err := sam.NewDatagramSubSession(id, 0)
+	if err != nil {
+		log.WithError(err).Error("Failed to create new datagram sub-session")
+		return nil, err
+	}
+	sam.dgsess[key] = ds
+	return ds, nil
+}
+
+// Lookup resolves name, without a port, on the primary session's control
+// connection, answering from the SAM's Cache when possible.
+func (s *PrimarySession) Lookup(name string) (net.Addr, error) {
 	log.WithField("name", name).Debug("Lookup() called")
-	var sam *common.SAM
 	name = strings.Split(name, ":")[0]
-	sam, err = common.NewSAM(s.samAddr)
-	if err == nil {
-		log.WithField("addr", a).Debug("Lookup successful")
-		defer sam.Close()
-		a, err = sam.Lookup(name)
+	addr, err := (*common.SAM)(s.SAM).Lookup(name)
+	if err != nil {
+		log.WithError(err).Error("Lookup failed")
+		return nil, err
 	}
-	log.WithError(err).Error("Lookup failed")
-	return
+	log.WithField("addr", addr).Debug("Lookup successful")
+	return addr, nil
+}
+
+// LookupOptions looks name up with the options its destination publishes,
+// see common.SAM.LookupOptions.
+func (s *PrimarySession) LookupOptions(name string) (*common.LookupResult, error) {
+	return (*common.SAM)(s.SAM).LookupOptions(name)
+}
+
+// LookupSelf returns the destination of the primary session as the bridge
+// knows it.
+func (s *PrimarySession) LookupSelf() (*common.LookupResult, error) {
+	return (*common.SAM)(s.SAM).LookupSelf()
 }
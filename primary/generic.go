@@ -1,7 +1,7 @@
 package primary
 
 import (
-	"errors"
+	"context"
 	"net"
 	"strings"
 
@@ -31,8 +31,21 @@ func (sam *PrimarySession) NewGenericSubSessionWithSignature(style, id string, e
 // setting extra to something else than []string{}.
 // This sam3 instance is now a session
 func (sam *PrimarySession) NewGenericSubSessionWithSignatureAndPorts(style, id, from, to string, extras []string) (net.Conn, error) {
+	ctx, cancel := (*common.SAM)(sam.SAM).ContextWithTimeout()
+	defer cancel()
+	return sam.NewGenericSubSessionWithSignatureAndPortsContext(ctx, style, id, from, to, extras)
+}
+
+// NewGenericSubSessionWithSignatureAndPortsContext is like
+// NewGenericSubSessionWithSignatureAndPorts, but gives up when ctx is done.
+func (sam *PrimarySession) NewGenericSubSessionWithSignatureAndPortsContext(ctx context.Context, style, id, from, to string, extras []string) (net.Conn, error) {
 	log.WithFields(logrus.Fields{"style": style, "id": id, "from": from, "to": to, "extras": extras}).Debug("newGenericSubSessionWithSignatureAndPorts called")
 
+	if (from != "0" && from != "") || (to != "0" && to != "") {
+		if err := (*common.SAM)(sam.SAM).Require(common.FeaturePorts); err != nil {
+			return nil, err
+		}
+	}
 	conn := sam.conn
 	fp := ""
 	tp := ""
@@ -42,60 +55,68 @@ func (sam *PrimarySession) NewGenericSubSessionWithSignatureAndPorts(style, id,
 	if to != "0" && to != "" {
 		tp = " TO_PORT=" + to
 	}
-	scmsg := []byte("SESSION ADD STYLE=" + style + " ID=" + id + fp + tp + " " + strings.Join(extras, " ") + "\n")
+	scmsg := "SESSION ADD STYLE=" + style + " ID=" + id + fp + tp + " " + strings.Join(extras, " ")
 
-	log.WithField("message", string(scmsg)).Debug("Sending SESSION ADD message")
+	log.WithField("message", scmsg).Debug("Sending SESSION ADD message")
 
-	for m, i := 0, 0; m != len(scmsg); i++ {
-		if i == 15 {
-			conn.Close()
-			log.Error("Writing to SAM failed after 15 attempts")
-			return nil, errors.New("writing to SAM failed")
-		}
-		n, err := conn.Write(scmsg[m:])
-		if err != nil {
-			log.WithError(err).Error("Failed to write to SAM connection")
-			conn.Close()
-			return nil, err
-		}
-		m += n
-	}
-	buf := make([]byte, 4096)
-	n, err := conn.Read(buf)
+	reply, err := (*common.SAM)(sam.SAM).CommandContext(ctx, scmsg)
 	if err != nil {
-		log.WithError(err).Error("Failed to read from SAM connection")
-		conn.Close()
+		// the primary session survives a failed or abandoned SESSION ADD,
+		// its monitor notices if the connection itself broke
+		log.WithError(err).Error("Failed to send SESSION ADD message")
 		return nil, err
 	}
-	text := string(buf[:n])
-	log.WithField("response", text).Debug("Received response from SAM")
-	// log.Println("SAM:", text)
-	if strings.HasPrefix(text, SESSION_ADDOK) {
-		//if sam.keys.String() != text[len(common.SESSION_ADDOK):len(text)-1] {
-		//conn.Close()
-		//return nil, errors.New("SAMv3 created a tunnel with keys other than the ones we asked it for")
-		//}
+	log.WithField("response", reply.String()).Debug("Received response from SAM")
+	if !reply.Is("SESSION", "STATUS") {
+		log.WithField("reply", reply.String()).Error("Unable to parse SAMv3 reply")
+		return nil, common.UnexpectedReply(reply)
+	}
+	switch reply.Result() {
+	case "OK":
 		log.Debug("Session added successfully")
 		return conn, nil //&StreamSession{id, conn, keys, nil, sync.RWMutex{}, nil}, nil
-	} else if text == common.SESSION_DUPLICATE_ID {
-		log.Error("Duplicate tunnel name")
-		conn.Close()
-		return nil, errors.New("Duplicate tunnel name")
-	} else if text == common.SESSION_DUPLICATE_DEST {
-		log.Error("Duplicate destination")
-		conn.Close()
-		return nil, errors.New("Duplicate destination")
-	} else if text == common.SESSION_INVALID_KEY {
-		log.Error("Invalid key - Primary Session")
-		conn.Close()
-		return nil, errors.New("Invalid key - Primary Session")
-	} else if strings.HasPrefix(text, common.SESSION_I2P_ERROR) {
-		log.WithField("error", text[len(common.SESSION_I2P_ERROR):]).Error("I2P error")
-		conn.Close()
-		return nil, errors.New("I2P error " + text[len(common.SESSION_I2P_ERROR):])
-	} else {
-		log.WithField("reply", text).Error("Unable to parse SAMv3 reply")
-		conn.Close()
-		return nil, errors.New("Unable to parse SAMv3 reply: " + text)
+	default:
+		log.WithFields(logrus.Fields{
+			"result":  reply.Result(),
+			"message": reply.Get("MESSAGE"),
+		}).Error("Failed to add subsession")
+		return nil, reply.Err()
+	}
+}
+
+// RemoveSubSession removes the sub-session id from the primary session.
+func (sam *PrimarySession) RemoveSubSession(id string) error {
+	ctx, cancel := (*common.SAM)(sam.SAM).ContextWithTimeout()
+	defer cancel()
+	return sam.RemoveSubSessionContext(ctx, id)
+}
+
+// RemoveSubSessionContext is like RemoveSubSession, but gives up when ctx
+// is done.
+func (sam *PrimarySession) RemoveSubSessionContext(ctx context.Context, id string) error {
+	return removeSubSession(ctx, (*common.SAM)(sam.SAM), id)
+}
+
+// removeSubSession sends SESSION REMOVE for the sub-session id on the
+// control connection of its primary session.
+func removeSubSession(ctx context.Context, sam *common.SAM, id string) error {
+	log.WithField("id", id).Debug("RemoveSubSession called")
+	reply, err := sam.CommandContext(ctx, "SESSION REMOVE ID="+id)
+	if err != nil {
+		log.WithError(err).Error("Failed to send SESSION REMOVE message")
+		return err
+	}
+	if !reply.Is("SESSION", "STATUS") {
+		log.WithField("reply", reply.String()).Error("Unable to parse SAMv3 reply")
+		return common.UnexpectedReply(reply)
+	}
+	if reply.Result() != "OK" {
+		log.WithFields(logrus.Fields{
+			"result":  reply.Result(),
+			"message": reply.Get("MESSAGE"),
+		}).Error("Failed to remove subsession")
+		return reply.Err()
 	}
+	log.Debug("Session removed successfully")
+	return nil
 }
@@ -0,0 +1,579 @@
+package primary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/go-sam-go/stream"
+	"github.com/go-i2p/i2pkeys"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrPortInUse is returned by PortMux.Listen and PortMux.ListenPacket for a
+// port which already has a listener.
+var ErrPortInUse = errors.New("port already has a listener")
+
+// PortMux serves several services on the virtual ports of one destination,
+// like HTTP on port 80 and another protocol on port 9000:
+//
+//	mux, err := primarySession.NewPortMux("services", 0)
+//	web, err := mux.Listen(80)
+//	other, err := mux.Listen(9000)
+//	fallback, err := mux.Listen(0)
+//
+// Streams are accepted on one stream session and handed to the listener of
+// their TO_PORT. Listening on port 0 gets the streams and datagrams of ports
+// nobody listens on; without it, those streams are closed and datagrams
+// dropped. Datagrams need a primary session, to which the mux adds a
+// sub-session per protocol.
+type PortMux struct {
+	primary *PrimarySession // nil for a mux over a STREAM session
+	id      string
+	udpPort int
+	session *stream.StreamSession
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	// mu guards the fields below
+	mu        sync.Mutex
+	streams   map[int]*muxListener
+	packets   map[muxPacketKey]*muxPacketConn
+	protocols map[int]*muxProtocol
+	acceptErr error
+	closed    bool
+}
+
+// NewPortMux adds the stream sub-session id to the primary session and
+// returns a PortMux accepting on it. Datagram sub-sessions are added as
+// id-datagram and id-raw followed by the protocol, on first use, and
+// forwarded from the bridge's UDP port udpPort, 7655 if 0.
+func (sam *PrimarySession) NewPortMux(id string, udpPort int) (*PortMux, error) {
+	log.WithFields(logrus.Fields{"id": id, "udpPort": udpPort}).Debug("NewPortMux called")
+	session, err := sam.NewStreamSubSession(id)
+	if err != nil {
+		return nil, err
+	}
+	m := newPortMux(session)
+	m.primary, m.id, m.udpPort = sam, id, udpPort
+	m.start()
+	return m, nil
+}
+
+// NewPortMux returns a PortMux accepting streams on session. It cannot
+// receive datagrams.
+func NewPortMux(session *stream.StreamSession) *PortMux {
+	log.WithField("id", session.ID()).Debug("NewPortMux called")
+	m := newPortMux(session)
+	m.start()
+	return m
+}
+
+func newPortMux(session *stream.StreamSession) *PortMux {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &PortMux{
+		session:   session,
+		ctx:       ctx,
+		cancel:    cancel,
+		streams:   map[int]*muxListener{},
+		packets:   map[muxPacketKey]*muxPacketConn{},
+		protocols: map[int]*muxProtocol{},
+	}
+}
+
+func (m *PortMux) start() {
+	m.wg.Add(1)
+	go m.acceptLoop()
+}
+
+// Addr returns the destination the mux serves.
+func (m *PortMux) Addr() i2pkeys.I2PAddr {
+	return m.session.Addr()
+}
+
+// Listen returns a listener for the streams to port, or to ports without a
+// listener if port is 0.
+func (m *PortMux) Listen(port int) (net.Listener, error) {
+	if _, err := common.ParsePort(strconv.Itoa(port)); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, net.ErrClosed
+	}
+	if m.acceptErr != nil {
+		return nil, m.acceptErr
+	}
+	if _, ok := m.streams[port]; ok {
+		return nil, fmt.Errorf("stream port %d: %w", port, ErrPortInUse)
+	}
+	l := &muxListener{
+		mux:   m,
+		port:  port,
+		conns: make(chan *stream.StreamConn, muxBacklog),
+		done:  make(chan struct{}),
+	}
+	m.streams[port] = l
+	log.WithFields(logrus.Fields{"id": m.session.ID(), "port": port}).Debug("PortMux listening on stream port")
+	return l, nil
+}
+
+// ListenPacket returns a net.PacketConn for the datagrams of protocol to
+// port, or to ports without a listener if port is 0. Protocol 17,
+// ProtocolDatagram, receives repliable datagrams; any other protocol raw
+// ones. The addresses of received datagrams are common.I2PAddrPort, without
+// a destination for raw datagrams. WriteTo takes a common.I2PAddrPort or an
+// i2pkeys.I2PAddr and sends from port. The first listener of a protocol
+// adds its sub-session, giving up after the SAM's Timeout or muxAddTimeout.
+func (m *PortMux) ListenPacket(protocol, port int) (net.PacketConn, error) {
+	if _, err := common.ParsePort(strconv.Itoa(port)); err != nil {
+		return nil, err
+	}
+	if protocol < 0 || protocol > 255 || protocol == 6 || protocol == 19 || protocol == 20 {
+		return nil, fmt.Errorf("Invalid datagram protocol %d", protocol)
+	}
+	if m.primary == nil {
+		return nil, errors.New("datagrams need a PortMux of a primary session")
+	}
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	if m.acceptErr != nil {
+		m.mu.Unlock()
+		return nil, m.acceptErr
+	}
+	key := muxPacketKey{protocol, port}
+	if _, ok := m.packets[key]; ok {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("protocol %d port %d: %w", protocol, port, ErrPortInUse)
+	}
+	// the conn reserves the port while the sub-session is added, without
+	// holding m.mu
+	c := &muxPacketConn{
+		mux:     m,
+		key:     key,
+		packets: make(chan muxPacket, muxBacklog),
+		done:    make(chan struct{}),
+	}
+	m.packets[key] = c
+	p, ok := m.protocols[protocol]
+	if !ok {
+		p = &muxProtocol{protocol: protocol, ready: make(chan struct{})}
+		m.protocols[protocol] = p
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		m.addProtocol(p)
+	}
+	<-p.ready
+	if p.err != nil {
+		m.mu.Lock()
+		if m.packets[key] == c {
+			delete(m.packets, key)
+		}
+		m.mu.Unlock()
+		return nil, p.err
+	}
+	c.proto = p
+	log.WithFields(logrus.Fields{"id": m.id, "protocol": protocol, "port": port}).Debug("PortMux listening on datagram port")
+	return c, nil
+}
+
+// Close closes the listeners and removes the sub-sessions the mux added.
+// It leaves the session the mux was made with open.
+func (m *PortMux) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	var protocols []*muxProtocol
+	for _, p := range m.protocols {
+		// the ones still being added are removed by addProtocol
+		if p.udp != nil {
+			protocols = append(protocols, p)
+		}
+	}
+	m.mu.Unlock()
+	log.WithField("id", m.session.ID()).Debug("Closing PortMux")
+	m.cancel()
+	var err error
+	for _, p := range protocols {
+		p.udp.Close()
+		if rerr := m.primary.RemoveSubSession(p.id); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	if m.primary != nil {
+		if rerr := m.primary.RemoveSubSession(m.id); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	m.wg.Wait()
+	return err
+}
+
+// muxBacklog is how many streams or datagrams wait for a listener; more are
+// closed or dropped.
+const muxBacklog = 16
+
+// acceptLoop hands the accepted streams to the listeners of their ports
+// until the mux is closed or accepting fails.
+func (m *PortMux) acceptLoop() {
+	defer m.wg.Done()
+	listener, err := m.session.Listen()
+	for err == nil {
+		var conn *stream.StreamConn
+		conn, err = listener.AcceptI2PContext(m.ctx)
+		if err != nil {
+			break
+		}
+		m.mu.Lock()
+		l, ok := m.streams[conn.LocalPort()]
+		if !ok {
+			l, ok = m.streams[0]
+		}
+		m.mu.Unlock()
+		if !ok {
+			log.WithField("port", conn.LocalPort()).Debug("No listener for stream port, closing stream")
+			conn.Close()
+			continue
+		}
+		l.deliver(conn)
+	}
+	if m.ctx.Err() != nil {
+		err = net.ErrClosed
+	} else {
+		log.WithError(err).Error("PortMux failed to accept streams")
+	}
+	m.mu.Lock()
+	m.acceptErr = err
+	streams := m.streams
+	m.streams = map[int]*muxListener{}
+	m.mu.Unlock()
+	for _, l := range streams {
+		l.close()
+	}
+}
+
+// muxListener receives the streams of one port of a PortMux.
+type muxListener struct {
+	mux   *PortMux
+	port  int
+	conns chan *stream.StreamConn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (l *muxListener) deliver(conn *stream.StreamConn) {
+	select {
+	case <-l.done:
+		conn.Close()
+	case l.conns <- conn:
+	default:
+		log.WithField("port", l.port).Warn("Stream backlog full, closing stream")
+		conn.Close()
+	}
+}
+
+// Accept returns the next stream to the listener's port.
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		l.mux.mu.Lock()
+		defer l.mux.mu.Unlock()
+		if l.mux.acceptErr != nil {
+			return nil, l.mux.acceptErr
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops listening on the port. Streams to it which were not
+// accepted yet are closed.
+func (l *muxListener) Close() error {
+	l.mux.mu.Lock()
+	if l.mux.streams[l.port] == l {
+		delete(l.mux.streams, l.port)
+	}
+	l.mux.mu.Unlock()
+	l.close()
+	return nil
+}
+
+func (l *muxListener) close() {
+	l.once.Do(func() {
+		close(l.done)
+		for {
+			select {
+			case conn := <-l.conns:
+				conn.Close()
+			default:
+				return
+			}
+		}
+	})
+}
+
+// Addr returns the destination and port of the listener.
+func (l *muxListener) Addr() net.Addr {
+	return common.I2PAddrPort{Addr: l.mux.Addr(), Port: l.port}
+}
+
+// ProtocolDatagram is the protocol of repliable datagrams, see
+// PortMux.ListenPacket. Raw datagrams use 18 unless told otherwise.
+const ProtocolDatagram = 17
+
+type muxPacketKey struct {
+	protocol, port int
+}
+
+// muxProtocol is the DATAGRAM or RAW sub-session of a PortMux receiving
+// the datagrams of one protocol.
+type muxProtocol struct {
+	protocol int
+	// ready is closed once the sub-session is added, or adding it failed
+	// with err; the fields below are set under the mux's mu before
+	ready chan struct{}
+	err   error
+	id    string
+	udp   *net.UDPConn
+	sam   *net.UDPAddr
+}
+
+type muxPacket struct {
+	data []byte
+	from common.I2PAddrPort
+}
+
+// muxAddTimeout bounds adding a datagram sub-session when the primary
+// session has no shorter Timeout.
+const muxAddTimeout = time.Minute
+
+// addProtocol adds the sub-session receiving p.protocol and closes
+// p.ready. m.mu is not held: SESSION ADD waits for the bridge.
+func (m *PortMux) addProtocol(p *muxProtocol) {
+	id, style := m.id+"-datagram", "DATAGRAM"
+	if p.protocol != ProtocolDatagram {
+		id, style = m.id+"-raw"+strconv.Itoa(p.protocol), "RAW"
+	}
+	udp, sam, err := m.addSubSession(id, style, p.protocol)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer close(p.ready)
+	if err == nil && m.closed {
+		udp.Close()
+		if rerr := m.primary.RemoveSubSession(id); rerr != nil {
+			log.WithError(rerr).WithField("id", id).Warn("Failed to remove sub-session of closed PortMux")
+		}
+		err = net.ErrClosed
+	}
+	if err != nil {
+		p.err = err
+		if m.protocols[p.protocol] == p {
+			// the next listener tries again
+			delete(m.protocols, p.protocol)
+		}
+		return
+	}
+	p.id, p.udp, p.sam = id, udp, sam
+	m.wg.Add(1)
+	go m.readLoop(p)
+}
+
+// addSubSession opens a UDP socket and adds the DATAGRAM or RAW sub-session
+// id forwarding protocol to it.
+func (m *PortMux) addSubSession(id, style string, protocol int) (*net.UDPConn, *net.UDPAddr, error) {
+	ctx, cancel := (*common.SAM)(m.primary.SAM).ContextWithTimeout()
+	defer cancel()
+	ctx, cancel = context.WithTimeout(ctx, muxAddTimeout)
+	defer cancel()
+	stop := context.AfterFunc(m.ctx, cancel)
+	defer stop()
+
+	udp, sam, lport, err := m.primary.listenUDP(m.udpPort)
+	if err != nil {
+		return nil, nil, err
+	}
+	extras := []string{"PORT=" + lport}
+	if style == "RAW" {
+		extras = append(extras, "PROTOCOL="+strconv.Itoa(protocol), "HEADER=true")
+	}
+	if _, err := m.primary.NewGenericSubSessionWithSignatureAndPortsContext(ctx, style, id, "0", "0", extras); err != nil {
+		udp.Close()
+		return nil, nil, err
+	}
+	return udp, sam, nil
+}
+
+// readLoop hands the datagrams of p to the listeners of their ports until
+// p's socket is closed.
+func (m *PortMux) readLoop(p *muxProtocol) {
+	defer m.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, saddr, err := p.udp.ReadFromUDP(buf)
+		if err != nil {
+			if m.ctx.Err() == nil {
+				log.WithError(err).Error("PortMux failed to read datagrams")
+			}
+			return
+		}
+		// only accept datagrams from the IP of the SAM bridge
+		if !saddr.IP.Equal(p.sam.IP) {
+			continue
+		}
+		header, data, ok := strings.Cut(string(buf[:n]), "\n")
+		if !ok {
+			log.Debug("Dropping datagram without header")
+			continue
+		}
+		from := common.I2PAddrPort{Port: common.ExtractPairInt(header, "FROM_PORT")}
+		if p.protocol == ProtocolDatagram {
+			from.Addr = i2pkeys.I2PAddr(common.ExtractDest(header))
+		}
+		to := common.ExtractPairInt(header, "TO_PORT")
+		m.mu.Lock()
+		c, ok := m.packets[muxPacketKey{p.protocol, to}]
+		if !ok {
+			c, ok = m.packets[muxPacketKey{p.protocol, 0}]
+		}
+		m.mu.Unlock()
+		if !ok {
+			log.WithFields(logrus.Fields{"protocol": p.protocol, "port": to}).Debug("No listener for datagram port, dropping datagram")
+			continue
+		}
+		c.deliver(muxPacket{data: []byte(data), from: from})
+	}
+}
+
+// muxPacketConn receives the datagrams of one protocol and port of a
+// PortMux.
+type muxPacketConn struct {
+	mux     *PortMux
+	key     muxPacketKey
+	proto   *muxProtocol
+	packets chan muxPacket
+	done    chan struct{}
+	once    sync.Once
+
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+func (c *muxPacketConn) deliver(p muxPacket) {
+	select {
+	case <-c.done:
+	case c.packets <- p:
+	default:
+		log.WithField("port", c.key.port).Debug("Datagram backlog full, dropping datagram")
+	}
+}
+
+// ReadFrom reads the next datagram. The read deadline in force when it is
+// called applies.
+func (c *muxPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.readDeadline
+	c.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case p := <-c.packets:
+		n := copy(b, p.data)
+		if n < len(p.data) {
+			return n, p.from, errors.New("Datagram did not fit into your buffer.")
+		}
+		return n, p.from, nil
+	case <-c.done:
+		return 0, nil, net.ErrClosed
+	case <-c.mux.ctx.Done():
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteTo sends b to addr, a common.I2PAddrPort or an i2pkeys.I2PAddr,
+// from the port of the conn.
+func (c *muxPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	var to common.I2PAddrPort
+	switch a := addr.(type) {
+	case common.I2PAddrPort:
+		to = a
+	case i2pkeys.I2PAddr:
+		to.Addr = a
+	default:
+		return 0, fmt.Errorf("Invalid I2P address %v", addr)
+	}
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	header := "3.2 " + c.proto.id + " " + to.Addr.Base64()
+	if c.key.port != 0 {
+		header += " FROM_PORT=" + strconv.Itoa(c.key.port)
+	}
+	if to.Port != 0 {
+		header += " TO_PORT=" + strconv.Itoa(to.Port)
+	}
+	if _, err := c.proto.udp.WriteToUDP(append([]byte(header+"\n"), b...), c.proto.sam); err != nil {
+		log.WithError(err).Error("Failed to write to UDP")
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close stops listening on the protocol and port.
+func (c *muxPacketConn) Close() error {
+	c.mux.mu.Lock()
+	if c.mux.packets[c.key] == c {
+		delete(c.mux.packets, c.key)
+	}
+	c.mux.mu.Unlock()
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+// LocalAddr returns the destination and port of the conn.
+func (c *muxPacketConn) LocalAddr() net.Addr {
+	return common.I2PAddrPort{Addr: c.mux.Addr(), Port: c.key.port}
+}
+
+func (c *muxPacketConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *muxPacketConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline does nothing, writes go to the bridge's UDP port
+// without blocking.
+func (c *muxPacketConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
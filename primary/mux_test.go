@@ -0,0 +1,273 @@
+package primary
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/go-sam-go/samtest"
+	"github.com/go-i2p/go-sam-go/stream"
+)
+
+func newTestPrimary(t *testing.T, b *samtest.Bridge, id string) *PrimarySession {
+	t.Helper()
+	commonSam, err := common.NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	keys, err := commonSam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	p, err := (*SAM)(commonSam).NewPrimarySession(id, keys, nil)
+	if err != nil {
+		t.Fatalf("NewPrimarySession() error = %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestPortMux_Streams(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	server := newTestPrimary(t, b, "server")
+	mux, err := server.NewPortMux("services", b.UDPPort())
+	if err != nil {
+		t.Fatalf("NewPortMux() error = %v", err)
+	}
+	defer mux.Close()
+	listeners := make(map[int]net.Listener)
+	for _, port := range []int{80, 9000, 0} {
+		if listeners[port], err = mux.Listen(port); err != nil {
+			t.Fatalf("Listen(%d) error = %v", port, err)
+		}
+	}
+	if _, err := mux.Listen(80); !errors.Is(err, ErrPortInUse) {
+		t.Errorf("second Listen(80) error = %v, want ErrPortInUse", err)
+	}
+
+	client, err := newTestPrimary(t, b, "client").NewStreamSubSession("client-stream")
+	if err != nil {
+		t.Fatalf("NewStreamSubSession() error = %v", err)
+	}
+	tests := []struct {
+		name     string
+		port     int
+		listener int
+	}{
+		{"http", 80, 80},
+		{"custom", 9000, 9000},
+		{"default", 1234, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := client.DialI2PWithPorts(mux.Addr(), 0, tt.port)
+			if err != nil {
+				t.Fatalf("DialI2PWithPorts() error = %v", err)
+			}
+			defer conn.Close()
+			accepted, err := listeners[tt.listener].Accept()
+			if err != nil {
+				t.Fatalf("Accept() error = %v", err)
+			}
+			defer accepted.Close()
+			want := common.I2PAddrPort{Addr: mux.Addr(), Port: tt.port}
+			if got := accepted.LocalAddr(); got != want {
+				t.Errorf("LocalAddr() = %v, want %v", got, want)
+			}
+		})
+	}
+
+	if err := mux.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := listeners[80].Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept() after Close() error = %v, want net.ErrClosed", err)
+	}
+	if slices.Contains(b.Sessions(), "services") {
+		t.Errorf("bridge sessions = %v after Close(), want services removed", b.Sessions())
+	}
+	select {
+	case <-server.Done():
+		t.Error("closing the mux closed the primary session")
+	default:
+	}
+}
+
+func TestPortMux_Datagrams(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	newMux := func(id string) *PortMux {
+		mux, err := newTestPrimary(t, b, id).NewPortMux(id+"-mux", b.UDPPort())
+		if err != nil {
+			t.Fatalf("NewPortMux() error = %v", err)
+		}
+		t.Cleanup(func() { mux.Close() })
+		return mux
+	}
+	listen := func(mux *PortMux, protocol, port int) net.PacketConn {
+		conn, err := mux.ListenPacket(protocol, port)
+		if err != nil {
+			t.Fatalf("ListenPacket(%d, %d) error = %v", protocol, port, err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	server, client := newMux("server"), newMux("client")
+	dns := listen(server, ProtocolDatagram, 53)
+	fallback := listen(server, ProtocolDatagram, 0)
+	rawDNS := listen(server, 18, 53)
+	from := listen(client, ProtocolDatagram, 5000)
+	rawFrom := listen(client, 18, 5000)
+	if _, err := server.ListenPacket(ProtocolDatagram, 53); !errors.Is(err, ErrPortInUse) {
+		t.Errorf("second ListenPacket(17, 53) error = %v, want ErrPortInUse", err)
+	}
+
+	tests := []struct {
+		name     string
+		from     net.PacketConn
+		to       int
+		conn     net.PacketConn
+		wantFrom common.I2PAddrPort
+	}{
+		{"repliable", from, 53, dns, common.I2PAddrPort{Addr: client.Addr(), Port: 5000}},
+		{"default", from, 99, fallback, common.I2PAddrPort{Addr: client.Addr(), Port: 5000}},
+		{"raw", rawFrom, 53, rawDNS, common.I2PAddrPort{Port: 5000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.from.WriteTo([]byte(tt.name), common.I2PAddrPort{Addr: server.Addr(), Port: tt.to}); err != nil {
+				t.Fatalf("WriteTo() error = %v", err)
+			}
+			buf := make([]byte, 64)
+			n, addr, err := tt.conn.ReadFrom(buf)
+			if err != nil {
+				t.Fatalf("ReadFrom() error = %v", err)
+			}
+			if string(buf[:n]) != tt.name || addr != tt.wantFrom {
+				t.Errorf("ReadFrom() = %q from %v, want %q from %v", buf[:n], addr, tt.name, tt.wantFrom)
+			}
+		})
+	}
+
+	commonSam, err := common.NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	keys, err := commonSam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	session, err := (&stream.SAM{SAM: commonSam}).NewStreamSession("stream", keys, nil)
+	if err != nil {
+		t.Fatalf("NewStreamSession() error = %v", err)
+	}
+	defer session.Close()
+	streamMux := NewPortMux(session)
+	defer streamMux.Close()
+	if _, err := streamMux.ListenPacket(ProtocolDatagram, 53); err == nil {
+		t.Error("ListenPacket() on the mux of a STREAM session succeeded")
+	}
+}
+
+func TestPortMux_AcceptFailed(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	server := newTestPrimary(t, b, "server")
+	b.Script("STREAM ACCEPT", "STREAM STATUS RESULT=I2P_ERROR MESSAGE=failed")
+	mux, err := server.NewPortMux("services", b.UDPPort())
+	if err != nil {
+		t.Fatalf("NewPortMux() error = %v", err)
+	}
+	defer mux.Close()
+	// the accept loop may fail before or after Listen
+	if l, err := mux.Listen(80); err == nil {
+		if _, err := l.Accept(); !errors.Is(err, common.ErrI2PError) {
+			t.Fatalf("Accept() error = %v, want ErrI2PError", err)
+		}
+	}
+	if _, err := mux.Listen(9000); !errors.Is(err, common.ErrI2PError) {
+		t.Errorf("Listen() after the accept loop failed error = %v, want ErrI2PError", err)
+	}
+	if _, err := mux.ListenPacket(ProtocolDatagram, 53); !errors.Is(err, common.ErrI2PError) {
+		t.Errorf("ListenPacket() after the accept loop failed error = %v, want ErrI2PError", err)
+	}
+}
+
+func TestPortMux_ListenPacketUnlocked(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	server := newTestPrimary(t, b, "server")
+	mux, err := server.NewPortMux("services", b.UDPPort())
+	if err != nil {
+		t.Fatalf("NewPortMux() error = %v", err)
+	}
+	defer mux.Close()
+	added, release := make(chan string, 2), make(chan struct{})
+	b.Handle("SESSION ADD", func(req *samtest.Request) string {
+		added <- req.Get("ID", "")
+		<-release
+		return "SESSION STATUS RESULT=OK"
+	})
+
+	type result struct {
+		conn net.PacketConn
+		err  error
+	}
+	results := make(chan result, 2)
+	for _, port := range []int{53, 54} {
+		go func() {
+			conn, err := mux.ListenPacket(ProtocolDatagram, port)
+			results <- result{conn, err}
+		}()
+	}
+	if id := <-added; id != "services-datagram" {
+		t.Errorf("SESSION ADD ID=%s, want services-datagram", id)
+	}
+	// the mux stays usable while the bridge adds the sub-session
+	done := make(chan error, 1)
+	go func() {
+		_, err := mux.Listen(80)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Listen() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Listen() blocked while a sub-session was being added")
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("ListenPacket() error = %v", r.err)
+		}
+		r.conn.Close()
+	}
+	select {
+	case id := <-added:
+		t.Errorf("second SESSION ADD ID=%s for the same protocol", id)
+	default:
+	}
+}
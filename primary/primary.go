@@ -1,6 +1,9 @@
 package primary
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/go-i2p/go-sam-go/common"
@@ -10,23 +13,88 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-var PrimarySessionSwitch string = "MASTER"
+// PrimarySessionSwitch overrides the STYLE used to create primary sessions.
+// Left empty, PRIMARY is tried first and MASTER if the bridge rejects it.
+var PrimarySessionSwitch string = ""
+
+// createPrimary creates a primary session with create, in the STYLE set by
+// PrimarySessionSwitch. Without one, PRIMARY is used if the bridge speaks
+// SAM 3.3. Bridges before I2P 0.9.47 speak SAM 3.3 as well but only know
+// MASTER, so when the bridge rejects the PRIMARY style the session is
+// created again as MASTER on a new control connection.
+func (sam *SAM) createPrimary(ctx context.Context, create func(sam *SAM, style string) (*PrimarySession, error)) (*PrimarySession, error) {
+	if PrimarySessionSwitch != "" {
+		return create(sam, PrimarySessionSwitch)
+	}
+	style, err := (*common.SAM)(sam).PrimaryStyle()
+	if err != nil {
+		log.WithError(err).Error("Primary sessions not supported")
+		return nil, err
+	}
+	p, err := create(sam, style)
+	if err == nil || style != "PRIMARY" || !styleRejected(err) {
+		return p, err
+	}
+	log.WithError(err).Warn("Bridge rejected STYLE=PRIMARY, retrying with MASTER")
+	(*common.SAM)(sam).Close()
+	next, rerr := (*common.SAM)(sam).ReconnectContext(ctx)
+	if rerr != nil {
+		log.WithError(rerr).Error("Failed to reconnect to SAM bridge")
+		return nil, errors.Join(err, rerr)
+	}
+	p, merr := create((*SAM)(next), "MASTER")
+	if merr != nil {
+		next.Close()
+		return nil, errors.Join(err, merr)
+	}
+	return p, nil
+}
+
+// styleRejected reports whether err is the bridge refusing the STYLE of a
+// SESSION CREATE, as bridges without PRIMARY sessions do.
+func styleRejected(err error) bool {
+	var serr *common.SAMError
+	if !errors.As(err, &serr) || !errors.Is(err, common.ErrI2PError) {
+		return false
+	}
+	msg := strings.ToUpper(serr.Message)
+	return strings.Contains(msg, "STYLE") || strings.Contains(msg, "PRIMARY")
+}
 
 // Creates a new PrimarySession with the I2CP- and streaminglib options as
 // specified. See the I2P documentation for a full list of options.
 func (sam *SAM) NewPrimarySession(id string, keys i2pkeys.I2PKeys, options []string) (*PrimarySession, error) {
+	ctx, cancel := (*common.SAM)(sam).ContextWithTimeout()
+	defer cancel()
+	return sam.NewPrimarySessionContext(ctx, id, keys, options)
+}
+
+// NewPrimarySessionContext is like NewPrimarySession, but gives up when ctx
+// is done.
+func (sam *SAM) NewPrimarySessionContext(ctx context.Context, id string, keys i2pkeys.I2PKeys, options []string) (*PrimarySession, error) {
 	log.WithFields(logrus.Fields{"id": id, "options": options}).Debug("NewPrimarySession() called")
-	return sam.newPrimarySession(PrimarySessionSwitch, id, keys, options)
+	return sam.createPrimary(ctx, func(sam *SAM, style string) (*PrimarySession, error) {
+		return sam.newPrimarySession(ctx, style, id, keys, options)
+	})
+}
+
+// NewPrimarySessionWithStyle is like NewPrimarySession, but creates the
+// session with STYLE=style, PRIMARY or MASTER, whatever the bridge's
+// version and PrimarySessionSwitch.
+func (sam *SAM) NewPrimarySessionWithStyle(style, id string, keys i2pkeys.I2PKeys, options []string) (*PrimarySession, error) {
+	ctx, cancel := (*common.SAM)(sam).ContextWithTimeout()
+	defer cancel()
+	return sam.newPrimarySession(ctx, style, id, keys, options)
 }
 
-func (sam *SAM) newPrimarySession(primarySessionSwitch, id string, keys i2pkeys.I2PKeys, options []string) (*PrimarySession, error) {
+func (sam *SAM) newPrimarySession(ctx context.Context, primarySessionSwitch, id string, keys i2pkeys.I2PKeys, options []string) (*PrimarySession, error) {
 	log.WithFields(logrus.Fields{
 		"primarySessionSwitch": primarySessionSwitch,
 		"id":                   id,
 		"options":              options,
 	}).Debug("newPrimarySession() called")
 
-	conn, err := sam.NewGenericSession(primarySessionSwitch, id, keys, options)
+	conn, err := (*common.SAM)(sam).NewGenericSessionContext(ctx, primarySessionSwitch, id, keys, options)
 	if err != nil {
 		log.WithError(err).Error("Failed to create new generic session")
 		return nil, err
@@ -48,14 +116,27 @@ func (sam *SAM) newPrimarySession(primarySessionSwitch, id string, keys i2pkeys.
 
 // Creates a new PrimarySession with the I2CP- and PRIMARYinglib options as
 // specified. See the I2P documentation for a full list of options.
-func (sam *SAM) NewPrimarySessionWithSignature(id string, keys i2pkeys.I2PKeys, options []string, sigType string) (*PrimarySession, error) {
+func (sam *SAM) NewPrimarySessionWithSignature(id string, keys i2pkeys.I2PKeys, options []string, sigType common.SigType) (*PrimarySession, error) {
+	ctx, cancel := (*common.SAM)(sam).ContextWithTimeout()
+	defer cancel()
+	return sam.NewPrimarySessionWithSignatureContext(ctx, id, keys, options, sigType)
+}
+
+// NewPrimarySessionWithSignatureContext is like
+// NewPrimarySessionWithSignature, but gives up when ctx is done.
+func (sam *SAM) NewPrimarySessionWithSignatureContext(ctx context.Context, id string, keys i2pkeys.I2PKeys, options []string, sigType common.SigType) (*PrimarySession, error) {
 	log.WithFields(logrus.Fields{
 		"id":      id,
 		"options": options,
 		"sigType": sigType,
 	}).Debug("NewPrimarySessionWithSignature() called")
+	return sam.createPrimary(ctx, func(sam *SAM, style string) (*PrimarySession, error) {
+		return sam.newPrimarySessionWithSignature(ctx, style, id, keys, options, sigType)
+	})
+}
 
-	conn, err := sam.NewGenericSessionWithSignature(PrimarySessionSwitch, id, keys, sigType, options)
+func (sam *SAM) newPrimarySessionWithSignature(ctx context.Context, style, id string, keys i2pkeys.I2PKeys, options []string, sigType common.SigType) (*PrimarySession, error) {
+	conn, err := (*common.SAM)(sam).NewGenericSessionWithSignatureAndPortsContext(ctx, style, id, "0", "0", keys, sigType, options)
 	if err != nil {
 		log.WithError(err).Error("Failed to create new generic session with signature")
 		return nil, err
@@ -74,3 +155,14 @@ func (sam *SAM) NewPrimarySessionWithSignature(id string, keys i2pkeys.I2PKeys,
 		dgsess:   map[string]*datagram.DatagramSession{},
 	}, nil
 }
+
+// Done returns a channel which is closed when the primary session's control
+// connection is closed or dies. All subsessions end with it.
+func (sam *PrimarySession) Done() <-chan struct{} {
+	return (*common.SAM)(sam.SAM).Done()
+}
+
+// Err returns why the primary session's control connection died, or nil.
+func (sam *PrimarySession) Err() error {
+	return (*common.SAM)(sam.SAM).Err()
+}
@@ -0,0 +1,157 @@
+package primary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/go-sam-go/samtest"
+)
+
+func TestNewPrimarySession_Style(t *testing.T) {
+	const (
+		rejected    = `SESSION STATUS RESULT=I2P_ERROR MESSAGE="Unrecognized SESSION STYLE: \"PRIMARY\""`
+		buildFailed = `SESSION STATUS RESULT=I2P_ERROR MESSAGE="Tunnel build failed"`
+	)
+	tests := []struct {
+		name      string
+		version   string
+		override  string
+		replies   []string
+		wantStyle string
+		wantErr   error
+		// wantMaster is whether a MASTER session was asked for
+		wantMaster bool
+		// wantReported are messages the error must carry
+		wantReported []string
+	}{
+		{"automatic on 3.3", "3.3", "", nil, "STYLE=PRIMARY", nil, false, nil},
+		{"override", "3.3", "MASTER", nil, "STYLE=MASTER", nil, true, nil},
+		{"MASTER when PRIMARY is rejected", "3.3", "", []string{rejected}, "STYLE=MASTER", nil, true, nil},
+		{"other errors are not retried", "3.3", "", []string{buildFailed}, "", common.ErrI2PError, false, nil},
+		{"override is not retried", "3.3", "PRIMARY", []string{rejected}, "", common.ErrI2PError, false, nil},
+		{"MASTER fails too", "3.3", "", []string{rejected, buildFailed}, "", common.ErrI2PError, true, []string{"SESSION STYLE", "Tunnel build failed"}},
+		{"unsupported on 3.2", "3.2", "", nil, "", common.ErrUnsupported, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := samtest.NewBridge()
+			if err != nil {
+				t.Fatalf("NewBridge() error = %v", err)
+			}
+			defer b.Close()
+			b.SetVersion(tt.version)
+			b.Script("SESSION CREATE", tt.replies...)
+			PrimarySessionSwitch = tt.override
+			defer func() { PrimarySessionSwitch = "" }()
+
+			commonSam, err := common.NewSAM(b.Addr())
+			if err != nil {
+				t.Fatalf("NewSAM() error = %v", err)
+			}
+			defer commonSam.Close()
+			keys, err := commonSam.NewKeys()
+			if err != nil {
+				t.Fatalf("NewKeys() error = %v", err)
+			}
+			_, err = (*SAM)(commonSam).NewPrimarySession("primary", keys, nil)
+			var master bool
+			for _, cmd := range b.Commands() {
+				master = master || strings.HasPrefix(cmd, "SESSION CREATE STYLE=MASTER ")
+			}
+			if master != tt.wantMaster {
+				t.Errorf("SESSION CREATE STYLE=MASTER sent = %v, want %v", master, tt.wantMaster)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("NewPrimarySession() error = %v, want %v", err, tt.wantErr)
+				}
+				for _, msg := range tt.wantReported {
+					if !strings.Contains(err.Error(), msg) {
+						t.Errorf("NewPrimarySession() error = %v, want it to report %q", err, msg)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewPrimarySession() error = %v", err)
+			}
+			var created bool
+			for _, cmd := range b.Commands() {
+				if strings.HasPrefix(cmd, "SESSION CREATE ") && strings.Contains(cmd, tt.wantStyle) {
+					created = true
+				}
+			}
+			if !created {
+				t.Errorf("no SESSION CREATE with %s in %q", tt.wantStyle, b.Commands())
+			}
+		})
+	}
+}
+
+func TestPrimarySession_ConcurrentSubSessions(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	commonSam, err := common.NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	defer commonSam.Close()
+	keys, err := commonSam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	p, err := (*SAM)(commonSam).NewPrimarySession("primary", keys, nil)
+	if err != nil {
+		t.Fatalf("NewPrimarySession() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := p.NewStreamSubSession(fmt.Sprintf("stream%d", i)); err != nil {
+				t.Errorf("NewStreamSubSession() error = %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := p.NewDatagramSubSession(fmt.Sprintf("datagram%d", i), 0); err != nil {
+				t.Errorf("NewDatagramSubSession() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := len(b.Sessions()); got != 21 {
+		t.Errorf("bridge has %d sessions, want 21: %v", got, b.Sessions())
+	}
+}
+
+func TestNewPrimarySessionWithSignatureContext_Cancelled(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	commonSam, err := common.NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	keys, err := commonSam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (*SAM)(commonSam).NewPrimarySessionWithSignatureContext(ctx, "primary", keys, nil, common.SIG_DEFAULT); !errors.Is(err, context.Canceled) {
+		t.Errorf("NewPrimarySessionWithSignatureContext() error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -57,7 +57,8 @@ func (s *PrimarySession) NewRawSubSession(id string, udpPort int) (*raw.RawSessi
 		return nil, err
 	}
 	//	conn, err := s.newGenericSubSession("RAW", id, s.keys, options, []string{"PORT=" + lport})
-	conn, err := s.NewGenericSubSession("RAW", id, []string{"PORT=" + lport})
+	// the sub-session shares the control connection of the primary session
+	_, err = s.NewGenericSubSession("RAW", id, []string{"PORT=" + lport})
 	if err != nil {
 		log.WithError(err).Error("Failed to create new generic sub-session")
 		return nil, err
@@ -69,6 +70,5 @@ func (s *PrimarySession) NewRawSubSession(id string, udpPort int) (*raw.RawSessi
 		SAMUDPConn: udpconn,
 		SAMUDPAddr: rUDPAddr,
 	}
-	rawSession.Conn = conn
 	return rawSession, nil
 }
@@ -0,0 +1,162 @@
+package primary
+
+import (
+	"context"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/go-sam-go/datagram"
+	"github.com/go-i2p/go-sam-go/stream"
+	"github.com/go-i2p/i2pkeys"
+	"github.com/sirupsen/logrus"
+)
+
+// ResilientPrimarySession is a PrimarySession which creates itself again,
+// with the same ID, keys and options, when the router restarts or the
+// bridge stops answering. Sub-sessions created through it are added again
+// once the primary session is back.
+type ResilientPrimarySession struct {
+	supervisor *common.Supervisor
+	backoff    common.Backoff
+	keys       i2pkeys.I2PKeys
+	id         string
+}
+
+// NewResilientPrimarySession creates a primary session like
+// NewPrimarySession and keeps it alive, creating it again with backoff
+// whenever its control connection is lost. A zero backoff uses
+// common.DefaultBackoff.
+func (sam *SAM) NewResilientPrimarySession(id string, keys i2pkeys.I2PKeys, options []string, backoff common.Backoff) (*ResilientPrimarySession, error) {
+	log.WithFields(logrus.Fields{"id": id, "options": options}).Debug("NewResilientPrimarySession() called")
+	first, err := sam.NewPrimarySession(id, keys, options)
+	if err != nil {
+		return nil, err
+	}
+	base := (*common.SAM)(first.SAM)
+	p := &ResilientPrimarySession{
+		backoff: backoff,
+		keys:    keys,
+		id:      id,
+	}
+	p.supervisor = common.NewSupervisor(first, func(ctx context.Context) (common.Session, error) {
+		next, err := base.ReconnectContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return (*SAM)(next).NewPrimarySessionContext(ctx, id, keys, options)
+	}, backoff)
+	return p, nil
+}
+
+// Session returns the current PrimarySession. It may be dead while a new
+// one is being created.
+func (p *ResilientPrimarySession) Session() *PrimarySession {
+	return p.supervisor.Current().(*PrimarySession)
+}
+
+// wait returns the current PrimarySession once it is alive.
+func (p *ResilientPrimarySession) wait(ctx context.Context) (*PrimarySession, error) {
+	session, err := p.supervisor.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return session.(*PrimarySession), nil
+}
+
+// ID returns the session ID, which is kept across re-creations.
+func (p *ResilientPrimarySession) ID() string {
+	return p.id
+}
+
+// Keys returns the keys of the session, which are kept across re-creations.
+func (p *ResilientPrimarySession) Keys() i2pkeys.I2PKeys {
+	return p.keys
+}
+
+// Addr returns the I2P destination of the session and its sub-sessions.
+func (p *ResilientPrimarySession) Addr() i2pkeys.I2PAddr {
+	return p.keys.Addr()
+}
+
+// NewStreamSubSession adds a stream sub-session like
+// PrimarySession.NewStreamSubSession. It is added again whenever the
+// primary session is created again. Closing the sub-session removes it
+// from the primary session, which stays open.
+func (p *ResilientPrimarySession) NewStreamSubSession(id string) (*stream.ResilientStreamSession, error) {
+	log.WithField("id", id).Debug("NewStreamSubSession called")
+	ctx, cancel := (*common.SAM)(p.Session().SAM).ContextWithTimeout()
+	defer cancel()
+	session, err := p.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	first, err := session.NewStreamSubSessionContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return stream.ResilientSubSession(first, id, func(ctx context.Context) (*stream.StreamSession, error) {
+		session, err := p.wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return session.NewStreamSubSessionContext(ctx, id)
+	}, func(session *stream.StreamSession) error {
+		return removeResilient(session.SAM.SAM, id)
+	}, p.backoff), nil
+}
+
+// NewDatagramSubSession adds a datagram sub-session like
+// PrimarySession.NewDatagramSubSession. It is added again whenever the
+// primary session is created again. Closing the sub-session removes it
+// from the primary session, which stays open.
+func (p *ResilientPrimarySession) NewDatagramSubSession(id string, udpPort int) (*datagram.ResilientDatagramSession, error) {
+	log.WithFields(logrus.Fields{"id": id, "udpPort": udpPort}).Debug("NewDatagramSubSession called")
+	ctx, cancel := (*common.SAM)(p.Session().SAM).ContextWithTimeout()
+	defer cancel()
+	session, err := p.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	first, err := session.NewDatagramSubSessionContext(ctx, id, udpPort)
+	if err != nil {
+		return nil, err
+	}
+	return datagram.ResilientSubSession(first, id, func(ctx context.Context) (*datagram.DatagramSession, error) {
+		session, err := p.wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return session.NewDatagramSubSessionContext(ctx, id, udpPort)
+	}, func(session *datagram.DatagramSession) error {
+		err := removeResilient((*common.SAM)(session.SAM), id)
+		if cerr := session.UDPConn.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}, p.backoff), nil
+}
+
+// removeResilient removes the resilient sub-session id from the primary
+// session whose control connection is sam. A sub-session lost with its
+// primary session needs no removing.
+func removeResilient(sam *common.SAM, id string) error {
+	select {
+	case <-sam.Done():
+		return nil
+	default:
+	}
+	ctx, cancel := sam.ContextWithTimeout()
+	defer cancel()
+	return removeSubSession(ctx, sam, id)
+}
+
+// Done is closed once the session is closed for good.
+func (p *ResilientPrimarySession) Done() <-chan struct{} {
+	return p.supervisor.Done()
+}
+
+// Close stops re-creating the session and closes it, together with its
+// sub-sessions.
+func (p *ResilientPrimarySession) Close() error {
+	log.WithField("id", p.ID()).Debug("Closing ResilientPrimarySession")
+	return p.supervisor.Close()
+}
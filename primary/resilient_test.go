@@ -0,0 +1,109 @@
+package primary
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/go-sam-go/samtest"
+)
+
+func TestResilientPrimarySession_AddsSubSessionsAgain(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	commonSam, err := common.NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	keys, err := commonSam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	backoff := common.Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	p, err := (*SAM)(commonSam).NewResilientPrimarySession("primary", keys, nil, backoff)
+	if err != nil {
+		t.Fatalf("NewResilientPrimarySession() error = %v", err)
+	}
+	defer p.Close()
+	web, err := p.NewStreamSubSession("web")
+	if err != nil {
+		t.Fatalf("NewStreamSubSession() error = %v", err)
+	}
+
+	first, firstWeb := p.Session(), web.Session()
+	if err := b.Disconnect("primary"); err != nil {
+		t.Fatalf("Disconnect() error = %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Session() == first || web.Session() == firstWeb || !slices.Contains(b.Sessions(), "web") {
+		if time.Now().After(deadline) {
+			t.Fatalf("sub-session was not added again, bridge has %v", b.Sessions())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if web.Addr() != keys.Addr() {
+		t.Errorf("sub-session Addr() = %s, want %s", web.Addr().Base32(), keys.Addr().Base32())
+	}
+}
+
+func TestResilientPrimarySession_CloseSubSession(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	commonSam, err := common.NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	keys, err := commonSam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	backoff := common.Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	p, err := (*SAM)(commonSam).NewResilientPrimarySession("primary", keys, nil, backoff)
+	if err != nil {
+		t.Fatalf("NewResilientPrimarySession() error = %v", err)
+	}
+	defer p.Close()
+	web, err := p.NewStreamSubSession("web")
+	if err != nil {
+		t.Fatalf("NewStreamSubSession() error = %v", err)
+	}
+	if got := web.ID(); got != "web" {
+		t.Errorf("stream sub-session ID() = %q, want %q", got, "web")
+	}
+	dns, err := p.NewDatagramSubSession("dns", 0)
+	if err != nil {
+		t.Fatalf("NewDatagramSubSession() error = %v", err)
+	}
+	if got := dns.ID(); got != "dns" {
+		t.Errorf("datagram sub-session ID() = %q, want %q", got, "dns")
+	}
+
+	if err := web.Close(); err != nil {
+		t.Fatalf("stream sub-session Close() error = %v", err)
+	}
+	if err := dns.Close(); err != nil {
+		t.Fatalf("datagram sub-session Close() error = %v", err)
+	}
+	if got := b.Sessions(); !slices.Equal(got, []string{"primary"}) {
+		t.Errorf("bridge has %v, want only the primary session", got)
+	}
+	select {
+	case <-p.Session().Done():
+		t.Fatalf("closing sub-sessions closed the primary session: %v", p.Session().Err())
+	case <-p.Done():
+		t.Fatal("closing sub-sessions stopped the primary session's supervision")
+	default:
+	}
+	if _, err := p.NewStreamSubSession("web"); err != nil {
+		t.Errorf("NewStreamSubSession() after Close() error = %v", err)
+	}
+}
@@ -1,7 +1,7 @@
 package primary
 
 import (
-	"net"
+	"context"
 
 	"github.com/go-i2p/go-sam-go/common"
 	"github.com/go-i2p/go-sam-go/stream"
@@ -11,49 +11,56 @@ import (
 // Creates a new stream.StreamSession with the I2CP- and streaminglib options as
 // specified. See the I2P documentation for a full list of options.
 func (sam *PrimarySession) NewStreamSubSession(id string) (*stream.StreamSession, error) {
+	ctx, cancel := (*common.SAM)(sam.SAM).ContextWithTimeout()
+	defer cancel()
+	return sam.NewStreamSubSessionContext(ctx, id)
+}
+
+// NewStreamSubSessionContext is like NewStreamSubSession, but gives up when
+// ctx is done.
+func (sam *PrimarySession) NewStreamSubSessionContext(ctx context.Context, id string) (*stream.StreamSession, error) {
 	log.WithField("id", id).Debug("NewStreamSubSession called")
-	conn, err := sam.NewGenericSubSession("STREAM", id, []string{})
+	_, err := sam.NewGenericSubSessionWithSignatureAndPortsContext(ctx, "STREAM", id, "0", "0", []string{})
 	if err != nil {
 		log.WithError(err).Error("Failed to create new generic sub-session")
 		return nil, err
 	}
-	return newFromPrimary(sam, conn), nil
+	return newFromPrimary(sam, id), nil
 }
 
 // Creates a new stream.StreamSession with the I2CP- and streaminglib options as
 // specified. See the I2P documentation for a full list of options.
 func (sam *PrimarySession) NewUniqueStreamSubSession(id string) (*stream.StreamSession, error) {
 	log.WithField("id", id).Debug("NewUniqueStreamSubSession called")
-	conn, err := sam.NewGenericSubSession("STREAM", id, []string{})
+	_, err := sam.NewGenericSubSession("STREAM", id, []string{})
 	if err != nil {
 		log.WithError(err).Error("Failed to create new generic sub-session")
 		return nil, err
 	}
 	fromPort, toPort := common.RandPort(), common.RandPort()
 	log.WithFields(logrus.Fields{"fromPort": fromPort, "toPort": toPort}).Debug("Generated random ports")
-	return newFromPrimary(sam, conn), nil
+	return newFromPrimary(sam, id), nil
 }
 
 // Creates a new stream.StreamSession with the I2CP- and streaminglib options as
 // specified. See the I2P documentation for a full list of options.
 func (sam *PrimarySession) NewStreamSubSessionWithPorts(id, from, to string) (*stream.StreamSession, error) {
 	log.WithFields(logrus.Fields{"id": id, "from": from, "to": to}).Debug("NewStreamSubSessionWithPorts called")
-	conn, err := sam.NewGenericSubSessionWithSignatureAndPorts("STREAM", id, from, to, []string{})
+	_, err := sam.NewGenericSubSessionWithSignatureAndPorts("STREAM", id, from, to, []string{})
 	if err != nil {
 		log.WithError(err).Error("Failed to create new generic sub-session with signature and ports")
 		return nil, err
 	}
-	return newFromPrimary(sam, conn), nil
+	return newFromPrimary(sam, id), nil
 }
 
-func newFromPrimary(sam *PrimarySession, conn net.Conn) *stream.StreamSession {
-	streamSession := &stream.StreamSession{
+// newFromPrimary returns the stream.StreamSession of the sub-session id,
+// sharing the control connection of the primary session.
+func newFromPrimary(sam *PrimarySession, id string) *stream.StreamSession {
+	return &stream.StreamSession{
 		SAM: &stream.SAM{
 			SAM: (*common.SAM)(sam.SAM),
 		},
+		SubSessionID: id,
 	}
-	streamSession.Conn = conn
-
-	return streamSession
-
 }
@@ -2,6 +2,7 @@ package primary
 
 import (
 	"net"
+	"sync"
 	"time"
 
 	"github.com/go-i2p/go-sam-go/common"
@@ -12,7 +13,8 @@ import (
 
 type SAM common.SAM
 
-// Represents a primary session.
+// Represents a primary session. A PrimarySession is safe for concurrent use,
+// sub-sessions may be added from several goroutines at once.
 type PrimarySession struct {
 	*SAM
 	samAddr  string          // address to the sam bridge (ipv4:port)
@@ -21,10 +23,12 @@ type PrimarySession struct {
 	keys     i2pkeys.I2PKeys // i2p destination keys
 	Timeout  time.Duration
 	Deadline time.Time
-	sigType  string
+	sigType  common.SigType
 	Config   common.SAMEmit
-	stsess   map[string]*stream.StreamSession
-	dgsess   map[string]*datagram.DatagramSession
+	// mu guards stsess and dgsess
+	mu     sync.Mutex
+	stsess map[string]*stream.StreamSession
+	dgsess map[string]*datagram.DatagramSession
 	//	from     string
 	//	to       string
 }
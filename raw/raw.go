@@ -1,6 +1,7 @@
 package raw
 
 import (
+	"context"
 	"errors"
 	"net"
 	"strconv"
@@ -13,6 +14,13 @@ import (
 // Creates a new raw session. udpPort is the UDP port SAM is listening on,
 // and if you set it to zero, it will use SAMs standard UDP port.
 func (s *SAM) NewRawSession(id string, keys i2pkeys.I2PKeys, options []string, udpPort int) (*RawSession, error) {
+	ctx, cancel := (*common.SAM)(s).ContextWithTimeout()
+	defer cancel()
+	return s.NewRawSessionContext(ctx, id, keys, options, udpPort)
+}
+
+// NewRawSessionContext is like NewRawSession, but gives up when ctx is done.
+func (s *SAM) NewRawSessionContext(ctx context.Context, id string, keys i2pkeys.I2PKeys, options []string, udpPort int) (*RawSession, error) {
 	log.WithFields(logrus.Fields{"id": id, "udpPort": udpPort}).Debug("Creating new RawSession")
 
 	if udpPort > 65335 || udpPort < 0 {
@@ -55,9 +63,10 @@ func (s *SAM) NewRawSession(id string, keys i2pkeys.I2PKeys, options []string, u
 		log.WithError(err).Error("Failed to get local port")
 		return nil, err
 	}
-	conn, err := s.NewGenericSession("RAW", id, keys, []string{"PORT=" + lport})
+	conn, err := (*common.SAM)(s).NewGenericSessionContext(ctx, "RAW", id, keys, common.MergeOptions(options, []string{"PORT=" + lport}))
 	if err != nil {
 		log.WithError(err).Error("Failed to create new generic session")
+		udpconn.Close()
 		return nil, err
 	}
 	log.WithFields(logrus.Fields{
@@ -71,3 +80,14 @@ func (s *SAM) NewRawSession(id string, keys i2pkeys.I2PKeys, options []string, u
 	rawSession.Conn = conn
 	return rawSession, nil
 }
+
+// Done returns a channel which is closed when the session's control
+// connection is closed or dies.
+func (s *RawSession) Done() <-chan struct{} {
+	return (*common.SAM)(s.SAM).Done()
+}
+
+// Err returns why the session's control connection died, or nil.
+func (s *RawSession) Err() error {
+	return (*common.SAM)(s.SAM).Err()
+}
@@ -2,6 +2,7 @@
 package sam3
 
 import (
+	"context"
 	"math/rand"
 
 	"github.com/go-i2p/go-sam-go/common"
@@ -46,7 +47,13 @@ func (s *SAM) NewDatagramSession(id string, keys i2pkeys.I2PKeys, options []stri
 
 func (s *SAM) NewPrimarySession(id string, keys i2pkeys.I2PKeys, options []string) (*PrimarySession, error) {
 	sam := primary.SAM(*s.SAM)
-	ps, err := sam.NewPrimarySession(id, keys, options)
+	var ps *primary.PrimarySession
+	var err error
+	if PrimarySessionSwitch != "" {
+		ps, err = sam.NewPrimarySessionWithStyle(PrimarySessionSwitch, id, keys, options)
+	} else {
+		ps, err = sam.NewPrimarySession(id, keys, options)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -57,12 +64,12 @@ func (s *SAM) NewPrimarySession(id string, keys i2pkeys.I2PKeys, options []strin
 }
 
 const (
-	Sig_NONE                 = "SIGNATURE_TYPE=EdDSA_SHA512_Ed25519"
-	Sig_DSA_SHA1             = "SIGNATURE_TYPE=DSA_SHA1"
-	Sig_ECDSA_SHA256_P256    = "SIGNATURE_TYPE=ECDSA_SHA256_P256"
-	Sig_ECDSA_SHA384_P384    = "SIGNATURE_TYPE=ECDSA_SHA384_P384"
-	Sig_ECDSA_SHA512_P521    = "SIGNATURE_TYPE=ECDSA_SHA512_P521"
-	Sig_EdDSA_SHA512_Ed25519 = "SIGNATURE_TYPE=EdDSA_SHA512_Ed25519"
+	Sig_NONE                 = common.SIG_NONE
+	Sig_DSA_SHA1             = common.SIG_DSA_SHA1
+	Sig_ECDSA_SHA256_P256    = common.SIG_ECDSA_SHA256_P256
+	Sig_ECDSA_SHA384_P384    = common.SIG_ECDSA_SHA384_P384
+	Sig_ECDSA_SHA512_P521    = common.SIG_ECDSA_SHA512_P521
+	Sig_EdDSA_SHA512_Ed25519 = common.SIG_EdDSA_SHA512_Ed25519
 )
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
@@ -77,9 +84,15 @@ func RandString() string {
 	return string(b)
 }
 
-// Creates a new controller for the I2P routers SAM bridge.
-func NewSAM(address string) (*SAM, error) {
-	is, err := common.NewSAM(address)
+// Creates a new controller for the I2P routers SAM bridge. Options such as
+// common.SetSAMAuth are applied before the handshake.
+func NewSAM(address string, opts ...func(*common.SAMEmit) error) (*SAM, error) {
+	return NewSAMContext(context.Background(), address, opts...)
+}
+
+// NewSAMContext is like NewSAM, but gives up when ctx is done.
+func NewSAMContext(ctx context.Context, address string, opts ...func(*common.SAMEmit) error) (*SAM, error) {
+	is, err := common.NewSAMContext(ctx, address, opts...)
 	if err != nil {
 		log.WithError(err).Error("Failed to create new SAM instance")
 		return nil, err
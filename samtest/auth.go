@@ -0,0 +1,75 @@
+package samtest
+
+import "github.com/sirupsen/logrus"
+
+// SetAuth turns HELLO authentication on or off, like the router's
+// sam.auth setting. Users are added with AddUser or the AUTH ADD command.
+func (b *Bridge) SetAuth(enabled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.auth = enabled
+}
+
+// AddUser registers a USER/PASSWORD pair accepted in HELLO.
+func (b *Bridge) AddUser(user, password string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.users[user] = password
+}
+
+// checkAuth returns the I2P_ERROR message for a HELLO that fails
+// authentication, or the empty string.
+func (b *Bridge) checkAuth(req *Request) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.auth {
+		return ""
+	}
+	user, password := req.Get("USER", ""), req.Get("PASSWORD", "")
+	if user == "" || password == "" {
+		return "USER and PASSWORD required"
+	}
+	if want, ok := b.users[user]; !ok || want != password {
+		return "Authorization failed"
+	}
+	return ""
+}
+
+func (b *Bridge) authCommand(c *client, req *Request) bool {
+	b.mu.Lock()
+	switch req.Action {
+	case "ENABLE":
+		b.auth = true
+	case "DISABLE":
+		b.auth = false
+	case "ADD":
+		user := req.Get("USER", "")
+		if user == "" || req.Get("PASSWORD", "") == "" {
+			b.mu.Unlock()
+			return c.reply(`AUTH STATUS RESULT=I2P_ERROR MESSAGE="USER and PASSWORD required"`) == nil
+		}
+		if _, ok := b.users[user]; ok {
+			b.mu.Unlock()
+			return c.reply(`AUTH STATUS RESULT=I2P_ERROR MESSAGE="user already exists"`) == nil
+		}
+		b.users[user] = req.Get("PASSWORD", "")
+	case "REMOVE":
+		user := req.Get("USER", "")
+		if _, ok := b.users[user]; !ok {
+			b.mu.Unlock()
+			return c.reply(`AUTH STATUS RESULT=I2P_ERROR MESSAGE="user not found"`) == nil
+		}
+		delete(b.users, user)
+	default:
+		b.mu.Unlock()
+		return c.reply(`AUTH STATUS RESULT=I2P_ERROR MESSAGE="Unsupported command"`) == nil
+	}
+	auth, users := b.auth, len(b.users)
+	b.mu.Unlock()
+	log.WithFields(logrus.Fields{
+		"action": req.Action,
+		"auth":   auth,
+		"users":  users,
+	}).Debug("Fake bridge changed authentication")
+	return c.reply("AUTH STATUS RESULT=OK") == nil
+}
@@ -0,0 +1,457 @@
+// Package samtest provides an in-process stand-in for a SAMv3 bridge, so the
+// session, stream and datagram code can be exercised without an I2P router.
+//
+// A Bridge listens on a loopback TCP port for control connections and on a
+// loopback UDP port for datagrams. Sessions created against the same Bridge
+// can reach each other: STREAM CONNECT is paired with a pending STREAM ACCEPT
+// on the target destination and datagrams are forwarded between the
+// registered UDP ports. Replies can be scripted per command to simulate
+// failures such as DUPLICATED_ID or CANT_REACH_PEER.
+package samtest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultVersion is the highest SAM version a Bridge negotiates unless
+// changed with SetVersion.
+const DefaultVersion = "3.3"
+
+// Handler produces the reply line for a request. Returning an empty string
+// falls back to the Bridge's built-in behaviour.
+type Handler func(req *Request) string
+
+// Bridge is a scriptable fake SAMv3 bridge bound to loopback.
+type Bridge struct {
+	listener net.Listener
+	udp      *net.UDPConn
+	// extra are the listeners added with Serve
+	extra []net.Listener
+
+	mu             sync.Mutex
+	version        string
+	connectTimeout time.Duration
+	sessions       map[string]*session
+	names          map[string]string
+	// options are the lease set options of destinations
+	options     map[string]map[string]string
+	scripts     map[string][]string
+	handlers    map[string]Handler
+	commands    []string
+	conns       map[net.Conn]struct{}
+	auth        bool
+	users       map[string]string
+	ignorePings bool
+	closed      bool
+	// done is closed by Close to abort commands still waiting, such as a
+	// STREAM CONNECT with no matching accept
+	done chan struct{}
+
+	wg sync.WaitGroup
+}
+
+// NewBridge starts a Bridge on 127.0.0.1 with a random TCP and UDP port.
+func NewBridge() (*Bridge, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on loopback: %w", err)
+	}
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to listen on loopback UDP: %w", err)
+	}
+	b := &Bridge{
+		listener:       l,
+		udp:            udp,
+		version:        DefaultVersion,
+		connectTimeout: 5 * time.Second,
+		sessions:       map[string]*session{},
+		names:          map[string]string{},
+		options:        map[string]map[string]string{},
+		scripts:        map[string][]string{},
+		handlers:       map[string]Handler{},
+		conns:          map[net.Conn]struct{}{},
+		users:          map[string]string{},
+		done:           make(chan struct{}),
+	}
+	b.wg.Add(2)
+	go b.serve(l)
+	go b.serveUDP()
+	log.WithFields(logrus.Fields{
+		"tcp": b.Addr(),
+		"udp": b.UDPPort(),
+	}).Debug("Started fake SAM bridge")
+	return b, nil
+}
+
+// Addr returns the host:port of the control listener, suitable for NewSAM.
+func (b *Bridge) Addr() string {
+	return b.listener.Addr().String()
+}
+
+// UDPPort returns the port datagram sessions should send to.
+func (b *Bridge) UDPPort() int {
+	return b.udp.LocalAddr().(*net.UDPAddr).Port
+}
+
+// SetVersion sets the highest SAM version the Bridge will negotiate.
+func (b *Bridge) SetVersion(v string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.version = v
+}
+
+// SetConnectTimeout bounds how long STREAM CONNECT waits for the target to
+// have a STREAM ACCEPT pending before replying TIMEOUT.
+func (b *Bridge) SetConnectTimeout(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connectTimeout = d
+}
+
+// AddName makes name resolvable to the base64 destination dest.
+func (b *Bridge) AddName(name, dest string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.names[name] = dest
+}
+
+// SetOptions publishes opts in the lease set of the destination dest, as
+// returned by NAMING LOOKUP with OPTIONS=true.
+func (b *Bridge) SetOptions(dest string, opts map[string]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.options[dest] = opts
+}
+
+// Script queues canned replies for the next commands matching command, a
+// "VERB ACTION" pair such as "SESSION CREATE". Each reply is used once, in
+// order, instead of the built-in behaviour.
+func (b *Bridge) Script(command string, replies ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.scripts[command] = append(b.scripts[command], replies...)
+}
+
+// Handle installs h for every command matching command. Scripted replies
+// take precedence over handlers.
+func (b *Bridge) Handle(command string, h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[command] = h
+}
+
+// Commands returns every command line received so far, in arrival order.
+func (b *Bridge) Commands() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.commands...)
+}
+
+// Sessions returns the IDs of the sessions currently registered.
+func (b *Bridge) Sessions() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ids := make([]string, 0, len(b.sessions))
+	for id := range b.sessions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Close stops the Bridge and closes every connection it holds.
+func (b *Bridge) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	for c := range b.conns {
+		c.Close()
+	}
+	extra := b.extra
+	b.mu.Unlock()
+	err := b.listener.Close()
+	for _, l := range extra {
+		l.Close()
+	}
+	b.udp.Close()
+	b.wg.Wait()
+	log.Debug("Stopped fake SAM bridge")
+	return err
+}
+
+// Serve accepts control connections on l as well, such as a Unix socket or
+// a TLS listener wrapping one. l is closed by Close.
+func (b *Bridge) Serve(l net.Listener) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		l.Close()
+		return
+	}
+	b.extra = append(b.extra, l)
+	b.wg.Add(1)
+	go b.serve(l)
+}
+
+func (b *Bridge) serve(l net.Listener) {
+	defer b.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		if !b.track(conn) {
+			conn.Close()
+			return
+		}
+		b.wg.Add(1)
+		go b.serveConn(conn)
+	}
+}
+
+func (b *Bridge) track(conn net.Conn) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false
+	}
+	b.conns[conn] = struct{}{}
+	return true
+}
+
+func (b *Bridge) untrack(conn net.Conn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.conns, conn)
+}
+
+// client is the Bridge's view of one control connection.
+type client struct {
+	wmu     sync.Mutex
+	conn    net.Conn
+	r       *bufio.Reader
+	version string
+	session *session
+	silent  bool
+	// detached is set once the connection has been handed over to a stream
+	detached bool
+	// forwarding is the session this connection sent STREAM FORWARD for
+	forwarding *session
+}
+
+func (c *client) reply(line string) error {
+	log.WithField("reply", line).Debug("Fake bridge reply")
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := io.WriteString(c.conn, line+"\n")
+	return err
+}
+
+func (b *Bridge) serveConn(conn net.Conn) {
+	defer b.wg.Done()
+	c := &client{conn: conn, r: bufio.NewReader(conn)}
+	defer func() {
+		if c.session != nil {
+			b.dropSession(c.session)
+		}
+		if c.forwarding != nil {
+			b.stopForward(c)
+		}
+		if !c.detached {
+			conn.Close()
+			b.untrack(conn)
+		}
+	}()
+	for !c.detached {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		req := parseRequest(line)
+		log.WithField("command", line).Debug("Fake bridge received command")
+		if !b.handle(c, req) {
+			return
+		}
+	}
+}
+
+// handle answers one request and reports whether the connection should stay
+// open.
+func (b *Bridge) handle(c *client, req *Request) bool {
+	b.mu.Lock()
+	b.commands = append(b.commands, req.Line)
+	var reply string
+	if queued := b.scripts[req.Command()]; len(queued) > 0 {
+		reply = queued[0]
+		b.scripts[req.Command()] = queued[1:]
+	} else if h, ok := b.handlers[req.Command()]; ok {
+		b.mu.Unlock()
+		reply = h(req)
+		b.mu.Lock()
+	}
+	b.mu.Unlock()
+	if reply != "" {
+		if req.Verb == "HELLO" && strings.Contains(reply, "RESULT=OK") {
+			c.version = req.Get("MAX", DefaultVersion)
+		}
+		return c.reply(reply) == nil
+	}
+
+	if c.version == "" && req.Verb != "HELLO" {
+		c.reply(req.Verb + " REPLY RESULT=I2P_ERROR MESSAGE=\"Must start with HELLO VERSION\"")
+		return false
+	}
+	switch req.Verb {
+	case "PING":
+		return b.ping(c, req)
+	case "PONG":
+		return true
+	}
+	switch req.Command() {
+	case "HELLO VERSION":
+		return b.hello(c, req)
+	case "DEST GENERATE":
+		return b.destGenerate(c, req)
+	case "NAMING LOOKUP":
+		return b.namingLookup(c, req)
+	case "SESSION CREATE":
+		return b.sessionCreate(c, req)
+	case "SESSION ADD":
+		return b.sessionAdd(c, req)
+	case "SESSION REMOVE":
+		return b.sessionRemove(c, req)
+	case "STREAM CONNECT":
+		return b.streamConnect(c, req)
+	case "STREAM ACCEPT":
+		return b.streamAccept(c, req)
+	case "STREAM FORWARD":
+		return b.streamForward(c, req)
+	case "AUTH ENABLE", "AUTH DISABLE", "AUTH ADD", "AUTH REMOVE":
+		return b.authCommand(c, req)
+	default:
+		return c.reply(req.Verb+" STATUS RESULT=I2P_ERROR MESSAGE=\"Unsupported command\"") == nil
+	}
+}
+
+func (b *Bridge) hello(c *client, req *Request) bool {
+	b.mu.Lock()
+	max := b.version
+	b.mu.Unlock()
+	min := req.Get("MIN", "3.0")
+	if want := req.Get("MAX", max); compareVersion(want, max) < 0 {
+		max = want
+	}
+	if compareVersion(min, max) > 0 {
+		c.reply("HELLO REPLY RESULT=NOVERSION")
+		return false
+	}
+	if msg := b.checkAuth(req); msg != "" {
+		c.reply(`HELLO REPLY RESULT=I2P_ERROR MESSAGE="` + msg + `"`)
+		return false
+	}
+	c.version = max
+	return c.reply("HELLO REPLY RESULT=OK VERSION="+max) == nil
+}
+
+func (b *Bridge) destGenerate(c *client, req *Request) bool {
+	sigType, ok := parseSigType(req.Get("SIGNATURE_TYPE", ""))
+	if !ok {
+		return c.reply(`DEST REPLY RESULT=I2P_ERROR MESSAGE="Invalid SIGNATURE_TYPE"`) == nil
+	}
+	pub, priv := newDestination(sigType)
+	return c.reply("DEST REPLY PUB="+pub+" PRIV="+priv) == nil
+}
+
+func (b *Bridge) namingLookup(c *client, req *Request) bool {
+	name := req.Get("NAME", "")
+	if name == "ME" && c.session != nil {
+		return c.reply("NAMING REPLY RESULT=OK NAME=ME VALUE="+c.session.pub) == nil
+	}
+	if dest, ok := b.resolve(name); ok {
+		reply := "NAMING REPLY RESULT=OK NAME=" + name + " VALUE=" + dest
+		if req.Get("OPTIONS", "") == "true" {
+			reply += b.leaseSetOptions(dest)
+		}
+		return c.reply(reply) == nil
+	}
+	return c.reply("NAMING REPLY RESULT=KEY_NOT_FOUND NAME="+name) == nil
+}
+
+// leaseSetOptions formats the lease set options of dest as OPTION: pairs of
+// a NAMING REPLY, sorted by key.
+func (b *Bridge) leaseSetOptions(dest string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	opts := b.options[dest]
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var s strings.Builder
+	for _, k := range keys {
+		v := opts[k]
+		if strings.ContainsAny(v, " \t\"") {
+			v = `"` + strings.ReplaceAll(strings.ReplaceAll(v, `\`, `\\`), `"`, `\"`) + `"`
+		}
+		s.WriteString(" OPTION:" + k + "=" + v)
+	}
+	return s.String()
+}
+
+// resolve maps a hostname, .b32.i2p address or base64 destination to a
+// base64 destination known to the Bridge.
+func (b *Bridge) resolve(name string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if dest, ok := b.names[name]; ok {
+		return dest, true
+	}
+	if isDestination(name) {
+		return name, true
+	}
+	if strings.HasSuffix(name, ".b32.i2p") {
+		for _, s := range b.sessions {
+			if B32(s.pub) == name {
+				return s.pub, true
+			}
+		}
+		for _, dest := range b.names {
+			if B32(dest) == name {
+				return dest, true
+			}
+		}
+	}
+	return "", false
+}
+
+// compareVersion compares two "major.minor" SAM version strings.
+func compareVersion(a, b string) int {
+	var amaj, amin, bmaj, bmin int
+	fmt.Sscanf(a, "%d.%d", &amaj, &amin)
+	fmt.Sscanf(b, "%d.%d", &bmaj, &bmin)
+	switch {
+	case amaj != bmaj:
+		return amaj - bmaj
+	default:
+		return amin - bmin
+	}
+}
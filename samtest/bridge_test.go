@@ -0,0 +1,120 @@
+package samtest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func dial(t *testing.T, b *Bridge) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", b.Addr())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+func roundTrip(t *testing.T, conn net.Conn, r *bufio.Reader, cmd string) string {
+	t.Helper()
+	if _, err := io.WriteString(conn, cmd+"\n"); err != nil {
+		t.Fatalf("write %q: %v", cmd, err)
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read reply to %q: %v", cmd, err)
+	}
+	return strings.TrimSpace(line)
+}
+
+func TestBridge_Handshake(t *testing.T) {
+	b, err := NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	tests := []struct {
+		name    string
+		version string
+		hello   string
+		want    string
+	}{
+		{"negotiates highest common", "3.3", "HELLO VERSION MIN=3.0 MAX=3.1", "HELLO REPLY RESULT=OK VERSION=3.1"},
+		{"caps at bridge version", "3.2", "HELLO VERSION MIN=3.0 MAX=3.3", "HELLO REPLY RESULT=OK VERSION=3.2"},
+		{"no overlap", "3.1", "HELLO VERSION MIN=3.2 MAX=3.3", "HELLO REPLY RESULT=NOVERSION"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.SetVersion(tt.version)
+			conn, r := dial(t, b)
+			if got := roundTrip(t, conn, r, tt.hello); got != tt.want {
+				t.Errorf("HELLO reply = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBridge_SessionAndLookup(t *testing.T) {
+	b, err := NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	conn, r := dial(t, b)
+	roundTrip(t, conn, r, "HELLO VERSION MIN=3.0 MAX=3.3")
+	reply := roundTrip(t, conn, r, "DEST GENERATE SIGNATURE_TYPE=7")
+	req := parseRequest(reply)
+	pub, priv := req.Get("PUB", ""), req.Get("PRIV", "")
+	if !isDestination(pub) || !strings.HasPrefix(priv, pub[:500]) {
+		t.Fatalf("DEST GENERATE reply malformed: %q", reply)
+	}
+	if got, err := publicFromPrivate(priv); err != nil || got != pub {
+		t.Fatalf("publicFromPrivate() = %q, %v; want %q", got, err, pub)
+	}
+
+	if got := roundTrip(t, conn, r, "SESSION CREATE STYLE=STREAM ID=one DESTINATION="+priv); got != "SESSION STATUS RESULT=OK DESTINATION="+priv {
+		t.Fatalf("SESSION CREATE reply = %q", got)
+	}
+	if got := roundTrip(t, conn, r, "NAMING LOOKUP NAME=ME"); got != "NAMING REPLY RESULT=OK NAME=ME VALUE="+pub {
+		t.Errorf("NAMING LOOKUP NAME=ME reply = %q", got)
+	}
+	if got := roundTrip(t, conn, r, "NAMING LOOKUP NAME="+B32(pub)); !strings.HasSuffix(got, "VALUE="+pub) {
+		t.Errorf("NAMING LOOKUP b32 reply = %q", got)
+	}
+	if got := roundTrip(t, conn, r, "NAMING LOOKUP NAME=nowhere.i2p"); got != "NAMING REPLY RESULT=KEY_NOT_FOUND NAME=nowhere.i2p" {
+		t.Errorf("NAMING LOOKUP unknown reply = %q", got)
+	}
+
+	other, r2 := dial(t, b)
+	roundTrip(t, other, r2, "HELLO VERSION MIN=3.0 MAX=3.3")
+	if got := roundTrip(t, other, r2, "SESSION CREATE STYLE=STREAM ID=one DESTINATION=TRANSIENT"); got != "SESSION STATUS RESULT=DUPLICATED_ID" {
+		t.Errorf("duplicate SESSION CREATE reply = %q", got)
+	}
+}
+
+func TestBridge_Script(t *testing.T) {
+	b, err := NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	b.Script("SESSION CREATE", `SESSION STATUS RESULT=I2P_ERROR MESSAGE="tunnel build failed"`)
+
+	conn, r := dial(t, b)
+	roundTrip(t, conn, r, "HELLO VERSION MIN=3.0 MAX=3.3")
+	if got := roundTrip(t, conn, r, "SESSION CREATE STYLE=STREAM ID=x DESTINATION=TRANSIENT"); got != `SESSION STATUS RESULT=I2P_ERROR MESSAGE="tunnel build failed"` {
+		t.Errorf("scripted reply = %q", got)
+	}
+	// the script is used up, the next attempt goes through
+	if got := roundTrip(t, conn, r, "SESSION CREATE STYLE=STREAM ID=x DESTINATION=TRANSIENT"); !strings.HasPrefix(got, "SESSION STATUS RESULT=OK") {
+		t.Errorf("unscripted reply = %q", got)
+	}
+	if cmds := b.Commands(); len(cmds) != 3 {
+		t.Errorf("Commands() = %d entries, want 3", len(cmds))
+	}
+}
@@ -0,0 +1,69 @@
+package samtest
+
+import (
+	"bytes"
+
+	"github.com/sirupsen/logrus"
+)
+
+func (b *Bridge) serveUDP() {
+	defer b.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := b.udp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		b.forwardDatagram(append([]byte(nil), buf[:n]...))
+	}
+}
+
+// forwardDatagram delivers one "3.x ID DESTINATION [options]\npayload"
+// datagram to every matching session of the target destination.
+func (b *Bridge) forwardDatagram(msg []byte) {
+	i := bytes.IndexByte(msg, '\n')
+	if i < 0 {
+		log.Debug("Fake bridge dropped datagram without header")
+		return
+	}
+	toks := tokenize(string(msg[:i]))
+	payload := msg[i+1:]
+	if len(toks) < 3 {
+		log.Debug("Fake bridge dropped datagram with short header")
+		return
+	}
+	opts := parseRequest("DATAGRAM SEND " + string(msg[:i]))
+	src := b.lookupSession(toks[1])
+	if src == nil {
+		log.WithField("id", toks[1]).Debug("Fake bridge dropped datagram from unknown session")
+		return
+	}
+	dest, ok := b.resolve(toks[2])
+	if !ok {
+		log.WithField("dest", toks[2]).Debug("Fake bridge dropped datagram to unknown destination")
+		return
+	}
+	fromPort := opts.Get("FROM_PORT", src.fromPort)
+	toPort := opts.Get("TO_PORT", src.toPort)
+	protocol := opts.Get("PROTOCOL", src.protocol)
+	for _, dst := range b.datagramTargets(dest, src.style, toPort, protocol) {
+		var out []byte
+		switch {
+		case dst.style == "DATAGRAM":
+			out = append([]byte(src.pub+" FROM_PORT="+fromPort+" TO_PORT="+toPort+"\n"), payload...)
+		case dst.header:
+			out = append([]byte("FROM_PORT="+fromPort+" TO_PORT="+toPort+" PROTOCOL="+protocol+"\n"), payload...)
+		default:
+			out = payload
+		}
+		if _, err := b.udp.WriteToUDP(out, dst.udp); err != nil {
+			log.WithError(err).Debug("Fake bridge failed to forward datagram")
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"from": src.id,
+			"to":   dst.id,
+			"size": len(payload),
+		}).Debug("Fake bridge forwarded datagram")
+	}
+}
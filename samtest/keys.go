@@ -0,0 +1,134 @@
+package samtest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	i2pB64enc = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~")
+	i2pB32enc = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+)
+
+const (
+	// public encryption key + signing key, before the certificate
+	destKeysLen = 384
+	certKey     = 5
+	// ElGamal private key + DSA signing private key appended to the destination
+	privSuffixLen = 256 + 20
+)
+
+// NewDestination returns a random destination in the same wire format a real
+// router hands out from DEST GENERATE: the base64 public destination and the
+// base64 private key blob which starts with it.
+func NewDestination() (pub, priv string) {
+	return newDestination(sigDSA)
+}
+
+// sigTypes are the signature type codes DEST GENERATE and SESSION CREATE
+// accept by name in SIGNATURE_TYPE.
+var sigTypes = map[string]int{
+	"DSA_SHA1":               sigDSA,
+	"ECDSA_SHA256_P256":      1,
+	"ECDSA_SHA384_P384":      2,
+	"ECDSA_SHA512_P521":      3,
+	"RSA_SHA256_2048":        4,
+	"RSA_SHA384_3072":        5,
+	"RSA_SHA512_4096":        6,
+	"EdDSA_SHA512_Ed25519":   7,
+	"EdDSA_SHA512_Ed25519ph": 8,
+	"RedDSA_SHA512_Ed25519":  11,
+}
+
+const (
+	sigDSA = 0
+	// crypto type in the key certificates handed out, ElGamal
+	encElGamal = 0
+)
+
+// parseSigType reads SIGNATURE_TYPE, a name or a code, defaulting to
+// DSA_SHA1 like a router.
+func parseSigType(s string) (int, bool) {
+	if s == "" {
+		return sigDSA, true
+	}
+	if code, err := strconv.Atoi(s); err == nil {
+		for _, c := range sigTypes {
+			if c == code {
+				return code, true
+			}
+		}
+		return 0, false
+	}
+	for name, code := range sigTypes {
+		if strings.EqualFold(name, s) {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+// newDestination returns a random destination of the signature type code,
+// with a NULL certificate for DSA_SHA1 and a key certificate otherwise.
+func newDestination(sigType int) (pub, priv string) {
+	dest := make([]byte, destKeysLen+3) // NULL certificate
+	if _, err := rand.Read(dest[:destKeysLen]); err != nil {
+		panic(err)
+	}
+	if sigType != sigDSA {
+		dest[destKeysLen] = certKey
+		binary.BigEndian.PutUint16(dest[destKeysLen+1:], 4)
+		dest = binary.BigEndian.AppendUint16(dest, uint16(sigType))
+		dest = binary.BigEndian.AppendUint16(dest, encElGamal)
+	}
+	suffix := make([]byte, privSuffixLen)
+	if _, err := rand.Read(suffix); err != nil {
+		panic(err)
+	}
+	pub = i2pB64enc.EncodeToString(dest)
+	priv = i2pB64enc.EncodeToString(append(dest, suffix...))
+	log.WithField("pub", pub[:16]).Debug("Generated fake destination")
+	return pub, priv
+}
+
+// publicFromPrivate extracts the public destination from a private key blob
+// by reading the certificate length that follows the 384 key bytes.
+func publicFromPrivate(priv string) (string, error) {
+	raw, err := i2pB64enc.DecodeString(priv)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < destKeysLen+3 {
+		return "", errors.New("private key too short")
+	}
+	certLen := int(binary.BigEndian.Uint16(raw[destKeysLen+1 : destKeysLen+3]))
+	end := destKeysLen + 3 + certLen
+	if len(raw) < end {
+		return "", errors.New("truncated certificate")
+	}
+	return i2pB64enc.EncodeToString(raw[:end]), nil
+}
+
+// B32 returns the .b32.i2p address of a base64 destination.
+func B32(pub string) string {
+	raw, err := i2pB64enc.DecodeString(pub)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(raw)
+	return i2pB32enc.EncodeToString(sum[:]) + ".b32.i2p"
+}
+
+func isDestination(s string) bool {
+	if len(s) < 516 || strings.HasSuffix(s, ".i2p") {
+		return false
+	}
+	_, err := i2pB64enc.DecodeString(s)
+	return err == nil
+}
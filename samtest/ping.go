@@ -0,0 +1,47 @@
+package samtest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IgnorePings stops the Bridge from answering PING with PONG, simulating a
+// control connection that has silently died somewhere along the path.
+func (b *Bridge) IgnorePings(ignore bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ignorePings = ignore
+}
+
+// Ping sends a bridge-initiated PING on the control connection of the
+// session id. The client's PONG shows up in Commands.
+func (b *Bridge) Ping(id string) error {
+	s := b.lookupSession(id)
+	if s == nil {
+		return fmt.Errorf("no session %q", id)
+	}
+	return s.control.reply("PING " + strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+// Disconnect closes the control connection of the session id, as a router
+// restart or a dropped NAT mapping would.
+func (b *Bridge) Disconnect(id string) error {
+	s := b.lookupSession(id)
+	if s == nil {
+		return fmt.Errorf("no session %q", id)
+	}
+	return s.control.conn.Close()
+}
+
+func (b *Bridge) ping(c *client, req *Request) bool {
+	b.mu.Lock()
+	ignore := b.ignorePings
+	b.mu.Unlock()
+	if ignore {
+		log.Debug("Fake bridge ignored PING")
+		return true
+	}
+	return c.reply("PONG"+strings.TrimPrefix(req.Line, "PING")) == nil
+}
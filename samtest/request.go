@@ -0,0 +1,91 @@
+package samtest
+
+import (
+	"strings"
+)
+
+// Request is a single command line received by the Bridge, split into its
+// verb, action and KEY=VALUE arguments.
+type Request struct {
+	Verb   string
+	Action string
+	Args   map[string]string
+	Line   string
+}
+
+// Command returns the "VERB ACTION" pair used to match scripts and handlers.
+func (r *Request) Command() string {
+	if r.Action == "" {
+		return r.Verb
+	}
+	return r.Verb + " " + r.Action
+}
+
+// Get returns the value of the argument key, or def if it is absent or empty.
+func (r *Request) Get(key, def string) string {
+	if v, ok := r.Args[key]; ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// parseRequest tokenizes a command line. Values may be double-quoted, in
+// which case they can contain spaces and backslash-escaped quotes.
+func parseRequest(line string) *Request {
+	req := &Request{
+		Args: map[string]string{},
+		Line: line,
+	}
+	for i, tok := range tokenize(line) {
+		switch {
+		case i == 0:
+			req.Verb = tok
+		case i == 1 && !strings.Contains(tok, "="):
+			req.Action = tok
+		default:
+			k, v, _ := strings.Cut(tok, "=")
+			req.Args[k] = unquote(v)
+		}
+	}
+	return req
+}
+
+func tokenize(line string) []string {
+	var (
+		toks   []string
+		cur    strings.Builder
+		quoted bool
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && quoted && i+1 < len(line):
+			cur.WriteByte(c)
+			i++
+			cur.WriteByte(line[i])
+		case c == '"':
+			quoted = !quoted
+			cur.WriteByte(c)
+		case (c == ' ' || c == '\t') && !quoted:
+			if cur.Len() > 0 {
+				toks = append(toks, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if cur.Len() > 0 {
+		toks = append(toks, cur.String())
+	}
+	return toks
+}
+
+func unquote(v string) string {
+	if len(v) < 2 || v[0] != '"' || v[len(v)-1] != '"' {
+		return v
+	}
+	v = v[1 : len(v)-1]
+	v = strings.ReplaceAll(v, `\"`, `"`)
+	return strings.ReplaceAll(v, `\\`, `\`)
+}
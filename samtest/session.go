@@ -0,0 +1,222 @@
+package samtest
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// session is a SESSION CREATE or SESSION ADD registered with the Bridge.
+type session struct {
+	id         string
+	style      string
+	pub        string
+	priv       string
+	control    *client
+	parent     *session
+	fromPort   string
+	toPort     string
+	listenPort string
+	protocol   string
+	header     bool
+	udp        *net.UDPAddr
+	accepts    chan *client
+	// forward is the STREAM FORWARD of the session, guarded by Bridge.mu
+	forward *forward
+}
+
+func (s *session) isPrimary() bool {
+	return s.style == "PRIMARY" || s.style == "MASTER"
+}
+
+func newSession(req *Request, c *client) *session {
+	s := &session{
+		id:       req.Get("ID", ""),
+		style:    req.Get("STYLE", ""),
+		control:  c,
+		fromPort: req.Get("FROM_PORT", "0"),
+		toPort:   req.Get("TO_PORT", "0"),
+		protocol: req.Get("PROTOCOL", "18"),
+		header:   req.Get("HEADER", "false") == "true",
+		accepts:  make(chan *client, 64),
+	}
+	s.listenPort = req.Get("LISTEN_PORT", s.fromPort)
+	if port := req.Get("PORT", ""); port != "" {
+		host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+		p, _ := strconv.Atoi(port)
+		s.udp = &net.UDPAddr{IP: net.ParseIP(req.Get("HOST", host)), Port: p}
+	}
+	return s
+}
+
+func (b *Bridge) sessionCreate(c *client, req *Request) bool {
+	if c.session != nil {
+		return c.reply(`SESSION STATUS RESULT=I2P_ERROR MESSAGE="Session already created"`) == nil
+	}
+	s := newSession(req, c)
+	switch s.style {
+	case "STREAM", "DATAGRAM", "RAW", "PRIMARY", "MASTER":
+	default:
+		return c.reply(`SESSION STATUS RESULT=I2P_ERROR MESSAGE="Invalid STYLE"`) == nil
+	}
+	if s.id == "" {
+		return c.reply(`SESSION STATUS RESULT=I2P_ERROR MESSAGE="Missing ID"`) == nil
+	}
+	switch dest := req.Get("DESTINATION", ""); dest {
+	case "":
+		return c.reply(`SESSION STATUS RESULT=I2P_ERROR MESSAGE="Missing DESTINATION"`) == nil
+	case "TRANSIENT":
+		sigType, ok := parseSigType(req.Get("SIGNATURE_TYPE", ""))
+		if !ok {
+			return c.reply(`SESSION STATUS RESULT=I2P_ERROR MESSAGE="Invalid SIGNATURE_TYPE"`) == nil
+		}
+		s.pub, s.priv = newDestination(sigType)
+	default:
+		pub, err := publicFromPrivate(dest)
+		if err != nil {
+			return c.reply("SESSION STATUS RESULT=INVALID_KEY") == nil
+		}
+		s.pub, s.priv = pub, dest
+	}
+	if (s.style == "DATAGRAM" || s.style == "RAW") && s.udp == nil {
+		return c.reply(`SESSION STATUS RESULT=I2P_ERROR MESSAGE="Missing PORT"`) == nil
+	}
+
+	b.mu.Lock()
+	if _, ok := b.sessions[s.id]; ok {
+		b.mu.Unlock()
+		return c.reply("SESSION STATUS RESULT=DUPLICATED_ID") == nil
+	}
+	for _, other := range b.sessions {
+		if other.parent == nil && other.pub == s.pub {
+			b.mu.Unlock()
+			return c.reply("SESSION STATUS RESULT=DUPLICATED_DEST") == nil
+		}
+	}
+	b.sessions[s.id] = s
+	b.mu.Unlock()
+	c.session = s
+	log.WithFields(logrus.Fields{"id": s.id, "style": s.style}).Debug("Fake bridge created session")
+	return c.reply("SESSION STATUS RESULT=OK DESTINATION="+s.priv) == nil
+}
+
+func (b *Bridge) sessionAdd(c *client, req *Request) bool {
+	if c.session == nil || !c.session.isPrimary() {
+		return c.reply(`SESSION STATUS RESULT=I2P_ERROR MESSAGE="Not a PRIMARY session"`) == nil
+	}
+	s := newSession(req, c)
+	s.parent = c.session
+	s.pub, s.priv = c.session.pub, c.session.priv
+	switch s.style {
+	case "STREAM", "DATAGRAM", "RAW":
+	default:
+		return c.reply(`SESSION STATUS RESULT=I2P_ERROR MESSAGE="Invalid STYLE"`) == nil
+	}
+	if s.id == "" {
+		return c.reply(`SESSION STATUS RESULT=I2P_ERROR MESSAGE="Missing ID"`) == nil
+	}
+	if (s.style == "DATAGRAM" || s.style == "RAW") && s.udp == nil {
+		return c.reply(`SESSION STATUS RESULT=I2P_ERROR MESSAGE="Missing PORT"`) == nil
+	}
+
+	b.mu.Lock()
+	if _, ok := b.sessions[s.id]; ok {
+		b.mu.Unlock()
+		return c.reply("SESSION STATUS RESULT=DUPLICATED_ID") == nil
+	}
+	b.sessions[s.id] = s
+	b.mu.Unlock()
+	log.WithFields(logrus.Fields{"id": s.id, "style": s.style}).Debug("Fake bridge added sub-session")
+	return c.reply("SESSION STATUS RESULT=OK ID="+s.id+` MESSAGE="ADD `+s.id+`"`) == nil
+}
+
+func (b *Bridge) sessionRemove(c *client, req *Request) bool {
+	id := req.Get("ID", "")
+	b.mu.Lock()
+	s, ok := b.sessions[id]
+	if !ok || s.parent == nil || s.parent != c.session {
+		b.mu.Unlock()
+		return c.reply("SESSION STATUS RESULT=INVALID_ID ID="+id) == nil
+	}
+	delete(b.sessions, id)
+	b.mu.Unlock()
+	closeAccepts(s)
+	return c.reply("SESSION STATUS RESULT=OK ID="+id+` MESSAGE="REMOVE `+id+`"`) == nil
+}
+
+// dropSession forgets a session and every sub-session hanging off it once its
+// control connection is gone, as a router would.
+func (b *Bridge) dropSession(s *session) {
+	b.mu.Lock()
+	var dropped []*session
+	for id, other := range b.sessions {
+		if other == s || other.parent == s {
+			delete(b.sessions, id)
+			dropped = append(dropped, other)
+		}
+	}
+	b.mu.Unlock()
+	for _, d := range dropped {
+		closeAccepts(d)
+	}
+	log.WithField("id", s.id).Debug("Fake bridge dropped session")
+}
+
+func closeAccepts(s *session) {
+	for {
+		select {
+		case a := <-s.accepts:
+			a.conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// streamTarget returns the STREAM session for dest that accepts connections
+// on toPort, preferring an exact LISTEN_PORT match over a catch-all.
+func (b *Bridge) streamTarget(dest, toPort string) *session {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var fallback *session
+	for _, s := range b.sessions {
+		if s.pub != dest || s.style != "STREAM" {
+			continue
+		}
+		if s.listenPort == toPort && toPort != "0" {
+			return s
+		}
+		if s.listenPort == "0" || s.listenPort == "" {
+			fallback = s
+		}
+	}
+	return fallback
+}
+
+// datagramTargets returns the sessions for dest that should receive a
+// datagram of the given style.
+func (b *Bridge) datagramTargets(dest, style, toPort, protocol string) []*session {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var targets []*session
+	for _, s := range b.sessions {
+		if s.pub != dest || s.style != style || s.udp == nil {
+			continue
+		}
+		if style == "RAW" && s.protocol != protocol {
+			continue
+		}
+		if s.listenPort != "0" && s.listenPort != "" && toPort != "0" && s.listenPort != toPort {
+			continue
+		}
+		targets = append(targets, s)
+	}
+	return targets
+}
+
+func (b *Bridge) lookupSession(id string) *session {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sessions[id]
+}
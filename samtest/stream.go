@@ -0,0 +1,188 @@
+package samtest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func (b *Bridge) streamAccept(c *client, req *Request) bool {
+	id := req.Get("ID", "")
+	s := b.lookupSession(id)
+	if s == nil || s.style != "STREAM" {
+		return c.reply("STREAM STATUS RESULT=INVALID_ID") == nil
+	}
+	if err := c.reply("STREAM STATUS RESULT=OK"); err != nil {
+		return false
+	}
+	// the socket now belongs to the stream, the control loop must let go
+	c.detached = true
+	c.silent = req.Get("SILENT", "false") == "true"
+	s.accepts <- c
+	log.WithField("id", id).Debug("Fake bridge queued STREAM ACCEPT")
+	return true
+}
+
+func (b *Bridge) streamConnect(c *client, req *Request) bool {
+	s := b.lookupSession(req.Get("ID", ""))
+	if s == nil || s.style != "STREAM" {
+		return c.reply("STREAM STATUS RESULT=INVALID_ID") == nil
+	}
+	dest, ok := b.resolve(req.Get("DESTINATION", ""))
+	if !ok {
+		return c.reply("STREAM STATUS RESULT=INVALID_KEY") == nil
+	}
+	fromPort := req.Get("FROM_PORT", s.fromPort)
+	toPort := req.Get("TO_PORT", s.toPort)
+	target := b.streamTarget(dest, toPort)
+	if target == nil {
+		return c.reply(`STREAM STATUS RESULT=CANT_REACH_PEER MESSAGE="Unknown destination"`) == nil
+	}
+	b.mu.Lock()
+	fwd := target.forward
+	b.mu.Unlock()
+	if fwd != nil {
+		return b.connectForward(c, s, fwd, fromPort, toPort)
+	}
+
+	b.mu.Lock()
+	timeout := b.connectTimeout
+	b.mu.Unlock()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		var acceptor *client
+		select {
+		case acceptor = <-target.accepts:
+		case <-timer.C:
+			return c.reply("STREAM STATUS RESULT=TIMEOUT") == nil
+		case <-b.done:
+			return false
+		}
+		if !acceptor.silent {
+			if _, err := io.WriteString(acceptor.conn, s.pub+" FROM_PORT="+fromPort+" TO_PORT="+toPort+"\n"); err != nil {
+				// that accept went away, wait for another one
+				acceptor.conn.Close()
+				b.untrack(acceptor.conn)
+				continue
+			}
+		}
+		if err := c.reply("STREAM STATUS RESULT=OK"); err != nil {
+			acceptor.conn.Close()
+			b.untrack(acceptor.conn)
+			return false
+		}
+		log.WithFields(logrus.Fields{
+			"from": s.id,
+			"to":   target.id,
+		}).Debug("Fake bridge connected stream")
+		c.detached = true
+		b.wg.Add(1)
+		go b.pipe(c, acceptor)
+		return true
+	}
+}
+
+// forward is where a session forwards the streams it accepts to.
+type forward struct {
+	addr    string
+	silent  bool
+	control *client
+}
+
+func (b *Bridge) streamForward(c *client, req *Request) bool {
+	id := req.Get("ID", "")
+	s := b.lookupSession(id)
+	if s == nil || s.style != "STREAM" {
+		return c.reply("STREAM STATUS RESULT=INVALID_ID") == nil
+	}
+	port := req.Get("PORT", "")
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return c.reply(`STREAM STATUS RESULT=I2P_ERROR MESSAGE="Invalid PORT"`) == nil
+	}
+	if req.Get("SSL", "false") == "true" {
+		return c.reply(`STREAM STATUS RESULT=I2P_ERROR MESSAGE="SSL is not supported"`) == nil
+	}
+	host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+	host = req.Get("HOST", host)
+	b.mu.Lock()
+	if s.forward != nil {
+		b.mu.Unlock()
+		return c.reply(`STREAM STATUS RESULT=I2P_ERROR MESSAGE="Already forwarding"`) == nil
+	}
+	s.forward = &forward{
+		addr:    net.JoinHostPort(host, port),
+		silent:  req.Get("SILENT", "false") == "true",
+		control: c,
+	}
+	b.mu.Unlock()
+	c.forwarding = s
+	log.WithFields(logrus.Fields{"id": id, "addr": s.forward.addr}).Debug("Fake bridge forwarding streams")
+	return c.reply("STREAM STATUS RESULT=OK") == nil
+}
+
+// stopForward ends the STREAM FORWARD of c once its connection is gone.
+func (b *Bridge) stopForward(c *client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s := c.forwarding; s.forward != nil && s.forward.control == c {
+		s.forward = nil
+	}
+}
+
+// connectForward connects the stream of c to the forwarding target of a
+// session, sending the destination line first unless the forward is silent.
+func (b *Bridge) connectForward(c *client, s *session, fwd *forward, fromPort, toPort string) bool {
+	b.mu.Lock()
+	timeout := b.connectTimeout
+	b.mu.Unlock()
+	conn, err := net.DialTimeout("tcp", fwd.addr, timeout)
+	if err != nil {
+		return c.reply(`STREAM STATUS RESULT=CANT_REACH_PEER MESSAGE="Forward target unreachable"`) == nil
+	}
+	if !b.track(conn) {
+		conn.Close()
+		return false
+	}
+	target := &client{conn: conn, r: bufio.NewReader(conn)}
+	if !fwd.silent {
+		if _, err := io.WriteString(conn, s.pub+" FROM_PORT="+fromPort+" TO_PORT="+toPort+"\n"); err != nil {
+			conn.Close()
+			b.untrack(conn)
+			return c.reply(`STREAM STATUS RESULT=CANT_REACH_PEER MESSAGE="Forward target unreachable"`) == nil
+		}
+	}
+	if err := c.reply("STREAM STATUS RESULT=OK"); err != nil {
+		conn.Close()
+		b.untrack(conn)
+		return false
+	}
+	log.WithFields(logrus.Fields{"from": s.id, "to": fwd.addr}).Debug("Fake bridge forwarded stream")
+	c.detached = true
+	b.wg.Add(1)
+	go b.pipe(c, target)
+	return true
+}
+
+// pipe copies bytes between two detached stream sockets until either side
+// closes, then closes both.
+func (b *Bridge) pipe(a, z *client) {
+	defer b.wg.Done()
+	done := make(chan struct{}, 2)
+	copyHalf := func(dst net.Conn, src io.Reader) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go copyHalf(a.conn, z.r)
+	go copyHalf(z.conn, a.r)
+	<-done
+	a.conn.Close()
+	z.conn.Close()
+	<-done
+	b.untrack(a.conn)
+	b.untrack(z.conn)
+}
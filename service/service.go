@@ -0,0 +1,280 @@
+// Package service keeps an I2P service at the same address across restarts,
+// like Tor's HiddenServiceDir: a directory holds its keys and publishes its
+// address, and sessions are created with a stable ID.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/go-sam-go/datagram"
+	"github.com/go-i2p/go-sam-go/primary"
+	"github.com/go-i2p/go-sam-go/stream"
+	"github.com/go-i2p/i2pkeys"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// HostnameFile holds the .b32.i2p address of the service
+	HostnameFile = "hostname.b32"
+	// DestinationFile holds the full base64 destination of the service, as
+	// needed to register it in an address book
+	DestinationFile = "destination.b64"
+	// KeysDir is the subdirectory of the service directory holding the
+	// keys unless WithKeyStore is used, apart from the address files
+	KeysDir = "keys"
+	// KeysFile is the name of the keys in the service's KeyStore, the file
+	// in KeysDir holding them unless WithKeyStore is used
+	KeysFile = "destination.keys"
+)
+
+// Service is an I2P destination whose keys live in a directory. The
+// directory is created if needed, accessible by the owner only:
+//
+//	keys/destination.keys  private keys, encrypted with WithPassphrase
+//	hostname.b32           the .b32.i2p address
+//	destination.b64        the base64 destination
+//
+// Each session method opens its own connection to the SAM bridge, configured
+// like the SAM passed to New. The router allows one session per
+// destination, so only one session of a Service may be open at a time; a
+// PrimarySession carries stream and datagram sub-sessions together.
+type Service struct {
+	sam     *common.SAM
+	dir     string
+	store   common.KeyStore
+	sigType common.SigType
+	id      string
+	keys    i2pkeys.I2PKeys
+}
+
+// Option configures a Service.
+type Option func(*Service) error
+
+// WithKeyStore keeps the keys in store instead of the service directory.
+func WithKeyStore(store common.KeyStore) Option {
+	return func(s *Service) error {
+		s.store = store
+		return nil
+	}
+}
+
+// WithPassphrase encrypts the keys in the service directory with a key
+// derived from passphrase, see common.EncryptedKeyStore.
+func WithPassphrase(passphrase []byte) Option {
+	return func(s *Service) error {
+		store, err := common.NewEncryptedKeyStore(s.keysDir(), passphrase)
+		if err != nil {
+			return err
+		}
+		s.store = store
+		return nil
+	}
+}
+
+// WithSigType sets the signature type of keys generated for a new service,
+// EdDSA_SHA512_Ed25519 by default. Existing keys are kept whatever their
+// type.
+func WithSigType(t common.SigType) Option {
+	return func(s *Service) error {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+		s.sigType = t
+		return nil
+	}
+}
+
+// WithID sets the session ID instead of the one derived from the address.
+func WithID(id string) Option {
+	return func(s *Service) error {
+		if id == "" || strings.ContainsAny(id, " \t\r\n") {
+			return fmt.Errorf("invalid session ID %q", id)
+		}
+		s.id = id
+		return nil
+	}
+}
+
+// New loads the service kept in dir, or creates it with new keys generated
+// through sam. The address files are written again every time, so they
+// always match the keys.
+func New(sam *common.SAM, dir string, opts ...Option) (*Service, error) {
+	log.WithField("dir", dir).Debug("Opening service")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.WithError(err).Error("Failed to create service directory")
+		return nil, err
+	}
+	s := &Service{
+		sam:     sam,
+		dir:     dir,
+		sigType: common.SIG_EdDSA_SHA512_Ed25519,
+	}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.store == nil {
+		store, err := common.NewDirKeyStore(s.keysDir())
+		if err != nil {
+			return nil, err
+		}
+		s.store = store
+	}
+	if err := s.loadKeys(); err != nil {
+		return nil, err
+	}
+	if s.id == "" {
+		s.id = DefaultID(s.keys.Addr())
+	}
+	if err := s.publish(); err != nil {
+		return nil, err
+	}
+	log.WithFields(logrus.Fields{"id": s.id, "hostname": s.Hostname()}).Debug("Service ready")
+	return s, nil
+}
+
+// DefaultID is the session ID of a service without WithID. It is derived
+// from the address, so it is stable and differs between services.
+func DefaultID(addr i2pkeys.I2PAddr) string {
+	return "svc-" + addr.Base32()[:16]
+}
+
+// keysDir is the directory of the default key stores.
+func (s *Service) keysDir() string {
+	return filepath.Join(s.dir, KeysDir)
+}
+
+func (s *Service) loadKeys() error {
+	keys, err := s.store.Load(KeysFile)
+	if err == nil {
+		s.keys = keys
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		log.WithError(err).Error("Failed to load service keys")
+		return err
+	}
+	log.WithField("sigType", s.sigType).Debug("Generating keys for new service")
+	if keys, err = s.sam.NewKeys(s.sigType); err != nil {
+		return err
+	}
+	if err := s.store.Save(KeysFile, keys); err != nil {
+		log.WithError(err).Error("Failed to save service keys")
+		return err
+	}
+	s.keys = keys
+	return nil
+}
+
+// publish writes the address files.
+func (s *Service) publish() error {
+	files := map[string]string{
+		HostnameFile:    s.Hostname(),
+		DestinationFile: s.keys.Addr().Base64(),
+	}
+	for name, content := range files {
+		if err := common.WriteFileAtomic(filepath.Join(s.dir, name), []byte(content+"\n"), 0o644); err != nil {
+			log.WithError(err).WithField("file", name).Error("Failed to write service address")
+			return err
+		}
+	}
+	return nil
+}
+
+// Dir returns the service directory.
+func (s *Service) Dir() string {
+	return s.dir
+}
+
+// ID returns the session ID used for every session of the service.
+func (s *Service) ID() string {
+	return s.id
+}
+
+// Keys returns the keys of the service.
+func (s *Service) Keys() i2pkeys.I2PKeys {
+	return s.keys
+}
+
+// Addr returns the destination of the service.
+func (s *Service) Addr() i2pkeys.I2PAddr {
+	return s.keys.Addr()
+}
+
+// Hostname returns the .b32.i2p address of the service.
+func (s *Service) Hostname() string {
+	return s.keys.Addr().Base32()
+}
+
+// connect opens a new control connection for a session of the service.
+func (s *Service) connect() (*common.SAM, error) {
+	ctx, cancel := s.sam.ContextWithTimeout()
+	defer cancel()
+	return s.sam.ReconnectContext(ctx)
+}
+
+// StreamSession creates a stream session for the service.
+func (s *Service) StreamSession(options []string) (*stream.StreamSession, error) {
+	sam, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	session, err := (&stream.SAM{SAM: sam}).NewStreamSession(s.id, s.keys, options)
+	if err != nil {
+		sam.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// Listen creates a stream session for the service and listens on it.
+// Closing the listener closes the session.
+func (s *Service) Listen(options []string) (*stream.StreamListener, error) {
+	session, err := s.StreamSession(options)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := session.Listen()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// DatagramSession creates a datagram session for the service, see
+// datagram.SAM.NewDatagramSession for udpPort.
+func (s *Service) DatagramSession(options []string, udpPort int) (*datagram.DatagramSession, error) {
+	sam, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	session, err := (*datagram.SAM)(sam).NewDatagramSession(s.id, s.keys, options, udpPort)
+	if err != nil {
+		sam.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// PrimarySession creates a primary session for the service, to run stream
+// and datagram sub-sessions on the same address.
+func (s *Service) PrimarySession(options []string) (*primary.PrimarySession, error) {
+	sam, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	session, err := (*primary.SAM)(sam).NewPrimarySession(s.id, s.keys, options)
+	if err != nil {
+		sam.Close()
+		return nil, err
+	}
+	return session, nil
+}
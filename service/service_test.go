@@ -0,0 +1,157 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/go-sam-go/samtest"
+	"github.com/go-i2p/go-sam-go/stream"
+)
+
+func newTestSAM(t *testing.T, b *samtest.Bridge) *common.SAM {
+	t.Helper()
+	sam, err := common.NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	t.Cleanup(func() { sam.Close() })
+	return sam
+}
+
+func TestNew_StableIdentity(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	dir := filepath.Join(t.TempDir(), "web")
+
+	first, err := New(newTestSAM(t, b), dir)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if sig, _, err := common.DestinationTypes(first.Addr()); err != nil || sig != common.SIG_EdDSA_SHA512_Ed25519 {
+		t.Errorf("new service keys are %s, %v, want EdDSA_SHA512_Ed25519", sig, err)
+	}
+	files := map[string]string{
+		HostnameFile:    first.Hostname() + "\n",
+		DestinationFile: first.Addr().Base64() + "\n",
+	}
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error = %v", name, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if fi, err := os.Stat(dir); err != nil || fi.Mode().Perm() != 0o700 {
+		t.Errorf("service directory mode = %v, %v, want 0700", fi.Mode().Perm(), err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, KeysDir, KeysFile)); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("keys file mode = %v, %v, want 0600", fi.Mode().Perm(), err)
+	}
+	// the address files are not in the key store
+	store, err := common.NewDirKeyStore(filepath.Join(dir, KeysDir))
+	if err != nil {
+		t.Fatalf("NewDirKeyStore() error = %v", err)
+	}
+	if names, err := store.List(); err != nil || len(names) != 1 || names[0] != KeysFile {
+		t.Errorf("key store List() = %v, %v, want [%s]", names, err, KeysFile)
+	}
+
+	// a stale hostname file is corrected, the keys decide
+	os.WriteFile(filepath.Join(dir, HostnameFile), []byte("stale.b32.i2p\n"), 0o644)
+	again, err := New(newTestSAM(t, b), dir)
+	if err != nil {
+		t.Fatalf("second New() error = %v", err)
+	}
+	if again.Addr() != first.Addr() || again.ID() != first.ID() {
+		t.Errorf("reopened service is %s/%s, want %s/%s", again.Hostname(), again.ID(), first.Hostname(), first.ID())
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, HostnameFile)); string(got) != first.Hostname()+"\n" {
+		t.Errorf("%s = %q after reopening, want the service address", HostnameFile, got)
+	}
+	if !strings.HasPrefix(first.ID(), "svc-") || strings.ContainsAny(first.ID(), " =") {
+		t.Errorf("ID() = %q, want a stable svc- ID", first.ID())
+	}
+}
+
+func TestService_Listen(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	svc, err := New(newTestSAM(t, b), t.TempDir(), WithID("web"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	listener, err := svc.Listen(nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+	if listener.Addr() != svc.Addr() {
+		t.Errorf("listener address = %s, want %s", listener.Addr(), svc.Hostname())
+	}
+
+	clientSam := newTestSAM(t, b)
+	clientKeys, err := clientSam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	client, err := (&stream.SAM{SAM: clientSam}).NewStreamSession("client", clientKeys, nil)
+	if err != nil {
+		t.Fatalf("NewStreamSession() error = %v", err)
+	}
+	go func() {
+		conn, err := client.DialI2P(svc.Addr())
+		if err != nil {
+			t.Errorf("DialI2P() error = %v", err)
+			listener.Close()
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("hi"))
+	}()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hi" {
+		t.Errorf("read %q, %v, want \"hi\"", buf, err)
+	}
+}
+
+func TestWithPassphrase(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	dir := t.TempDir()
+	svc, err := New(newTestSAM(t, b), dir, WithPassphrase([]byte("correct horse")))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, KeysDir, KeysFile))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if bytes.Contains(data, []byte(svc.Keys().String()[:64])) {
+		t.Error("keys file holds the private keys in plaintext")
+	}
+	if _, err := New(newTestSAM(t, b), dir, WithPassphrase([]byte("battery staple"))); !errors.Is(err, common.ErrDecryptKeys) {
+		t.Errorf("New() with the wrong passphrase error = %v, want ErrDecryptKeys", err)
+	}
+}
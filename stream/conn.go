@@ -24,22 +24,36 @@ func (sc *StreamConn) Close() error {
 	return sc.conn.Close()
 }
 
+// LocalAddr returns the session's destination and the port the connection
+// was made from or accepted on, a common.I2PAddrPort.
 func (sc *StreamConn) LocalAddr() net.Addr {
-	return sc.localAddr()
+	return sc.laddr
 }
 
 // Implements net.Conn
 func (sc *StreamConn) localAddr() i2pkeys.I2PAddr {
-	return sc.laddr
+	return sc.laddr.Addr
 }
 
+// RemoteAddr returns the peer's destination and port, a
+// common.I2PAddrPort.
 func (sc *StreamConn) RemoteAddr() net.Addr {
-	return sc.remoteAddr()
+	return sc.raddr
 }
 
 // Implements net.Conn
 func (sc *StreamConn) remoteAddr() i2pkeys.I2PAddr {
-	return sc.raddr
+	return sc.raddr.Addr
+}
+
+// LocalPort returns the local virtual port of the connection.
+func (sc *StreamConn) LocalPort() int {
+	return sc.laddr.Port
+}
+
+// RemotePort returns the virtual port of the peer.
+func (sc *StreamConn) RemotePort() int {
+	return sc.raddr.Port
 }
 
 // Implements net.Conn
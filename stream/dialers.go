@@ -1,13 +1,11 @@
 package stream
 
 import (
-	"bufio"
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/go-i2p/go-sam-go/common"
@@ -15,124 +13,181 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// context-aware dialer, eventually...
+// DialContext implements the dialer signature of net.Dialer. addr is a
+// name.i2p or .b32.i2p address or a base64 destination, with or without a
+// port. The port, if the bridge supports ports, is the TO_PORT of the
+// connection, the session's TO_PORT is used otherwise.
+//
+// The session's Timeout and Deadline apply in addition to ctx. When one of
+// them ends the dial, the error wraps context.Canceled or
+// context.DeadlineExceeded.
 func (s *StreamSession) DialContext(ctx context.Context, n, addr string) (net.Conn, error) {
 	log.WithFields(logrus.Fields{"network": n, "addr": addr}).Debug("DialContext called")
-	return s.DialContextI2P(ctx, n, addr)
+	conn, err := s.DialContextI2P(ctx, n, addr)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
 }
 
-// context-aware dialer, eventually...
+// DialContextI2P is like DialContext, but returns a *StreamConn.
 func (s *StreamSession) DialContextI2P(ctx context.Context, n, addr string) (*StreamConn, error) {
 	log.WithFields(logrus.Fields{"network": n, "addr": addr}).Debug("DialContextI2P called")
 	if ctx == nil {
 		log.Panic("nil context")
 		panic("nil context")
 	}
+	ctx, cancel := s.dialContext(ctx)
+	defer cancel()
+	host, portStr, err := common.SplitHostPort(addr)
+	if err != nil {
+		return nil, dialError(addr, err)
+	}
+	if host == "" {
+		return nil, dialError(addr, errMissingHost)
+	}
+	port, err := common.ParsePort(portStr)
+	if err != nil {
+		return nil, dialError(addr, err)
+	}
+	from, to := s.ports()
+	if port != 0 {
+		if s.Supports(common.FeaturePorts) {
+			to = port
+		} else {
+			log.WithField("port", port).Debug("Bridge does not support ports, ignoring the port of the address")
+		}
+	}
+	i2paddr, err := s.LookupContext(ctx, host)
+	if err != nil {
+		log.WithError(err).WithField("addr", addr).Error("Failed to resolve dial address")
+		return nil, dialError(addr, err)
+	}
+	log.WithFields(logrus.Fields{"host": host, "i2paddr": i2paddr.Base32()}).Debug("Resolved dial address")
+	return s.dialI2P(ctx, addr, i2paddr, from, to)
+}
+
+// ports returns the session's FROM_PORT and TO_PORT.
+func (s *StreamSession) ports() (from, to int) {
+	from, _ = common.ParsePort(s.From())
+	to, _ = common.ParsePort(s.To())
+	return from, to
+}
+
+// errMissingHost fails a dial to an address without a host, which would
+// otherwise be looked up as an empty name.
+var errMissingHost = errors.New("missing host in address")
+
+// dialError wraps err with the target of a failed dial.
+func dialError(target string, err error) error {
+	return fmt.Errorf("dialing %s: %w", target, err)
+}
+
+// dialContext bounds ctx by the session's Timeout and Deadline.
+func (s *StreamSession) dialContext(ctx context.Context) (context.Context, context.CancelFunc) {
 	deadline := s.deadline(ctx, time.Now())
 	if !deadline.IsZero() {
 		if d, ok := ctx.Deadline(); !ok || deadline.Before(d) {
-			subCtx, cancel := context.WithDeadline(ctx, deadline)
-			defer cancel()
-			ctx = subCtx
+			return context.WithDeadline(ctx, deadline)
 		}
 	}
-
-	i2paddr, err := i2pkeys.NewI2PAddrFromString(addr)
-	if err != nil {
-		log.WithError(err).Error("Failed to create I2P address from string")
-		return nil, err
-	}
-	return s.DialI2P(i2paddr)
+	return context.WithCancel(ctx)
 }
 
 // implement net.Dialer
 func (s *StreamSession) Dial(n, addr string) (c net.Conn, err error) {
 	log.WithFields(logrus.Fields{"network": n, "addr": addr}).Debug("Dial called")
+	return s.DialContext(context.Background(), n, addr)
+}
 
-	var i2paddr i2pkeys.I2PAddr
-	var host string
-	host, _, err = net.SplitHostPort(addr)
-	// log.Println("Dialing:", host)
-	if err = common.IgnorePortError(err); err == nil {
-		// check for name
-		if strings.HasSuffix(host, ".b32.i2p") || strings.HasSuffix(host, ".i2p") {
-			// name lookup
-			i2paddr, err = s.Lookup(host)
-			log.WithFields(logrus.Fields{"host": host, "i2paddr": i2paddr}).Debug("Looked up I2P address")
-		} else {
-			// probably a destination
-			i2paddr, err = i2pkeys.NewI2PAddrFromBytes([]byte(host))
-			// i2paddr = i2pkeys.I2PAddr(host)
-			// log.Println("Destination:", i2paddr, err)
-			log.WithFields(logrus.Fields{"host": host, "i2paddr": i2paddr}).Debug("Created I2P address from bytes")
+// Dials to an I2P destination and returns a SAMConn, which implements a net.Conn.
+func (s *StreamSession) DialI2P(addr i2pkeys.I2PAddr) (*StreamConn, error) {
+	return s.DialI2PContext(context.Background(), addr)
+}
+
+// DialI2PContext is like DialI2P, but gives up when ctx is done. A
+// connection which is still being set up is closed.
+func (s *StreamSession) DialI2PContext(ctx context.Context, addr i2pkeys.I2PAddr) (*StreamConn, error) {
+	ctx, cancel := s.dialContext(ctx)
+	defer cancel()
+	from, to := s.ports()
+	return s.dialI2P(ctx, addr.Base32(), addr, from, to)
+}
+
+// DialI2PWithPorts is like DialI2P, but connects from port from to port to
+// of addr instead of the session's FROM_PORT and TO_PORT. Ports other than
+// 0 need a SAM 3.2 bridge.
+func (s *StreamSession) DialI2PWithPorts(addr i2pkeys.I2PAddr, from, to int) (*StreamConn, error) {
+	return s.DialI2PWithPortsContext(context.Background(), addr, from, to)
+}
+
+// DialI2PWithPortsContext is like DialI2PWithPorts, but gives up when ctx
+// is done.
+func (s *StreamSession) DialI2PWithPortsContext(ctx context.Context, addr i2pkeys.I2PAddr, from, to int) (*StreamConn, error) {
+	target := common.I2PAddrPort{Addr: addr, Port: to}.String()
+	for _, port := range []int{from, to} {
+		if _, err := common.ParsePort(strconv.Itoa(port)); err != nil {
+			return nil, dialError(target, err)
 		}
-		if err == nil {
-			return s.DialI2P(i2paddr)
+	}
+	if from != 0 || to != 0 {
+		if err := s.Require(common.FeaturePorts); err != nil {
+			return nil, dialError(target, err)
 		}
 	}
-	log.WithError(err).Error("Dial failed")
-	return
+	ctx, cancel := s.dialContext(ctx)
+	defer cancel()
+	return s.dialI2P(ctx, target, addr, from, to)
 }
 
-// Dials to an I2P destination and returns a SAMConn, which implements a net.Conn.
-func (s *StreamSession) DialI2P(addr i2pkeys.I2PAddr) (*StreamConn, error) {
-	log.WithField("addr", addr).Debug("DialI2P called")
-	sam, err := common.NewSAM(s.Sam())
+// dialI2P sends STREAM CONNECT for addr on a new connection to the bridge.
+// The connection follows ctx until the bridge answers: its deadline is
+// ctx's, and it is closed if ctx ends first. Errors are wrapped with
+// target.
+func (s *StreamSession) dialI2P(ctx context.Context, target string, addr i2pkeys.I2PAddr, from, to int) (*StreamConn, error) {
+	log.WithFields(logrus.Fields{"addr": target, "from": from, "to": to}).Debug("DialI2P called")
+	cmd := "STREAM CONNECT " + s.ID()
+	if from != 0 {
+		cmd += " FROM_PORT=" + strconv.Itoa(from)
+	}
+	if to != 0 {
+		cmd += " TO_PORT=" + strconv.Itoa(to)
+	}
+	cmd += " DESTINATION=" + addr.Base64() + " SILENT=false"
+	sam, err := s.RedialContext(ctx)
 	if err != nil {
 		log.WithError(err).Error("Failed to create new SAM instance")
-		return nil, err
+		return nil, dialError(target, err)
 	}
 	conn := sam.Conn
-	_, err = conn.Write([]byte("STREAM CONNECT ID=" + s.ID() + s.FromPort() + s.ToPort() + " DESTINATION=" + addr.Base64() + " SILENT=false\n"))
+	reply, err := sam.CommandContext(ctx, cmd)
 	if err != nil {
-		log.WithError(err).Error("Failed to write STREAM CONNECT command")
+		log.WithError(err).Error("Failed to send STREAM CONNECT command")
 		conn.Close()
-		return nil, err
+		return nil, dialError(target, err)
 	}
-	buf := make([]byte, 4096)
-	n, err := conn.Read(buf)
-	if err != nil && err != io.EOF {
-		log.WithError(err).Error("Failed to write STREAM CONNECT command")
+	if !reply.Is("STREAM", "STATUS") {
+		log.WithField("reply", reply.String()).Error("Unexpected reply to STREAM CONNECT")
 		conn.Close()
-		return nil, err
+		return nil, dialError(target, common.UnexpectedReply(reply))
 	}
-	scanner := bufio.NewScanner(bytes.NewReader(buf[:n]))
-	scanner.Split(bufio.ScanWords)
-	for scanner.Scan() {
-		switch scanner.Text() {
-		case "STREAM":
-			continue
-		case "STATUS":
-			continue
-		case ResultOK:
-			log.Debug("Successfully connected to I2P destination")
-			return &StreamConn{s.Addr(), addr, conn}, nil
-		case ResultCantReachPeer:
-			log.Error("Can't reach peer")
-			conn.Close()
-			return nil, fmt.Errorf("Can not reach peer")
-		case ResultI2PError:
-			log.Error("I2P internal error")
-			conn.Close()
-			return nil, fmt.Errorf("I2P internal error")
-		case ResultInvalidKey:
-			log.Error("Invalid key - Stream Session")
-			conn.Close()
-			return nil, fmt.Errorf("Invalid key - Stream Session")
-		case ResultInvalidID:
-			log.Error("Invalid tunnel ID")
-			conn.Close()
-			return nil, fmt.Errorf("Invalid tunnel ID")
-		case ResultTimeout:
-			log.Error("Connection timeout")
-			conn.Close()
-			return nil, fmt.Errorf("Timeout")
-		default:
-			log.WithField("error", scanner.Text()).Error("Unknown error")
-			conn.Close()
-			return nil, fmt.Errorf("Unknown error: %s : %s", scanner.Text(), string(buf[:n]))
-		}
+	if err := reply.Err(); err != nil {
+		log.WithFields(logrus.Fields{
+			"result":  reply.Result(),
+			"message": reply.Get("MESSAGE"),
+		}).Error("Failed to connect to I2P destination")
+		conn.Close()
+		return nil, dialError(target, err)
+	}
+	if err := ctx.Err(); err != nil {
+		// the bridge answered as ctx ended, the caller is gone
+		conn.Close()
+		return nil, dialError(target, err)
 	}
-	log.Panic("Unexpected end of StreamSession.DialI2P()")
-	panic("sam3 go library error in StreamSession.DialI2P()")
+	log.Debug("Successfully connected to I2P destination")
+	return &StreamConn{
+		laddr: common.I2PAddrPort{Addr: s.Addr(), Port: from},
+		raddr: common.I2PAddrPort{Addr: addr, Port: to},
+		conn:  sam.DataConn(),
+	}, nil
 }
@@ -0,0 +1,432 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/go-sam-go/samtest"
+	"github.com/go-i2p/i2pkeys"
+)
+
+func newTestSession(t *testing.T, b *samtest.Bridge, id string) *StreamSession {
+	t.Helper()
+	commonSam, err := common.NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	keys, err := commonSam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	sam := &SAM{SAM: commonSam}
+	session, err := sam.NewStreamSession(id, keys, nil)
+	if err != nil {
+		t.Fatalf("NewStreamSession() error = %v", err)
+	}
+	t.Cleanup(func() { session.Close() })
+	return session
+}
+
+func TestStreamSession_DialAccept(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	server := newTestSession(t, b, "server")
+	client := newTestSession(t, b, "client")
+	listener, err := server.Listen()
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	accepted := make(chan *StreamConn, 1)
+	go func() {
+		conn, err := listener.AcceptI2P()
+		if err != nil {
+			t.Errorf("AcceptI2P() error = %v", err)
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	conn, err := client.DialI2P(server.Addr())
+	if err != nil {
+		t.Fatalf("DialI2P() error = %v", err)
+	}
+	defer conn.Close()
+	sconn, ok := <-accepted
+	if !ok {
+		return
+	}
+	defer sconn.Close()
+
+	if got := sconn.RemoteAddr().String(); got != client.Addr().Base32() {
+		t.Errorf("accepted RemoteAddr() = %s, want %s", got, client.Addr().Base32())
+	}
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(sconn, buf); err != nil {
+		t.Fatalf("ReadFull() error = %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("accepted conn read %q, want %q", buf, "ping")
+	}
+}
+
+func TestStreamSession_DialAddresses(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	server := newTestSession(t, b, "server")
+	client := newTestSession(t, b, "client")
+	b.AddName("server.i2p", string(server.Addr()))
+	listener, err := server.Listen()
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		addr    string
+		wantErr error
+	}{
+		{"name", "server.i2p", nil},
+		{"name and port", "server.i2p:80", nil},
+		{"b32", server.Addr().Base32(), nil},
+		{"b32 and port", server.Addr().Base32() + ":80", nil},
+		{"base64", server.Addr().Base64(), nil},
+		{"base64 and port", server.Addr().Base64() + ":80", nil},
+		{"unknown name", "missing.i2p:80", common.ErrKeyNotFound},
+		{"port only", ":80", errMissingHost},
+		{"too many colons", "a:b:c", errMissingHost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := client.DialContext(context.Background(), "tcp", tt.addr)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || !strings.Contains(err.Error(), tt.addr) {
+					t.Errorf("DialContext() error = %v, want %v for %s", err, tt.wantErr, tt.addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DialContext() error = %v", err)
+			}
+			conn.Close()
+			if conn, err = client.Dial("tcp", tt.addr); err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			conn.Close()
+		})
+	}
+	for _, cmd := range b.Commands() {
+		if cmd == "NAMING LOOKUP NAME=" || strings.HasPrefix(cmd, "NAMING LOOKUP NAME= ") {
+			t.Errorf("bridge received a lookup of an empty name: %q", cmd)
+		}
+	}
+}
+
+func TestStreamSession_DialUnreachable(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	b.Script("STREAM CONNECT", "STREAM STATUS RESULT=CANT_REACH_PEER")
+
+	client := newTestSession(t, b, "client")
+	pub, _ := samtest.NewDestination()
+	_, err = client.DialI2P(i2pkeys.I2PAddr(pub))
+	if !errors.Is(err, common.ErrCantReachPeer) {
+		t.Errorf("DialI2P() error = %v, want ErrCantReachPeer", err)
+	}
+	if !common.IsTemporary(err) {
+		t.Errorf("IsTemporary(%v) = false, want true", err)
+	}
+}
+
+func TestStreamSession_Done(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	session := newTestSession(t, b, "watched")
+	b.Disconnect("watched")
+	select {
+	case <-session.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done() was not closed after the bridge dropped the session")
+	}
+	if !errors.Is(session.Err(), common.ErrConnectionLost) {
+		t.Errorf("Err() = %v, want ErrConnectionLost", session.Err())
+	}
+}
+
+func TestStreamSession_ContextCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(ctx context.Context, server, client *StreamSession) error
+	}{
+		{
+			name: "accept",
+			call: func(ctx context.Context, server, client *StreamSession) error {
+				listener, err := server.Listen()
+				if err != nil {
+					return err
+				}
+				_, err = listener.AcceptI2PContext(ctx)
+				return err
+			},
+		},
+		{
+			name: "dial",
+			call: func(ctx context.Context, server, client *StreamSession) error {
+				// nobody accepts on server, so the bridge holds the connect
+				_, err := client.DialI2PContext(ctx, server.Addr())
+				return err
+			},
+		},
+		{
+			name: "dial context",
+			call: func(ctx context.Context, server, client *StreamSession) error {
+				target := server.Addr().Base32() + ":80"
+				_, err := client.DialContext(ctx, "tcp", target)
+				if err != nil && !strings.Contains(err.Error(), target) {
+					t.Errorf("DialContext() error = %v, want it to name %s", err, target)
+				}
+				return err
+			},
+		},
+		{
+			name: "session deadline",
+			call: func(ctx context.Context, server, client *StreamSession) error {
+				client.Deadline = time.Now().Add(50 * time.Millisecond)
+				defer func() { client.Deadline = time.Time{} }()
+				_, err := client.DialContext(context.Background(), "tcp", server.Addr().Base32())
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := samtest.NewBridge()
+			if err != nil {
+				t.Fatalf("NewBridge() error = %v", err)
+			}
+			defer b.Close()
+			b.SetConnectTimeout(time.Minute)
+
+			server := newTestSession(t, b, "server")
+			client := newTestSession(t, b, "client")
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() { done <- tt.call(ctx, server, client) }()
+			select {
+			case err := <-done:
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("error = %v, want %v", err, context.DeadlineExceeded)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("call did not return after its context expired")
+			}
+			// the sessions themselves are unaffected
+			if _, err := client.Lookup(server.Addr().Base32()); err != nil {
+				t.Errorf("Lookup() after cancelled call error = %v", err)
+			}
+		})
+	}
+}
+
+func TestStreamSession_LookupCached(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	pub, _ := samtest.NewDestination()
+	b.AddName("site.i2p", pub)
+	session := newTestSession(t, b, "lookup")
+	hellos := func() (n int) {
+		for _, cmd := range b.Commands() {
+			if strings.HasPrefix(cmd, "HELLO") {
+				n++
+			}
+		}
+		return n
+	}
+	before := hellos()
+
+	for i := 0; i < 3; i++ {
+		addr, err := session.Lookup("site.i2p")
+		if err != nil || string(addr) != pub {
+			t.Fatalf("Lookup() = %.16s, %v, want the destination", addr, err)
+		}
+	}
+	if _, err := session.Lookup("missing.i2p"); !errors.Is(err, common.ErrKeyNotFound) {
+		t.Errorf("Lookup() of an unknown name error = %v, want ErrKeyNotFound", err)
+	}
+	if n := hellos() - before; n != 0 {
+		t.Errorf("lookups opened %d new connections, want none", n)
+	}
+	n := 0
+	for _, cmd := range b.Commands() {
+		if cmd == "NAMING LOOKUP NAME=site.i2p" {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("bridge saw %d lookups of site.i2p, want 1", n)
+	}
+}
+
+func TestStreamSession_DialConfiguredResolver(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	server := newTestSession(t, b, "server")
+	listener, err := server.Listen()
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	commonSam, err := common.NewSAM(b.Addr(), common.SetResolver(common.StaticResolver{"server.i2p": server.Addr()}))
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	keys, err := commonSam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	client, err := (&SAM{SAM: commonSam}).NewStreamSession("client", keys, nil)
+	if err != nil {
+		t.Fatalf("NewStreamSession() error = %v", err)
+	}
+	defer client.Close()
+	conn, err := client.Dial("tcp", "server.i2p:80")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	conn.Close()
+	for _, cmd := range b.Commands() {
+		if strings.HasPrefix(cmd, "NAMING LOOKUP") {
+			t.Errorf("bridge saw %q, want the configured resolver to answer", cmd)
+		}
+	}
+}
+
+func TestStreamSession_DialPorts(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	server := newTestSession(t, b, "server")
+	client := newTestSession(t, b, "client")
+	listener, err := server.Listen()
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan *StreamConn)
+	go func() {
+		for {
+			conn, err := listener.AcceptI2P()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	tests := []struct {
+		name     string
+		dial     func() (*StreamConn, error)
+		from, to int
+	}{
+		{"session ports", func() (*StreamConn, error) { return client.DialI2P(server.Addr()) }, 0, 0},
+		{"explicit ports", func() (*StreamConn, error) { return client.DialI2PWithPorts(server.Addr(), 1234, 80) }, 1234, 80},
+		{"address port", func() (*StreamConn, error) {
+			conn, err := client.DialContext(context.Background(), "tcp", server.Addr().Base32()+":8080")
+			if err != nil {
+				return nil, err
+			}
+			return conn.(*StreamConn), nil
+		}, 0, 8080},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := tt.dial()
+			if err != nil {
+				t.Fatalf("dial error = %v", err)
+			}
+			defer conn.Close()
+			sconn, ok := <-accepted
+			if !ok {
+				t.Fatal("AcceptI2P() failed")
+			}
+			defer sconn.Close()
+
+			want := []struct {
+				name string
+				got  net.Addr
+				want common.I2PAddrPort
+			}{
+				{"dialed LocalAddr()", conn.LocalAddr(), common.I2PAddrPort{Addr: client.Addr(), Port: tt.from}},
+				{"dialed RemoteAddr()", conn.RemoteAddr(), common.I2PAddrPort{Addr: server.Addr(), Port: tt.to}},
+				{"accepted LocalAddr()", sconn.LocalAddr(), common.I2PAddrPort{Addr: server.Addr(), Port: tt.to}},
+				{"accepted RemoteAddr()", sconn.RemoteAddr(), common.I2PAddrPort{Addr: client.Addr(), Port: tt.from}},
+			}
+			for _, w := range want {
+				if w.got != w.want {
+					t.Errorf("%s = %v, want %v", w.name, w.got, w.want)
+				}
+			}
+			if server.From() != "0" || server.To() != "0" {
+				t.Errorf("session ports = %s/%s after accepting, want 0/0", server.From(), server.To())
+			}
+		})
+	}
+
+	if _, err := client.DialI2PWithPorts(server.Addr(), 0, 70000); err == nil {
+		t.Error("DialI2PWithPorts() accepted port 70000")
+	}
+}
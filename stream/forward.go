@@ -0,0 +1,317 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/i2pkeys"
+	"github.com/sirupsen/logrus"
+)
+
+// ForwardOptions are the options of STREAM FORWARD.
+type ForwardOptions struct {
+	// SSL makes the bridge connect to the target with TLS
+	SSL bool
+	// Silent leaves out the line with the peer's destination and ports
+	// the bridge otherwise sends first on each forwarded connection
+	Silent bool
+}
+
+// StreamForward is a STREAM FORWARD of a session: the bridge accepts the
+// session's streams itself and connects each one to a local TCP server.
+// Forwarding lasts until the StreamForward or the session is closed.
+type StreamForward struct {
+	sam  *common.SAM
+	addr string
+}
+
+// Forward makes the bridge forward the streams the session accepts to
+// host:port. An empty host is the address the bridge sees this side
+// connect from. The forwarded connections start with a line holding the
+// peer's destination and ports, unless opts.Silent is set; ListenForward
+// strips it.
+func (s *StreamSession) Forward(host string, port int, opts ForwardOptions) (*StreamForward, error) {
+	ctx, cancel := s.ContextWithTimeout()
+	defer cancel()
+	return s.ForwardContext(ctx, host, port, opts)
+}
+
+// ForwardContext is like Forward, but gives up when ctx is done.
+func (s *StreamSession) ForwardContext(ctx context.Context, host string, port int, opts ForwardOptions) (*StreamForward, error) {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	log.WithFields(logrus.Fields{"id": s.ID(), "addr": addr, "ssl": opts.SSL, "silent": opts.Silent}).Debug("Forward called")
+	if port < 1 || port > 65535 {
+		return nil, fmt.Errorf("Invalid forwarding port %d", port)
+	}
+	cmd := "STREAM FORWARD " + s.ID() + " PORT=" + strconv.Itoa(port)
+	if host != "" {
+		cmd += " HOST=" + host
+	}
+	cmd += " SILENT=" + strconv.FormatBool(opts.Silent)
+	if opts.SSL {
+		cmd += " SSL=true"
+	}
+	sam, err := s.RedialContext(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to create new SAM instance")
+		return nil, err
+	}
+	reply, err := sam.CommandContext(ctx, cmd)
+	if err != nil {
+		log.WithError(err).Error("Failed to send STREAM FORWARD command")
+		sam.Conn.Close()
+		return nil, err
+	}
+	if !reply.Is("STREAM", "STATUS") {
+		log.WithField("reply", reply.String()).Error("Unexpected reply to STREAM FORWARD")
+		sam.Conn.Close()
+		return nil, common.UnexpectedReply(reply)
+	}
+	if err := reply.Err(); err != nil {
+		log.WithFields(logrus.Fields{
+			"result":  reply.Result(),
+			"message": reply.Get("MESSAGE"),
+		}).Error("Failed to forward streams")
+		sam.Conn.Close()
+		return nil, err
+	}
+	// the control socket only ends the forwarding from now on
+	sam.Conn.SetDeadline(time.Time{})
+	log.WithField("addr", addr).Debug("Forwarding streams")
+	return &StreamForward{sam: sam, addr: addr}, nil
+}
+
+// Close ends the forwarding. Forwarded connections stay open.
+func (f *StreamForward) Close() error {
+	log.WithField("addr", f.addr).Debug("Closing StreamForward")
+	return f.sam.Conn.Close()
+}
+
+// forwardHeaderTimeout bounds the wait for the destination line of a
+// forwarded connection, which the bridge sends as soon as it connects.
+const forwardHeaderTimeout = 10 * time.Second
+
+// ForwardListener is a net.Listener for the streams a session forwards to a
+// local TCP listener. Connections which are not from the bridge are
+// refused. Each connection's destination line is read in its own
+// goroutine, so a slow one does not hold up the others.
+type ForwardListener struct {
+	session  *StreamSession
+	listener net.Listener
+	forward  *StreamForward
+	bridge   net.IP
+	// conns receives the connections whose destination line was read
+	conns  chan *StreamConn
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mu guards err, the error which ended accepting
+	mu  sync.Mutex
+	err error
+}
+
+// ErrForwardHost is returned by ListenForward when the control connection
+// is not a TCP connection straight to the bridge, as with a Unix socket or
+// a Dialer: the address the bridge reaches this side at is unknown then.
+// ListenForwardOn takes it instead.
+var ErrForwardHost = errors.New("control connection is not TCP to the SAM bridge, the host to forward to is unknown")
+
+// ListenForward listens on a local TCP port and makes the bridge forward
+// the session's streams to it. Unlike a StreamListener, it needs no command
+// to the bridge for each connection. It fails with ErrForwardHost unless
+// the control connection is a TCP connection to the bridge.
+func (s *StreamSession) ListenForward() (*ForwardListener, error) {
+	ctx, cancel := s.ContextWithTimeout()
+	defer cancel()
+	return s.ListenForwardContext(ctx)
+}
+
+// ListenForwardContext is like ListenForward, but gives up when ctx is
+// done.
+func (s *StreamSession) ListenForwardContext(ctx context.Context) (*ForwardListener, error) {
+	local, bridge, err := s.forwardHosts()
+	if err != nil {
+		log.WithError(err).Error("Cannot listen for forwarded streams")
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(local.String(), "0"))
+	if err != nil {
+		log.WithError(err).Error("Failed to listen on TCP")
+		return nil, err
+	}
+	return s.listenForward(ctx, listener, local.String(), bridge)
+}
+
+// ListenForwardOn makes the bridge forward the session's streams to
+// listener, a TCP listener the bridge reaches at host and the listener's
+// port. It is for bridges behind a Unix socket, an SSH tunnel or a proxy,
+// where ListenForward cannot tell the host. Connections are not checked to
+// come from the bridge. Closing the ForwardListener, or failing, closes
+// listener.
+func (s *StreamSession) ListenForwardOn(listener net.Listener, host string) (*ForwardListener, error) {
+	ctx, cancel := s.ContextWithTimeout()
+	defer cancel()
+	return s.ListenForwardOnContext(ctx, listener, host)
+}
+
+// ListenForwardOnContext is like ListenForwardOn, but gives up when ctx is
+// done.
+func (s *StreamSession) ListenForwardOnContext(ctx context.Context, listener net.Listener, host string) (*ForwardListener, error) {
+	if host == "" {
+		listener.Close()
+		return nil, fmt.Errorf("%w: empty host", ErrForwardHost)
+	}
+	return s.listenForward(ctx, listener, host, nil)
+}
+
+// forwardHosts returns the address of this side and that of the bridge, as
+// seen on the control connection. They are only known when it is a TCP
+// connection straight to the bridge.
+func (s *StreamSession) forwardHosts() (local, bridge net.IP, err error) {
+	laddr, lok := s.Conn.LocalAddr().(*net.TCPAddr)
+	raddr, rok := s.Conn.RemoteAddr().(*net.TCPAddr)
+	if s.SAMEmit.Dialer != nil || !lok || !rok {
+		return nil, nil, ErrForwardHost
+	}
+	return laddr.IP, raddr.IP, nil
+}
+
+// listenForward makes the bridge forward to host and the port of listener.
+// Connections from other addresses than bridge are refused, unless bridge
+// is nil.
+func (s *StreamSession) listenForward(ctx context.Context, listener net.Listener, host string, bridge net.IP) (*ForwardListener, error) {
+	addr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		listener.Close()
+		return nil, fmt.Errorf("forwarded streams need a TCP listener, not %s", listener.Addr().Network())
+	}
+	forward, err := s.ForwardContext(ctx, host, addr.Port, ForwardOptions{})
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	lctx, cancel := context.WithCancel(context.Background())
+	l := &ForwardListener{
+		session:  s,
+		listener: listener,
+		forward:  forward,
+		bridge:   bridge,
+		conns:    make(chan *StreamConn),
+		ctx:      lctx,
+		cancel:   cancel,
+	}
+	go l.acceptLoop()
+	return l, nil
+}
+
+// implements net.Listener
+func (l *ForwardListener) Addr() net.Addr {
+	return l.session.Addr()
+}
+
+// Close ends the forwarding and closes the TCP listener. Connections whose
+// destination line was not read yet are closed.
+func (l *ForwardListener) Close() error {
+	l.cancel()
+	err := l.forward.Close()
+	if lerr := l.listener.Close(); err == nil {
+		err = lerr
+	}
+	return err
+}
+
+// implements net.Listener
+func (l *ForwardListener) Accept() (net.Conn, error) {
+	return l.AcceptI2P()
+}
+
+// AcceptI2P returns the next forwarded stream, with the peer's destination
+// and the ports read from its first line. Connections without a valid line
+// are closed and skipped.
+func (l *ForwardListener) AcceptI2P() (*StreamConn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.err != nil {
+			return nil, l.err
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+// acceptLoop accepts the forwarded connections and reads their destination
+// lines in the background until the TCP listener fails or is closed.
+func (l *ForwardListener) acceptLoop() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if l.ctx.Err() == nil {
+				log.WithError(err).Error("Failed to accept forwarded connection")
+				l.mu.Lock()
+				l.err = err
+				l.mu.Unlock()
+			}
+			l.cancel()
+			return
+		}
+		go l.serve(conn)
+	}
+}
+
+// serve reads the destination line of conn and hands it to AcceptI2P.
+func (l *ForwardListener) serve(conn net.Conn) {
+	// closing the listener interrupts the handshake
+	stop := context.AfterFunc(l.ctx, func() { conn.Close() })
+	sconn, err := l.handshake(conn)
+	if !stop() {
+		return
+	}
+	if err != nil {
+		log.WithError(err).WithField("remote", conn.RemoteAddr()).Warn("Dropping forwarded connection")
+		conn.Close()
+		return
+	}
+	select {
+	case l.conns <- sconn:
+	case <-l.ctx.Done():
+		sconn.Close()
+	}
+}
+
+// handshake reads the destination line of a forwarded connection.
+func (l *ForwardListener) handshake(conn net.Conn) (*StreamConn, error) {
+	if l.bridge != nil {
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && !addr.IP.Equal(l.bridge) {
+			return nil, fmt.Errorf("connection from %s is not from the bridge", addr.IP)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(forwardHeaderTimeout))
+	r := common.NewMessageReader(conn)
+	line, err := r.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	dest, err := i2pkeys.NewI2PAddrFromString(common.ExtractDest(line))
+	if err != nil {
+		return nil, err
+	}
+	// the peer's FROM_PORT is its port, TO_PORT ours
+	from := common.ExtractPairInt(line, "FROM_PORT")
+	to := common.ExtractPairInt(line, "TO_PORT")
+	log.WithFields(logrus.Fields{"dest": dest.Base32(), "from": from, "to": to}).Debug("Accepted forwarded I2P connection")
+	return &StreamConn{
+		laddr: common.I2PAddrPort{Addr: l.session.Addr(), Port: to},
+		raddr: common.I2PAddrPort{Addr: dest, Port: from},
+		conn:  r.Conn(),
+	}, nil
+}
@@ -0,0 +1,225 @@
+package stream
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/go-sam-go/samtest"
+)
+
+func TestStreamSession_ListenForward(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	b.SetConnectTimeout(500 * time.Millisecond)
+
+	server := newTestSession(t, b, "server")
+	client := newTestSession(t, b, "client")
+	listener, err := server.ListenForward()
+	if err != nil {
+		t.Fatalf("ListenForward() error = %v", err)
+	}
+	defer listener.Close()
+	if _, err := server.Forward("", 1, ForwardOptions{}); err == nil {
+		t.Error("second Forward() succeeded")
+	}
+
+	conn, err := client.DialI2PWithPorts(server.Addr(), 1234, 80)
+	if err != nil {
+		t.Fatalf("DialI2PWithPorts() error = %v", err)
+	}
+	defer conn.Close()
+	// sent before the listener reads the destination line
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	sconn, err := listener.AcceptI2P()
+	if err != nil {
+		t.Fatalf("AcceptI2P() error = %v", err)
+	}
+	defer sconn.Close()
+
+	if got, want := sconn.RemoteAddr(), (common.I2PAddrPort{Addr: client.Addr(), Port: 1234}); got != want {
+		t.Errorf("RemoteAddr() = %v, want %v", got, want)
+	}
+	if got, want := sconn.LocalAddr(), (common.I2PAddrPort{Addr: server.Addr(), Port: 80}); got != want {
+		t.Errorf("LocalAddr() = %v, want %v", got, want)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(sconn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("ReadFull() = %q, %v, want ping", buf, err)
+	}
+	if _, err := sconn.Write([]byte("pong")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("ReadFull() = %q, %v, want pong", buf, err)
+	}
+
+	if err := listener.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if conn, err := client.DialI2P(server.Addr()); err == nil {
+		conn.Close()
+		t.Error("DialI2P() succeeded after the forwarding ended")
+	}
+}
+
+func TestStreamSession_ForwardSilent(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	server := newTestSession(t, b, "server")
+	client := newTestSession(t, b, "client")
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer tcp.Close()
+	forward, err := server.Forward("127.0.0.1", tcp.Addr().(*net.TCPAddr).Port, ForwardOptions{Silent: true})
+	if err != nil {
+		t.Fatalf("Forward() error = %v", err)
+	}
+	defer forward.Close()
+
+	conn, err := client.DialI2P(server.Addr())
+	if err != nil {
+		t.Fatalf("DialI2P() error = %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	forwarded, err := tcp.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer forwarded.Close()
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(forwarded, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("silent forward read %q, %v, want ping without a destination line", buf, err)
+	}
+
+	for _, port := range []int{0, 65536} {
+		if _, err := client.Forward("", port, ForwardOptions{}); err == nil {
+			t.Errorf("Forward() accepted port %d", port)
+		}
+	}
+}
+
+func TestForwardListener_SlowHandshake(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	server := newTestSession(t, b, "server")
+	client := newTestSession(t, b, "client")
+	listener, err := server.ListenForward()
+	if err != nil {
+		t.Fatalf("ListenForward() error = %v", err)
+	}
+	defer listener.Close()
+
+	// a connection which never sends its destination line
+	stalled, err := net.Dial("tcp", listener.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer stalled.Close()
+	conn, err := client.DialI2P(server.Addr())
+	if err != nil {
+		t.Fatalf("DialI2P() error = %v", err)
+	}
+	defer conn.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		sconn, err := listener.AcceptI2P()
+		if err == nil {
+			sconn.Close()
+		}
+		accepted <- err
+	}()
+	select {
+	case err := <-accepted:
+		if err != nil {
+			t.Fatalf("AcceptI2P() error = %v", err)
+		}
+	case <-time.After(forwardHeaderTimeout / 2):
+		t.Fatal("AcceptI2P() waited for a stalled connection")
+	}
+
+	if err := listener.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := listener.AcceptI2P(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("AcceptI2P() after Close() error = %v, want net.ErrClosed", err)
+	}
+	// the stalled handshake was abandoned
+	stalled.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := stalled.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read() on the stalled connection after Close() error = %v, want EOF", err)
+	}
+}
+
+func TestStreamSession_ListenForwardOn(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	// through a Dialer, the host the bridge can connect back to is unknown
+	commonSam, err := common.NewSAM(b.Addr(), common.SetSAMDialer(&net.Dialer{}))
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	keys, err := commonSam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	server, err := (&SAM{SAM: commonSam}).NewStreamSession("server", keys, nil)
+	if err != nil {
+		t.Fatalf("NewStreamSession() error = %v", err)
+	}
+	defer server.Close()
+	client := newTestSession(t, b, "client")
+
+	if _, err := server.ListenForward(); !errors.Is(err, ErrForwardHost) {
+		t.Fatalf("ListenForward() error = %v, want ErrForwardHost", err)
+	}
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	listener, err := server.ListenForwardOn(tcp, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("ListenForwardOn() error = %v", err)
+	}
+	defer listener.Close()
+
+	conn, err := client.DialI2P(server.Addr())
+	if err != nil {
+		t.Fatalf("DialI2P() error = %v", err)
+	}
+	defer conn.Close()
+	sconn, err := listener.AcceptI2P()
+	if err != nil {
+		t.Fatalf("AcceptI2P() error = %v", err)
+	}
+	defer sconn.Close()
+	if got := sconn.RemoteAddr().(common.I2PAddrPort).Addr; got != client.Addr() {
+		t.Errorf("RemoteAddr() = %s, want %s", got.Base32(), client.Addr().Base32())
+	}
+}
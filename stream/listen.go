@@ -1,6 +1,9 @@
 package stream
 
-import "github.com/sirupsen/logrus"
+import (
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/sirupsen/logrus"
+)
 
 // create a new stream listener to accept inbound connections
 func (s *StreamSession) Listen() (*StreamListener, error) {
@@ -9,3 +12,26 @@ func (s *StreamSession) Listen() (*StreamListener, error) {
 		session: s,
 	}, nil
 }
+
+// ListenPool creates a stream listener which keeps size STREAM ACCEPTs
+// pending in the background, so bursts of peers do not wait for a new
+// connection to the bridge each. Accepted connections wait for AcceptI2P
+// in the place of their STREAM ACCEPT, which is sent again only once
+// AcceptI2P returned them: at most size streams are ever accepted but not
+// returned.
+// After a failed STREAM ACCEPT, whose error AcceptI2P returns, the pool
+// waits according to backoff, a zero backoff being common.DefaultBackoff.
+// Bridges older than SAM 3.2 get a single pending STREAM ACCEPT.
+func (s *StreamSession) ListenPool(size int, backoff common.Backoff) (*StreamListener, error) {
+	l, err := s.Listen()
+	if err != nil || size <= 0 {
+		return l, err
+	}
+	if size > 1 && !s.Supports(common.FeatureConcurrentAccept) {
+		log.WithField("version", s.Version()).Warn("Bridge does not accept concurrently, using a pool of one")
+		size = 1
+	}
+	log.WithFields(logrus.Fields{"id": s.ID(), "size": size}).Debug("Starting accept pool")
+	l.pool = newAcceptPool(l, size, backoff)
+	return l, nil
+}
@@ -1,11 +1,8 @@
 package stream
 
 import (
-	"bufio"
-	"errors"
-	"io"
+	"context"
 	"net"
-	"strings"
 
 	"github.com/sirupsen/logrus"
 
@@ -13,12 +10,15 @@ import (
 	"github.com/go-i2p/i2pkeys"
 )
 
+// From returns the FROM_PORT of the session. The ports of an accepted
+// connection are those of its addresses.
 func (l *StreamListener) From() string {
-	return l.session.Fromport
+	return l.session.From()
 }
 
+// To returns the TO_PORT of the session.
 func (l *StreamListener) To() string {
-	return l.session.Toport
+	return l.session.To()
 }
 
 // get our address
@@ -29,6 +29,9 @@ func (l *StreamListener) Addr() net.Addr {
 
 // implements net.Listener
 func (l *StreamListener) Close() error {
+	if l.pool != nil {
+		l.pool.close()
+	}
 	return l.session.Close()
 }
 
@@ -39,47 +42,62 @@ func (l *StreamListener) Accept() (net.Conn, error) {
 
 // accept a new inbound connection
 func (l *StreamListener) AcceptI2P() (*StreamConn, error) {
+	ctx := l.session.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return l.AcceptI2PContext(ctx)
+}
+
+// AcceptI2PContext is like AcceptI2P, but gives up waiting for a peer when
+// ctx is done. The pending accept socket is closed in that case, unless the
+// listener has an accept pool.
+func (l *StreamListener) AcceptI2PContext(ctx context.Context) (*StreamConn, error) {
 	log.Debug("StreamListener.AcceptI2P() called")
-	s, err := common.NewSAM(l.session.Sam())
+	if l.pool != nil {
+		return l.pool.accept(ctx)
+	}
+	return l.accept(ctx)
+}
+
+// accept sends STREAM ACCEPT on a new connection to the bridge and waits
+// for a peer.
+func (l *StreamListener) accept(ctx context.Context) (*StreamConn, error) {
+	s, err := l.session.RedialContext(ctx)
 	if err == nil {
 		log.Debug("Connected to SAM bridge")
 		// we connected to sam
-		// send accept() command
-		_, err = io.WriteString(s.Conn, "STREAM ACCEPT ID="+l.session.ID()+" SILENT=false\n")
+		// send accept() command and read the reply
+		reply, err := s.CommandContext(ctx, "STREAM ACCEPT "+l.session.ID()+" SILENT=false")
 		if err != nil {
 			log.WithError(err).Error("Failed to send STREAM ACCEPT command")
 			s.Close()
 			return nil, err
 		}
-		// read reply
-		rd := bufio.NewReader(s.Conn)
-		// read first line
-		line, err := rd.ReadString(10)
-		if err != nil {
-			log.WithError(err).Error("Failed to read SAM bridge response")
+		log.WithField("response", reply.String()).Debug("Received SAM bridge response")
+		if !reply.Is("STREAM", "STATUS") {
+			log.WithField("line", reply.String()).Error("Invalid SAM response")
 			s.Close()
-			return nil, err
+			return nil, common.UnexpectedReply(reply)
 		}
-		log.WithField("response", line).Debug("Received SAM bridge response")
-		log.Println(line)
-		if strings.HasPrefix(line, "STREAM STATUS RESULT=OK") {
+		if reply.OK() {
 			// we gud read destination line
-			destline, err := rd.ReadString(10)
+			destline, err := s.ReadLineContext(ctx)
 			if err == nil {
 				dest := common.ExtractDest(destline)
-				l.session.Fromport = common.ExtractPairString(destline, "FROM_PORT")
-				l.session.Toport = common.ExtractPairString(destline, "TO_PORT")
+				// the peer's FROM_PORT is its port, TO_PORT ours
+				from := common.ExtractPairInt(destline, "FROM_PORT")
+				to := common.ExtractPairInt(destline, "TO_PORT")
 				// return wrapped connection
-				dest = strings.Trim(dest, "\n")
 				log.WithFields(logrus.Fields{
 					"dest": dest,
-					"from": l.From(),
-					"to":   l.To(),
+					"from": from,
+					"to":   to,
 				}).Debug("Accepted new I2P connection")
 				return &StreamConn{
-					laddr: l.session.Addr(),
-					raddr: i2pkeys.I2PAddr(dest),
-					conn:  s.Conn,
+					laddr: common.I2PAddrPort{Addr: l.session.Addr(), Port: to},
+					raddr: common.I2PAddrPort{Addr: i2pkeys.I2PAddr(dest), Port: from},
+					conn:  s.DataConn(),
 				}, nil
 			} else {
 				log.WithError(err).Error("Failed to read destination line")
@@ -87,13 +105,12 @@ func (l *StreamListener) AcceptI2P() (*StreamConn, error) {
 				return nil, err
 			}
 		} else {
-			log.WithField("line", line).Error("Invalid SAM response")
+			log.WithField("line", reply.String()).Error("STREAM ACCEPT failed")
 			s.Close()
-			return nil, errors.New("invalid sam line: " + line)
+			return nil, reply.Err()
 		}
 	} else {
 		log.WithError(err).Error("Failed to connect to SAM bridge")
-		s.Close()
 		return nil, err
 	}
 }
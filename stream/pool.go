@@ -0,0 +1,145 @@
+package stream
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/common"
+)
+
+// acceptPool keeps STREAM ACCEPTs of a StreamListener pending and queues
+// what they return.
+type acceptPool struct {
+	listener *StreamListener
+	backoff  common.Backoff
+	ctx      context.Context
+	cancel   context.CancelFunc
+	// slots holds a token for each pending STREAM ACCEPT and each queued
+	// result, so there are never more than its capacity
+	slots   chan struct{}
+	results chan acceptResult
+	wg      sync.WaitGroup
+	// done is closed once every worker stopped
+	done chan struct{}
+	once sync.Once
+}
+
+type acceptResult struct {
+	conn *StreamConn
+	err  error
+}
+
+func newAcceptPool(l *StreamListener, size int, backoff common.Backoff) *acceptPool {
+	parent := l.session.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	p := &acceptPool{
+		listener: l,
+		backoff:  backoff,
+		ctx:      ctx,
+		cancel:   cancel,
+		slots:    make(chan struct{}, size),
+		results:  make(chan acceptResult, size),
+		done:     make(chan struct{}),
+	}
+	p.wg.Add(size)
+	for i := 0; i < size; i++ {
+		go p.worker()
+	}
+	go func() {
+		p.wg.Wait()
+		close(p.done)
+	}()
+	return p
+}
+
+// worker keeps one STREAM ACCEPT pending, whenever a slot is free, until
+// the pool is closed or the session dies.
+func (p *acceptPool) worker() {
+	defer p.wg.Done()
+	for attempt := 0; ; {
+		select {
+		case p.slots <- struct{}{}:
+		case <-p.ctx.Done():
+			return
+		}
+		conn, err := p.listener.accept(p.ctx)
+		if p.ctx.Err() != nil {
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		select {
+		case p.results <- acceptResult{conn, err}:
+		case <-p.ctx.Done():
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if err == nil {
+			attempt = 0
+			continue
+		}
+		select {
+		case <-p.listener.session.Done():
+			log.WithError(err).Debug("Session died, stopping accept pool worker")
+			return
+		default:
+		}
+		t := time.NewTimer(p.backoff.Delay(attempt))
+		select {
+		case <-t.C:
+		case <-p.ctx.Done():
+			t.Stop()
+			return
+		}
+		attempt++
+	}
+}
+
+// accept returns the next queued connection or error, freeing its slot.
+func (p *acceptPool) accept(ctx context.Context) (*StreamConn, error) {
+	select {
+	case r := <-p.results:
+		<-p.slots
+		return r.conn, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.done:
+	}
+	// workers may have queued results before stopping
+	select {
+	case r := <-p.results:
+		return r.conn, r.err
+	default:
+	}
+	if err := p.listener.session.Err(); err != nil {
+		return nil, err
+	}
+	return nil, net.ErrClosed
+}
+
+// close stops the workers, closing their pending STREAM ACCEPTs and the
+// connections nobody accepted.
+func (p *acceptPool) close() {
+	p.once.Do(func() {
+		p.cancel()
+		<-p.done
+		for {
+			select {
+			case r := <-p.results:
+				if r.conn != nil {
+					r.conn.Close()
+				}
+			default:
+				return
+			}
+		}
+	})
+}
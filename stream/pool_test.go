@@ -0,0 +1,110 @@
+package stream
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/go-sam-go/samtest"
+)
+
+// pendingAccepts counts the STREAM ACCEPTs the bridge received.
+func pendingAccepts(b *samtest.Bridge) int {
+	n := 0
+	for _, cmd := range b.Commands() {
+		if strings.HasPrefix(cmd, "STREAM ACCEPT ") {
+			n++
+		}
+	}
+	return n
+}
+
+func waitAccepts(t *testing.T, b *samtest.Bridge, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for pendingAccepts(b) < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("bridge received %d STREAM ACCEPTs, want %d", pendingAccepts(b), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStreamSession_ListenPool(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	b.SetConnectTimeout(200 * time.Millisecond)
+
+	const size = 4
+	server := newTestSession(t, b, "server")
+	client := newTestSession(t, b, "client")
+	listener, err := server.ListenPool(size, common.Backoff{})
+	if err != nil {
+		t.Fatalf("ListenPool() error = %v", err)
+	}
+	waitAccepts(t, b, size)
+
+	// nobody accepts: the queued streams take the place of the pending
+	// STREAM ACCEPTs, which are not sent again
+	var dialed []*StreamConn
+	for i := 0; i < size; i++ {
+		conn, err := client.DialI2P(server.Addr())
+		if err != nil {
+			t.Fatalf("DialI2P() %d error = %v", i, err)
+		}
+		defer conn.Close()
+		dialed = append(dialed, conn)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := pendingAccepts(b); got != size {
+		t.Errorf("bridge received %d STREAM ACCEPTs with a full queue, want %d", got, size)
+	}
+	if conn, err := client.DialI2P(server.Addr()); err == nil {
+		conn.Close()
+		t.Error("DialI2P() succeeded while the pool was full")
+	}
+
+	for i := range dialed {
+		conn, err := listener.AcceptI2P()
+		if err != nil {
+			t.Fatalf("AcceptI2P() %d error = %v", i, err)
+		}
+		conn.Close()
+	}
+	// accepting replenished the pool
+	waitAccepts(t, b, 2*size)
+
+	if err := listener.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := listener.AcceptI2P(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("AcceptI2P() after Close() error = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestStreamSession_ListenPool_OldBridge(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+	b.SetVersion("3.1")
+
+	server := newTestSession(t, b, "server")
+	listener, err := server.ListenPool(4, common.Backoff{})
+	if err != nil {
+		t.Fatalf("ListenPool() error = %v", err)
+	}
+	defer listener.Close()
+	waitAccepts(t, b, 1)
+	time.Sleep(50 * time.Millisecond)
+	if got := pendingAccepts(b); got != 1 {
+		t.Errorf("bridge received %d STREAM ACCEPTs from a SAM 3.1 pool, want 1", got)
+	}
+}
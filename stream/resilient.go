@@ -0,0 +1,280 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/i2pkeys"
+	"github.com/sirupsen/logrus"
+)
+
+// ResilientStreamSession is a StreamSession which creates itself again,
+// with the same ID, keys and options, when the router restarts or the
+// bridge stops answering. Its address never changes, so services keep
+// running without a restart.
+type ResilientStreamSession struct {
+	supervisor *common.Supervisor
+	keys       i2pkeys.I2PKeys
+	id         string
+}
+
+// NewResilientStreamSession creates a stream session like NewStreamSession
+// and keeps it alive, creating it again with backoff whenever its control
+// connection is lost. A zero backoff uses common.DefaultBackoff.
+func (sam *SAM) NewResilientStreamSession(id string, keys i2pkeys.I2PKeys, options []string, backoff common.Backoff) (*ResilientStreamSession, error) {
+	log.WithFields(logrus.Fields{"id": id, "options": options}).Debug("Creating new ResilientStreamSession")
+	first, err := sam.NewStreamSession(id, keys, options)
+	if err != nil {
+		return nil, err
+	}
+	base := first.SAM.SAM
+	return Resilient(first, func(ctx context.Context) (*StreamSession, error) {
+		next, err := base.ReconnectContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return (&SAM{SAM: next}).NewStreamSessionContext(ctx, id, keys, options)
+	}, backoff), nil
+}
+
+// Resilient keeps first alive by calling create whenever its control
+// connection is lost. create must return a session with the same ID and
+// keys as first.
+func Resilient(first *StreamSession, create func(ctx context.Context) (*StreamSession, error), backoff common.Backoff) *ResilientStreamSession {
+	s := &ResilientStreamSession{
+		keys: first.Keys(),
+		id:   strings.TrimPrefix(first.ID(), "ID="),
+	}
+	s.supervisor = common.NewSupervisor(first, s.recreate(create), backoff)
+	return s
+}
+
+// ResilientSubSession is like Resilient, for the sub-session id of a
+// primary session, which create adds again through the primary session.
+// Sessions are closed with remove rather than Close, which would close the
+// control connection of the primary session.
+func ResilientSubSession(first *StreamSession, id string, create func(ctx context.Context) (*StreamSession, error), remove func(*StreamSession) error, backoff common.Backoff) *ResilientStreamSession {
+	s := &ResilientStreamSession{
+		keys: first.Keys(),
+		id:   id,
+	}
+	s.supervisor = common.NewSupervisorWithClose(first, s.recreate(create), func(session common.Session) error {
+		return remove(session.(*StreamSession))
+	}, backoff)
+	return s
+}
+
+// recreate wraps create to carry the Timeout and Deadline of the current
+// session over.
+func (s *ResilientStreamSession) recreate(create func(ctx context.Context) (*StreamSession, error)) func(ctx context.Context) (common.Session, error) {
+	return func(ctx context.Context) (common.Session, error) {
+		previous := s.Session()
+		next, err := create(ctx)
+		if err != nil {
+			return nil, err
+		}
+		next.Timeout = previous.Timeout
+		next.Deadline = previous.Deadline
+		return next, nil
+	}
+}
+
+// Session returns the current StreamSession. It may be dead while a new one
+// is being created.
+func (s *ResilientStreamSession) Session() *StreamSession {
+	return s.supervisor.Current().(*StreamSession)
+}
+
+// wait returns the current StreamSession once it is alive.
+func (s *ResilientStreamSession) wait(ctx context.Context) (*StreamSession, error) {
+	session, err := s.supervisor.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return session.(*StreamSession), nil
+}
+
+// ID returns the session ID, which is kept across re-creations.
+func (s *ResilientStreamSession) ID() string {
+	return s.id
+}
+
+// Keys returns the keys of the session, which are kept across re-creations.
+func (s *ResilientStreamSession) Keys() i2pkeys.I2PKeys {
+	return s.keys
+}
+
+// Addr returns the I2P destination of the session.
+func (s *ResilientStreamSession) Addr() i2pkeys.I2PAddr {
+	return s.keys.Addr()
+}
+
+func (s *ResilientStreamSession) LocalAddr() net.Addr {
+	return s.Addr()
+}
+
+// Dial connects to addr like StreamSession.Dial. While the session is being
+// created again, Dial waits for it within the session's Timeout and
+// Deadline.
+func (s *ResilientStreamSession) Dial(n, addr string) (net.Conn, error) {
+	ctx, cancel := s.Session().dialContext(context.Background())
+	defer cancel()
+	session, err := s.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return session.DialContext(ctx, n, addr)
+}
+
+// DialContext is like Dial, but gives up when ctx is done.
+func (s *ResilientStreamSession) DialContext(ctx context.Context, n, addr string) (net.Conn, error) {
+	ctx, cancel := s.Session().dialContext(ctx)
+	defer cancel()
+	session, err := s.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return session.DialContext(ctx, n, addr)
+}
+
+// DialI2P is like StreamSession.DialI2P, waiting for the session like Dial.
+func (s *ResilientStreamSession) DialI2P(addr i2pkeys.I2PAddr) (*StreamConn, error) {
+	return s.DialI2PContext(context.Background(), addr)
+}
+
+// DialI2PContext is like DialI2P, but gives up when ctx is done.
+func (s *ResilientStreamSession) DialI2PContext(ctx context.Context, addr i2pkeys.I2PAddr) (*StreamConn, error) {
+	ctx, cancel := s.Session().dialContext(ctx)
+	defer cancel()
+	session, err := s.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return session.DialI2PContext(ctx, addr)
+}
+
+// DialI2PWithPorts is like StreamSession.DialI2PWithPorts, waiting for the
+// session like Dial.
+func (s *ResilientStreamSession) DialI2PWithPorts(addr i2pkeys.I2PAddr, from, to int) (*StreamConn, error) {
+	return s.DialI2PWithPortsContext(context.Background(), addr, from, to)
+}
+
+// DialI2PWithPortsContext is like DialI2PWithPorts, but gives up when ctx
+// is done.
+func (s *ResilientStreamSession) DialI2PWithPortsContext(ctx context.Context, addr i2pkeys.I2PAddr, from, to int) (*StreamConn, error) {
+	ctx, cancel := s.Session().dialContext(ctx)
+	defer cancel()
+	session, err := s.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return session.DialI2PWithPortsContext(ctx, addr, from, to)
+}
+
+// Lookup resolves name through the current session.
+func (s *ResilientStreamSession) Lookup(name string) (i2pkeys.I2PAddr, error) {
+	ctx, cancel := s.Session().ContextWithTimeout()
+	defer cancel()
+	session, err := s.wait(ctx)
+	if err != nil {
+		return i2pkeys.I2PAddr(""), err
+	}
+	return session.LookupContext(ctx, name)
+}
+
+// Listen returns a listener whose Accept survives the session being created
+// again.
+func (s *ResilientStreamSession) Listen() (*ResilientStreamListener, error) {
+	log.WithFields(logrus.Fields{"id": s.ID(), "laddr": s.Addr()}).Debug("Creating new ResilientStreamListener")
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ResilientStreamListener{
+		session: s,
+		ctx:     ctx,
+		cancel:  cancel,
+	}, nil
+}
+
+// Done is closed once the session is closed for good.
+func (s *ResilientStreamSession) Done() <-chan struct{} {
+	return s.supervisor.Done()
+}
+
+// Close stops re-creating the session and closes it.
+func (s *ResilientStreamSession) Close() error {
+	log.WithField("id", s.ID()).Debug("Closing ResilientStreamSession")
+	return s.supervisor.Close()
+}
+
+// ResilientStreamListener accepts connections on a ResilientStreamSession.
+// An Accept blocked when the session is lost carries on once the session
+// has been created again.
+type ResilientStreamListener struct {
+	session *ResilientStreamSession
+	ctx     context.Context
+	cancel  context.CancelFunc
+	once    sync.Once
+}
+
+// implements net.Listener
+func (l *ResilientStreamListener) Addr() net.Addr {
+	return l.session.Addr()
+}
+
+// Close unblocks pending Accepts. Unlike StreamListener.Close it leaves the
+// session open.
+func (l *ResilientStreamListener) Close() error {
+	l.once.Do(l.cancel)
+	return nil
+}
+
+// implements net.Listener
+func (l *ResilientStreamListener) Accept() (net.Conn, error) {
+	return l.AcceptI2P()
+}
+
+// AcceptI2P accepts the next inbound connection.
+func (l *ResilientStreamListener) AcceptI2P() (*StreamConn, error) {
+	return l.AcceptI2PContext(context.Background())
+}
+
+// AcceptI2PContext is like AcceptI2P, but gives up when ctx is done.
+func (l *ResilientStreamListener) AcceptI2PContext(ctx context.Context) (*StreamConn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(l.ctx, cancel)
+	defer stop()
+	supervisor := l.session.supervisor
+	for {
+		session, err := l.session.wait(ctx)
+		if err != nil {
+			return nil, l.closed(err)
+		}
+		listener, err := session.Listen()
+		if err != nil {
+			return nil, err
+		}
+		conn, err := listener.AcceptI2PContext(ctx)
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, l.closed(ctx.Err())
+		}
+		if !supervisor.Lost(ctx, session, err) {
+			return nil, err
+		}
+		log.WithError(err).Debug("Accept interrupted by lost session, retrying")
+	}
+}
+
+// closed turns the error of an Accept aborted by Close into net.ErrClosed.
+func (l *ResilientStreamListener) closed(err error) error {
+	if l.ctx.Err() != nil && errors.Is(err, context.Canceled) {
+		return net.ErrClosed
+	}
+	return err
+}
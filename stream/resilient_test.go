@@ -0,0 +1,149 @@
+package stream
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/go-sam-go/samtest"
+)
+
+var fastBackoff = common.Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+
+func TestResilientStreamSession_AcceptSurvivesRestart(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	commonSam, err := common.NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	keys, err := commonSam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	server, err := (&SAM{SAM: commonSam}).NewResilientStreamSession("server", keys, nil, fastBackoff)
+	if err != nil {
+		t.Fatalf("NewResilientStreamSession() error = %v", err)
+	}
+	defer server.Close()
+	if got := server.ID(); got != "server" {
+		t.Errorf("ID() = %q, want %q", got, "server")
+	}
+	client := newTestSession(t, b, "client")
+
+	listener, err := server.Listen()
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan *StreamConn, 1)
+	go func() {
+		conn, err := listener.AcceptI2P()
+		if err != nil {
+			t.Errorf("AcceptI2P() error = %v", err)
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	first := server.Session()
+	// the first attempt to create the session again is rejected
+	b.Script("SESSION CREATE", `SESSION STATUS RESULT=I2P_ERROR MESSAGE="router starting"`)
+	if err := b.Disconnect("server"); err != nil {
+		t.Fatalf("Disconnect() error = %v", err)
+	}
+	select {
+	case <-first.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("lost session was not noticed")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var conn *StreamConn
+	for conn == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("could not reach the re-created session")
+		}
+		conn, err = client.DialI2P(server.Addr())
+		if err != nil {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	defer conn.Close()
+	if server.Session() == first {
+		t.Error("Session() still returns the lost session")
+	}
+	if server.Addr() != keys.Addr() {
+		t.Errorf("Addr() = %s, want %s", server.Addr().Base32(), keys.Addr().Base32())
+	}
+
+	var sconn *StreamConn
+	select {
+	case sconn = <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked AcceptI2P() did not resume")
+	}
+	if sconn == nil {
+		return
+	}
+	defer sconn.Close()
+	if _, err := conn.Write([]byte("back")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(sconn, buf); err != nil {
+		t.Fatalf("ReadFull() error = %v", err)
+	}
+	if string(buf) != "back" {
+		t.Errorf("accepted conn read %q, want %q", buf, "back")
+	}
+}
+
+func TestResilientStreamListener_Close(t *testing.T) {
+	b, err := samtest.NewBridge()
+	if err != nil {
+		t.Fatalf("NewBridge() error = %v", err)
+	}
+	defer b.Close()
+
+	commonSam, err := common.NewSAM(b.Addr())
+	if err != nil {
+		t.Fatalf("NewSAM() error = %v", err)
+	}
+	keys, err := commonSam.NewKeys()
+	if err != nil {
+		t.Fatalf("NewKeys() error = %v", err)
+	}
+	server, err := (&SAM{SAM: commonSam}).NewResilientStreamSession("server", keys, nil, fastBackoff)
+	if err != nil {
+		t.Fatalf("NewResilientStreamSession() error = %v", err)
+	}
+	listener, _ := server.Listen()
+	done := make(chan error, 1)
+	go func() {
+		_, err := listener.Accept()
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	server.Close()
+	listener.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Accept() after Close() succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept() did not return after Close()")
+	}
+	select {
+	case <-server.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done() was not closed")
+	}
+}
@@ -34,6 +34,14 @@ func (s *StreamSession) SetWriteDeadline(t time.Time) error {
 	return s.Conn.SetWriteDeadline(t)
 }
 
+// ID returns the ID of the session as sent in commands, "ID=name".
+func (s *StreamSession) ID() string {
+	if s.SubSessionID != "" {
+		return "ID=" + s.SubSessionID
+	}
+	return s.SAM.ID()
+}
+
 func (s *StreamSession) From() string {
 	return s.Fromport
 }
@@ -42,8 +50,8 @@ func (s *StreamSession) To() string {
 	return s.Toport
 }
 
-func (s *StreamSession) SignatureType() string {
-	return s.SignatureType()
+func (s *StreamSession) SignatureType() common.SigType {
+	return s.SigType
 }
 
 func (s *StreamSession) Close() error {
@@ -53,7 +61,7 @@ func (s *StreamSession) Close() error {
 
 // Returns the I2P destination (the address) of the stream session
 func (s *StreamSession) Addr() i2pkeys.I2PAddr {
-	return s.Addr()
+	return s.DestinationKeys.Addr()
 }
 
 func (s *StreamSession) LocalAddr() net.Addr {
@@ -67,20 +75,23 @@ func (s *StreamSession) Keys() i2pkeys.I2PKeys {
 
 // lookup name, convenience function
 func (s *StreamSession) Lookup(name string) (i2pkeys.I2PAddr, error) {
+	ctx, cancel := s.ContextWithTimeout()
+	defer cancel()
+	return s.LookupContext(ctx, name)
+}
+
+// LookupContext is like Lookup, but gives up when ctx is done. The lookup
+// is sent on the session's control connection and answered from the SAM's
+// Cache when possible.
+func (s *StreamSession) LookupContext(ctx context.Context, name string) (i2pkeys.I2PAddr, error) {
 	log.WithField("name", name).Debug("Looking up address")
-	sam, err := common.NewSAM(s.Sam())
-	if err == nil {
-		addr, err := sam.Lookup(name)
-		defer sam.Close()
-		if err != nil {
-			log.WithError(err).Error("Lookup failed")
-		} else {
-			log.WithField("addr", addr).Debug("Lookup successful")
-		}
-		return addr, err
+	addr, err := s.SAM.LookupContext(ctx, name)
+	if err != nil {
+		log.WithError(err).Error("Lookup failed")
+		return i2pkeys.I2PAddr(""), err
 	}
-	log.WithError(err).Error("Failed to create SAM instance for lookup")
-	return i2pkeys.I2PAddr(""), err
+	log.WithField("addr", addr).Debug("Lookup successful")
+	return addr, nil
 }
 
 /*
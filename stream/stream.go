@@ -1,6 +1,9 @@
 package stream
 
 import (
+	"context"
+
+	"github.com/go-i2p/go-sam-go/common"
 	"github.com/go-i2p/i2pkeys"
 	"github.com/sirupsen/logrus"
 )
@@ -8,8 +11,16 @@ import (
 // Creates a new StreamSession with the I2CP- and streaminglib options as
 // specified. See the I2P documentation for a full list of options.
 func (sam *SAM) NewStreamSession(id string, keys i2pkeys.I2PKeys, options []string) (*StreamSession, error) {
+	ctx, cancel := sam.ContextWithTimeout()
+	defer cancel()
+	return sam.NewStreamSessionContext(ctx, id, keys, options)
+}
+
+// NewStreamSessionContext is like NewStreamSession, but gives up when ctx is
+// done.
+func (sam *SAM) NewStreamSessionContext(ctx context.Context, id string, keys i2pkeys.I2PKeys, options []string) (*StreamSession, error) {
 	log.WithFields(logrus.Fields{"id": id, "options": options}).Debug("Creating new StreamSession")
-	conn, err := sam.NewGenericSession("STREAM", id, keys, []string{})
+	conn, err := sam.NewGenericSessionContext(ctx, "STREAM", id, keys, options)
 	if err != nil {
 		return nil, err
 	}
@@ -23,9 +34,17 @@ func (sam *SAM) NewStreamSession(id string, keys i2pkeys.I2PKeys, options []stri
 
 // Creates a new StreamSession with the I2CP- and streaminglib options as
 // specified. See the I2P documentation for a full list of options.
-func (sam *SAM) NewStreamSessionWithSignature(id string, keys i2pkeys.I2PKeys, options []string, sigType string) (*StreamSession, error) {
+func (sam *SAM) NewStreamSessionWithSignature(id string, keys i2pkeys.I2PKeys, options []string, sigType common.SigType) (*StreamSession, error) {
+	ctx, cancel := sam.ContextWithTimeout()
+	defer cancel()
+	return sam.NewStreamSessionWithSignatureContext(ctx, id, keys, options, sigType)
+}
+
+// NewStreamSessionWithSignatureContext is like NewStreamSessionWithSignature,
+// but gives up when ctx is done.
+func (sam *SAM) NewStreamSessionWithSignatureContext(ctx context.Context, id string, keys i2pkeys.I2PKeys, options []string, sigType common.SigType) (*StreamSession, error) {
 	log.WithFields(logrus.Fields{"id": id, "options": options, "sigType": sigType}).Debug("Creating new StreamSession with signature")
-	conn, err := sam.NewGenericSessionWithSignature("STREAM", id, keys, sigType, []string{})
+	conn, err := sam.NewGenericSessionWithSignatureAndPortsContext(ctx, "STREAM", id, "0", "0", keys, sigType, options)
 	if err != nil {
 		return nil, err
 	}
@@ -40,9 +59,17 @@ func (sam *SAM) NewStreamSessionWithSignature(id string, keys i2pkeys.I2PKeys, o
 
 // Creates a new StreamSession with the I2CP- and streaminglib options as
 // specified. See the I2P documentation for a full list of options.
-func (sam *SAM) NewStreamSessionWithSignatureAndPorts(id, from, to string, keys i2pkeys.I2PKeys, options []string, sigType string) (*StreamSession, error) {
+func (sam *SAM) NewStreamSessionWithSignatureAndPorts(id, from, to string, keys i2pkeys.I2PKeys, options []string, sigType common.SigType) (*StreamSession, error) {
+	ctx, cancel := sam.ContextWithTimeout()
+	defer cancel()
+	return sam.NewStreamSessionWithSignatureAndPortsContext(ctx, id, from, to, keys, options, sigType)
+}
+
+// NewStreamSessionWithSignatureAndPortsContext is like
+// NewStreamSessionWithSignatureAndPorts, but gives up when ctx is done.
+func (sam *SAM) NewStreamSessionWithSignatureAndPortsContext(ctx context.Context, id, from, to string, keys i2pkeys.I2PKeys, options []string, sigType common.SigType) (*StreamSession, error) {
 	log.WithFields(logrus.Fields{"id": id, "from": from, "to": to, "options": options, "sigType": sigType}).Debug("Creating new StreamSession with signature and ports")
-	conn, err := sam.NewGenericSessionWithSignatureAndPorts("STREAM", id, from, to, keys, sigType, []string{})
+	conn, err := sam.NewGenericSessionWithSignatureAndPortsContext(ctx, "STREAM", id, from, to, keys, sigType, options)
 	if err != nil {
 		return nil, err
 	}
@@ -1,9 +1,13 @@
 package stream
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/go-i2p/go-sam-go/common"
+	"github.com/go-i2p/go-sam-go/samtest"
 	"github.com/go-i2p/i2pkeys"
 )
 
@@ -54,3 +58,96 @@ func TestNewStreamSession_Integration(t *testing.T) {
 		})
 	}
 }
+
+func TestNewStreamSession_Options(t *testing.T) {
+	options := []string{"inbound.length=1", "outbound.length=1", "i2p.streaming.maxWindowSize=64"}
+	tests := []struct {
+		name   string
+		create func(sam *SAM, keys i2pkeys.I2PKeys) (*StreamSession, error)
+	}{
+		{"NewStreamSession", func(sam *SAM, keys i2pkeys.I2PKeys) (*StreamSession, error) {
+			return sam.NewStreamSession("opts", keys, options)
+		}},
+		{"NewStreamSessionWithSignature", func(sam *SAM, keys i2pkeys.I2PKeys) (*StreamSession, error) {
+			return sam.NewStreamSessionWithSignature("opts", keys, options, common.SIG_DEFAULT)
+		}},
+		{"NewStreamSessionWithSignatureAndPorts", func(sam *SAM, keys i2pkeys.I2PKeys) (*StreamSession, error) {
+			return sam.NewStreamSessionWithSignatureAndPorts("opts", "0", "0", keys, options, common.SIG_DEFAULT)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := samtest.NewBridge()
+			if err != nil {
+				t.Fatalf("NewBridge() error = %v", err)
+			}
+			defer b.Close()
+			commonSam, err := common.NewSAM(b.Addr())
+			if err != nil {
+				t.Fatalf("NewSAM() error = %v", err)
+			}
+			keys, err := commonSam.NewKeys()
+			if err != nil {
+				t.Fatalf("NewKeys() error = %v", err)
+			}
+			session, err := tt.create(&SAM{SAM: commonSam}, keys)
+			if err != nil {
+				t.Fatalf("%s() error = %v", tt.name, err)
+			}
+			defer session.Close()
+			var create string
+			for _, cmd := range b.Commands() {
+				if strings.HasPrefix(cmd, "SESSION CREATE ") {
+					create = cmd + " "
+				}
+			}
+			for _, opt := range options {
+				if !strings.Contains(create, " "+opt+" ") {
+					t.Errorf("SESSION CREATE %q lacks %s", create, opt)
+				}
+			}
+			if strings.Contains(create, " inbound.length=0 ") {
+				t.Errorf("SESSION CREATE %q has the configured inbound.length too", create)
+			}
+		})
+	}
+}
+
+func TestNewStreamSession_ContextCancelled(t *testing.T) {
+	tests := []struct {
+		name   string
+		create func(ctx context.Context, sam *SAM, keys i2pkeys.I2PKeys) (*StreamSession, error)
+	}{
+		{"NewStreamSessionContext", func(ctx context.Context, sam *SAM, keys i2pkeys.I2PKeys) (*StreamSession, error) {
+			return sam.NewStreamSessionContext(ctx, "cancelled", keys, nil)
+		}},
+		{"NewStreamSessionWithSignatureContext", func(ctx context.Context, sam *SAM, keys i2pkeys.I2PKeys) (*StreamSession, error) {
+			return sam.NewStreamSessionWithSignatureContext(ctx, "cancelled", keys, nil, common.SIG_DEFAULT)
+		}},
+		{"NewStreamSessionWithSignatureAndPortsContext", func(ctx context.Context, sam *SAM, keys i2pkeys.I2PKeys) (*StreamSession, error) {
+			return sam.NewStreamSessionWithSignatureAndPortsContext(ctx, "cancelled", "0", "0", keys, nil, common.SIG_DEFAULT)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := samtest.NewBridge()
+			if err != nil {
+				t.Fatalf("NewBridge() error = %v", err)
+			}
+			defer b.Close()
+			commonSam, err := common.NewSAM(b.Addr())
+			if err != nil {
+				t.Fatalf("NewSAM() error = %v", err)
+			}
+			keys, err := commonSam.NewKeys()
+			if err != nil {
+				t.Fatalf("NewKeys() error = %v", err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if _, err := tt.create(ctx, &SAM{SAM: commonSam}, keys); !errors.Is(err, context.Canceled) {
+				t.Errorf("%s() error = %v, want %v", tt.name, err, context.Canceled)
+			}
+		})
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"time"
 
 	"github.com/go-i2p/go-sam-go/common"
-	"github.com/go-i2p/i2pkeys"
 )
 
 type SAM struct {
@@ -17,15 +16,22 @@ type StreamSession struct {
 	*SAM
 	Timeout  time.Duration
 	Deadline time.Time
+	// SubSessionID is the ID of a sub-session of a primary session, which
+	// shares the SAM of the primary session and so not its ID
+	SubSessionID string
 }
 
 type StreamListener struct {
 	// parent stream session
 	session *StreamSession
+	// pool keeps STREAM ACCEPTs pending, nil for one at a time
+	pool *acceptPool
 }
 
 type StreamConn struct {
-	laddr i2pkeys.I2PAddr
-	raddr i2pkeys.I2PAddr
+	// laddr and raddr hold the ports of this connection, which may differ
+	// from the session's
+	laddr common.I2PAddrPort
+	raddr common.I2PAddrPort
 	conn  net.Conn
 }